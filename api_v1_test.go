@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+func (c *testClient) sendJSON(method, path string, body interface{}) (*http.Response, []byte) {
+	c.t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.request(method, path, "application/json", encoded)
+}
+
+// Decodes a model.ResponseFormatJSON whose data is a workout
+func decodeWorkoutResponse(t *testing.T, body []byte) (model.WorkoutResponseJSON, model.ResponseFormatJSON) {
+	t.Helper()
+	var workout model.WorkoutResponseJSON
+	resp := model.ResponseFormatJSON{Data: &workout}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("malformed response %s: %v", body, err)
+	}
+	return workout, resp
+}
+
+func firstWorkoutOf(t *testing.T, app *App, email string) model.Workout {
+	t.Helper()
+	var workout model.Workout
+	err := app.DB.Joins("JOIN users ON users.id = workouts.user_id").Where("users.email = ?", email).Order("workouts.id").First(&workout).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return workout
+}
+
+func TestRESTCreateWorkoutRespondsWithLocation(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+
+	resp, body := c.postJSON("/api/v1/workouts", model.CreateWorkoutRequestJSON{Kind: "pushups", Reps: 10, DurationSeconds: 60})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/v1/workouts: %d %s", resp.StatusCode, body)
+	}
+	created, _ := decodeWorkoutResponse(t, body)
+	location := resp.Header.Get("Location")
+	if want := "/api/v1/workouts/" + created.ID; location != want {
+		t.Fatalf("got Location %q, want %q", location, want)
+	}
+	// Appended to the end of the seeded list
+	if created.Order != 4 || created.Version != 1 || created.Reps != 10 {
+		t.Fatalf("created %+v", created)
+	}
+
+	resp, body = c.get(location)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", location, resp.StatusCode, body)
+	}
+	if fetched, _ := decodeWorkoutResponse(t, body); fetched != created {
+		t.Fatalf("got %+v, want %+v", fetched, created)
+	}
+}
+
+func TestRESTWorkoutsOfOtherUsersAreNotFound(t *testing.T) {
+	app := newTestApp(t, nil)
+	janes := firstWorkoutOf(t, app, testUserEmail)
+	createTestUser(t, app, "mallory", "mallory@example.com", "mallorypass")
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, "mallory@example.com", "mallorypass")
+
+	path := fmt.Sprintf("/api/v1/workouts/%d", janes.ID)
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		var body interface{}
+		if method == http.MethodPatch {
+			body = map[string]interface{}{"expected_version": janes.Version, "reps": 1}
+		}
+		resp, respBody := c.sendJSON(method, path, body)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s %s: got %d %s, want %d", method, path, resp.StatusCode, respBody, http.StatusNotFound)
+		}
+	}
+	if after := firstWorkoutOf(t, app, testUserEmail); after.Version != janes.Version || after.Reps != janes.Reps {
+		t.Fatalf("workout changed: %+v", after)
+	}
+}
+
+func TestRESTStaleVersionConflicts(t *testing.T) {
+	app := newTestApp(t, nil)
+	workout := firstWorkoutOf(t, app, testUserEmail)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	path := fmt.Sprintf("/api/v1/workouts/%d", workout.ID)
+
+	resp, body := c.sendJSON(http.MethodPatch, path, map[string]interface{}{"expected_version": workout.Version, "reps": 42})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH %s: %d %s", path, resp.StatusCode, body)
+	}
+	updated, _ := decodeWorkoutResponse(t, body)
+	if updated.Version != workout.Version+1 || updated.Reps != 42 {
+		t.Fatalf("updated %+v", updated)
+	}
+
+	assertConflict := func(resp *http.Response, body []byte) {
+		t.Helper()
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("%s %s: got %d %s, want %d", resp.Request.Method, path, resp.StatusCode, body, http.StatusConflict)
+		}
+		current, envelope := decodeWorkoutResponse(t, body)
+		if envelope.ErrorCode != string(apperror.CodeConflict) {
+			t.Fatalf("got error code %q", envelope.ErrorCode)
+		}
+		if current != updated {
+			t.Fatalf("conflict carries %+v, want the current workout %+v", current, updated)
+		}
+	}
+	assertConflict(c.sendJSON(http.MethodPatch, path, map[string]interface{}{"expected_version": workout.Version, "reps": 7}))
+	assertConflict(c.sendJSON(http.MethodDelete, fmt.Sprintf("%s?expected_version=%d", path, workout.Version), nil))
+}
+
+func TestRESTValidationErrors(t *testing.T) {
+	app := newTestApp(t, nil)
+	workout := firstWorkoutOf(t, app, testUserEmail)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	path := fmt.Sprintf("/api/v1/workouts/%d", workout.ID)
+
+	tests := []struct {
+		method, path string
+		body         interface{}
+		field        string
+	}{
+		{http.MethodPost, "/api/v1/workouts", map[string]interface{}{"kind": "pushups", "reps": 0, "duration_seconds": 60}, "reps"},
+		{http.MethodPost, "/api/v1/workouts", map[string]interface{}{"kind": "cartwheels", "reps": 10, "duration_seconds": 60}, "kind"},
+		{http.MethodPatch, path, map[string]interface{}{"reps": 10}, "expected_version"},
+		{http.MethodPatch, path, map[string]interface{}{"expected_version": workout.Version, "duration_seconds": -1}, "duration_seconds"},
+	}
+	for _, tc := range tests {
+		resp, body := c.sendJSON(tc.method, tc.path, tc.body)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("%s %s %v: got %d %s, want %d", tc.method, tc.path, tc.body, resp.StatusCode, body, http.StatusUnprocessableEntity)
+		}
+		var envelope struct {
+			ErrorCode   string                `json:"error_code"`
+			ErrorFields []apperror.FieldError `json:"error_fields"`
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.ErrorCode != string(apperror.CodeInvalidInput) || len(envelope.ErrorFields) != 1 || envelope.ErrorFields[0].Field != tc.field {
+			t.Fatalf("%s %s %v: got %s, want an error on %s", tc.method, tc.path, tc.body, body, tc.field)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -15,14 +19,19 @@ import (
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gorilla/mux"
+	"github.com/nrawrx3/workout-backend/auth"
 	"github.com/nrawrx3/workout-backend/config"
 	"github.com/nrawrx3/workout-backend/constants"
 	"github.com/nrawrx3/workout-backend/graph"
+	"github.com/nrawrx3/workout-backend/graph/loader"
 	bk_handler "github.com/nrawrx3/workout-backend/handler"
 	"github.com/nrawrx3/workout-backend/handler/middleware"
 	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/oidc"
+	"github.com/nrawrx3/workout-backend/openapi"
 	"github.com/nrawrx3/workout-backend/store"
 	"github.com/nrawrx3/workout-backend/util"
+	"github.com/nrawrx3/workout-backend/validation"
 	"gorm.io/gorm"
 
 	"github.com/rs/cors"
@@ -35,6 +44,9 @@ type App struct {
 	Cfg        *config.Config
 	Router     *mux.Router
 	HttpServer *http.Server
+	// Set by Init
+	Authenticator *auth.Authenticator
+	OpenAPIDoc    *openapi.Document
 }
 
 func NewApp(cfg *config.Config) (*App, error) {
@@ -64,17 +76,29 @@ func (app *App) Init(cfg *config.Config) error {
 
 	// Set up stores
 	userStore := store.NewUserStore(app.DB)
+	workoutStore := store.NewWorkoutStore(app.DB)
+	idempotencyStore := store.NewIdempotencyStore(app.DB)
+	twoFactorStore := store.NewTwoFactorStore(app.DB)
+	magicLinkStore := store.NewMagicLinkStore(app.DB)
+	identityStore := store.NewIdentityStore(app.DB)
 
 	// Router
 	router := mux.NewRouter()
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recover)
 
-	// Cipher we use for cookies
-	aesCipher, err := util.NewAESCipher(cfg.CookieSecretKey)
+	// Keys we encrypt cookies with
+	cookieKeys, err := cfg.CookieKeyring.HexKeys()
 	if err != nil {
 		return err
 	}
+	cookieKeyring, err := util.NewAESKeyring(cfg.CookieKeyring.PrimaryKeyID, cookieKeys, cfg.CookieSecretKey)
+	if err != nil {
+		return err
+	}
+	cookieKeyring.AcceptUnversionedCookiesUntil(cfg.AcceptUnversionedCookiesUntil)
+
+	csrfCfg := cfg.CSRF.WithDefaults()
 
 	// Set up CORS middleware
 	allowedOrigins := append([]string{}, cfg.Cors.AllowedOrigins...)
@@ -87,7 +111,12 @@ func (app *App) Init(cfg *config.Config) error {
 	corsObject := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowCredentials: true,
-		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With"},
+		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With", middleware.IdempotencyKeyHeader, csrfCfg.HeaderName},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead},
+		ExposedHeaders: []string{
+			middleware.IdempotentReplayedHeader, "Location", "Retry-After",
+			middleware.RateLimitLimitHeader, middleware.RateLimitRemainingHeader, middleware.RateLimitResetHeader,
+		},
 		// AllowOriginFunc: func(origin string) bool {
 		// 	log.Printf("received origin: %s", origin)
 		// 	return origin == "http://localhost:5180"
@@ -97,35 +126,125 @@ func (app *App) Init(cfg *config.Config) error {
 	cookieInfo := model.SessionCookieInfo{
 		CookieName: cfg.CookieName,
 		Secure:     true,
-		SecretKey:  cfg.CookieSecretKey,
 		SameSite:   http.SameSiteNoneMode,
-		Expires:    time.Now().Add(1 * time.Hour),
+		Lifetime:   1 * time.Hour,
 		HttpOnly:   true,
 		Domain:     cfg.CookieDomain,
 	}
 
-	sessionCheckMiddle := middleware.NewSessionChecker(userStore, cookieInfo, aesCipher)
+	sessionCheckMiddle := middleware.NewSessionChecker(userStore, cookieInfo, cookieKeyring)
+
+	clientIPs, err := middleware.NewClientIPResolver(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid rate_limit config: %w", err)
+	}
+	idempotencyMiddle := middleware.NewIdempotency(idempotencyStore, cfg.Idempotency.KeyTTL(), clientIPs)
+	go purgeExpiredIdempotencyKeys(idempotencyStore)
+
+	passwordHasher, err := util.NewPasswordHasher(cfg.PasswordHashing.Params())
+	if err != nil {
+		return fmt.Errorf("invalid password_hashing config: %w", err)
+	}
+	authenticator := auth.NewAuthenticator(userStore, twoFactorStore, cookieInfo, cookieKeyring, passwordHasher)
+	app.Authenticator = authenticator
+
+	totpCfg := cfg.TOTP.WithDefaults()
+	authenticator.TOTPIssuer = totpCfg.Issuer
+	if totpCfg.SecretKey != "" {
+		authenticator.TOTPCipher, err = util.NewAESCipher(totpCfg.SecretKey)
+		if err != nil {
+			return fmt.Errorf("invalid totp secret_key: %w", err)
+		}
+	}
 
-	loginHandler := bk_handler.NewLoginHandler(userStore, cookieInfo, aesCipher)
+	// Wraps the handlers of endpoints authenticated by the session cookie
+	csrfProtect := func(h http.Handler) http.Handler { return h }
+	if !csrfCfg.DisableTokenCheck {
+		authenticator.CSRFCookieName = csrfCfg.CookieName
+		csrfMiddle := middleware.NewCSRF(cfg.CookieName, csrfCfg.CookieName, csrfCfg.HeaderName)
+		csrfProtect = csrfMiddle.Handler
+	}
+
+	// Returns the middleware throttling the routes of the group
+	rateLimited := func(group string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler { return h }
+	}
+	// Lets graph.RateLimitFields throttle fields by a group of their own
+	rateLimitInContext := func(h http.Handler) http.Handler { return h }
+	if !cfg.RateLimit.Disabled {
+		rateLimiter := middleware.NewRateLimiter(middleware.NewMemoryRateLimitStore(), clientIPs)
+		limitOf := func(group string) middleware.RateLimit {
+			limits := cfg.RateLimit.Group(group)
+			return middleware.RateLimit{
+				Burst: limits.Burst,
+				Rate:  float64(limits.RequestsPerMinute) / 60,
+			}
+		}
+		rateLimited = func(group string) func(http.Handler) http.Handler {
+			return rateLimiter.Handler(group, limitOf(group))
+		}
+		rateLimitInContext = rateLimiter.InContext(limitOf)
+	}
+	limitREST := rateLimited(config.RateLimitGroupREST)
+	magicLinks, err := newMagicLinks(cfg.MagicLink, authenticator, magicLinkStore)
+	if err != nil {
+		return fmt.Errorf("invalid magic_link config: %w", err)
+	}
+	if magicLinks != nil {
+		go purgeExpiredMagicLinkTokens(magicLinkStore)
+	}
+	loginHandler := bk_handler.NewLoginHandler(userStore, authenticator, cookieInfo, cookieKeyring, magicLinks)
+	oidcLogins, oidcFrontendURL, err := newOIDCLogins(cfg.OIDC, authenticator, identityStore)
+	if err != nil {
+		return fmt.Errorf("invalid oidc config: %w", err)
+	}
+	oidcLoginHandler := bk_handler.NewOIDCLoginHandler(oidcLogins, oidcFrontendURL)
 
 	// Set up GraphQL handler
+	gqlCfg := cfg.GraphQL.WithDefaults()
+
+	resolver := graph.NewResolver(app.DB)
+	resolver.MaxPageSize = gqlCfg.MaxPageSize
+	resolver.Authenticator = authenticator
+	resolver.WorkoutValidator, err = validation.NewWorkoutValidator(cfg.WorkoutLimits)
+	if err != nil {
+		return err
+	}
+
 	srv := handler.New(graph.NewExecutableSchema(graph.Config{
-		Resolvers: &graph.Resolver{
-			DB: app.DB,
-		},
+		Resolvers:  resolver,
+		Complexity: graph.NewComplexityRoot(gqlCfg.MaxPageSize),
 	}))
 
 	srv.AddTransport(transport.Websocket{
 		KeepAlivePingInterval: 10 * time.Second,
 	})
 	srv.AddTransport(transport.Options{})
-	srv.AddTransport(transport.GET{})
+	if !csrfCfg.DisableGraphQLGET {
+		srv.AddTransport(transport.GET{})
+	}
 	srv.AddTransport(transport.POST{})
 	srv.AddTransport(transport.MultipartForm{})
 
+	srv.SetErrorPresenter(graph.ErrorPresenter)
+	srv.SetRecoverFunc(graph.RecoverFunc)
+
 	srv.SetQueryCache(lru.New(1000))
 
-	srv.Use(extension.Introspection{})
+	if !gqlCfg.DisableIntrospection {
+		srv.Use(extension.Introspection{})
+	}
+	srv.Use(extension.FixedComplexityLimit(gqlCfg.ComplexityLimit))
+	srv.Use(graph.DepthLimit{MaxDepth: gqlCfg.MaxDepth})
+	srv.Use(graph.RequireSession{PublicFields: map[string]bool{"login": true, "login_second_factor": true}})
+	// The same budget for guesses as the REST login endpoints
+	srv.Use(graph.RateLimitFields{
+		Fields: map[string]string{
+			"login":               config.RateLimitGroupLogin,
+			"login_second_factor": config.RateLimitGroupLogin,
+		},
+		Take: middleware.TakeRateLimitToken,
+	})
 	srv.Use(extension.AutomaticPersistedQuery{
 		Cache: lru.New(100),
 	})
@@ -134,10 +253,27 @@ func (app *App) Init(cfg *config.Config) error {
 
 	gqlSubRouter.Path(constants.GqlPlaygroundApiPath).HandlerFunc(playground.Handler("GraphQL playground", constants.GqlQueryApiPath))
 
-	router.Path(constants.LoginPath).Handler(corsObject.Handler(http.HandlerFunc(loginHandler.Login)))
-
+	router.Path(constants.LoginPath).Handler(corsObject.Handler(
+		rateLimited(config.RateLimitGroupLogin)(http.HandlerFunc(loginHandler.Login))))
+	router.Path(constants.LoginSecondFactorPath).Handler(corsObject.Handler(
+		rateLimited(config.RateLimitGroupLogin)(http.HandlerFunc(loginHandler.SecondFactor))))
+	router.Path(constants.LoginMagicLinkPath).Handler(corsObject.Handler(
+		rateLimited(config.RateLimitGroupLogin)(http.HandlerFunc(loginHandler.RequestMagicLink))))
+	router.Path(constants.LoginMagicLinkVerifyPath).Handler(corsObject.Handler(
+		rateLimited(config.RateLimitGroupLogin)(http.HandlerFunc(loginHandler.VerifyMagicLink))))
+	router.Path(constants.LoginOIDCPath).Handler(corsObject.Handler(
+		rateLimited(config.RateLimitGroupLogin)(http.HandlerFunc(oidcLoginHandler.Begin))))
+	router.Path(constants.LoginOIDCCallbackPath).Handler(corsObject.Handler(
+		rateLimited(config.RateLimitGroupLogin)(http.HandlerFunc(oidcLoginHandler.Callback))))
+
+	loaderMiddle := loader.Middleware(userStore, workoutStore)
+
+	// The session is optional so that the login mutation can be reached,
+	// graph.RequireSession rejects everything else
 	gqlSubRouter.Path(constants.GqlQueryApiPath).Handler(
-		corsObject.Handler(sessionCheckMiddle.Handler(srv)))
+		corsObject.Handler(middleware.GraphQLQueriesOnlyOverGET(csrfProtect(
+			sessionCheckMiddle.OptionalHandler(rateLimited(config.RateLimitGroupGraphQL)(rateLimitInContext(idempotencyMiddle.Handler(
+				middleware.ResponseWriterInContext(loaderMiddle(srv))))))))))
 
 	router.Path(constants.AmILoggedInPath).Handler(
 		corsObject.Handler(http.HandlerFunc(loginHandler.AmILoggedIn)))
@@ -145,7 +281,52 @@ func (app *App) Init(cfg *config.Config) error {
 	workoutsListHandler := bk_handler.NewWorkoutsListHandler(userStore)
 
 	router.Path(constants.WorkoutsListPath).Methods("GET").Handler(corsObject.Handler(sessionCheckMiddle.Handler(
-		http.HandlerFunc(workoutsListHandler.HandleGetWorkoutsList))))
+		limitREST(http.HandlerFunc(workoutsListHandler.HandleGetWorkoutsList)))))
+
+	// REST API. OPTIONS is allowed on every route so that CORS preflight
+	// requests reach the cors handler.
+	authenticated := func(h http.HandlerFunc) http.Handler {
+		return corsObject.Handler(csrfProtect(sessionCheckMiddle.Handler(limitREST(idempotencyMiddle.Handler(h)))))
+	}
+
+	workoutsHandler := bk_handler.NewWorkoutsHandler(workoutStore, resolver.WorkoutValidator, gqlCfg.MaxPageSize)
+	profileHandler := bk_handler.NewProfileHandler(userStore)
+
+	apiRouter := router.PathPrefix(constants.ApiV1Prefix).Subrouter()
+
+	apiRouter.Path(constants.ApiV1WorkoutsPath).Methods(http.MethodGet, http.MethodOptions).Handler(authenticated(workoutsHandler.List))
+	apiRouter.Path(constants.ApiV1WorkoutsPath).Methods(http.MethodPost).Handler(authenticated(workoutsHandler.Create))
+	apiRouter.Path(constants.ApiV1WorkoutsOrderPath).Methods(http.MethodGet, http.MethodOptions).Handler(authenticated(workoutsHandler.GetOrder))
+	apiRouter.Path(constants.ApiV1WorkoutsOrderPath).Methods(http.MethodPut).Handler(authenticated(workoutsHandler.PutOrder))
+	apiRouter.Path(constants.ApiV1WorkoutPath).Methods(http.MethodGet, http.MethodOptions).Handler(authenticated(workoutsHandler.Get))
+	apiRouter.Path(constants.ApiV1WorkoutPath).Methods(http.MethodPatch).Handler(authenticated(workoutsHandler.Patch))
+	apiRouter.Path(constants.ApiV1WorkoutPath).Methods(http.MethodDelete).Handler(authenticated(workoutsHandler.Delete))
+	apiRouter.Path(constants.ApiV1WorkoutMovePath).Methods(http.MethodPost, http.MethodOptions).Handler(authenticated(workoutsHandler.Move))
+
+	apiRouter.Path(constants.ApiV1ProfilePath).Methods(http.MethodGet, http.MethodOptions).Handler(authenticated(profileHandler.GetProfile))
+	apiRouter.Path(constants.ApiV1SessionsPath).Methods(http.MethodGet, http.MethodOptions).Handler(authenticated(profileHandler.ListSessions))
+	apiRouter.Path(constants.ApiV1SessionPath).Methods(http.MethodDelete, http.MethodOptions).Handler(authenticated(profileHandler.DeleteSession))
+
+	csrfHeader := csrfCfg.HeaderName
+	if csrfCfg.DisableTokenCheck {
+		csrfHeader = ""
+	}
+	openAPIDoc := bk_handler.OpenAPIDocument(cfg.CookieName, csrfHeader)
+	openAPIHandler, err := bk_handler.NewOpenAPIHandler(openAPIDoc)
+	if err != nil {
+		return err
+	}
+	router.Path(constants.OpenAPIPath).Methods(http.MethodGet, http.MethodOptions).Handler(corsObject.Handler(openAPIHandler))
+	app.OpenAPIDoc = openAPIDoc
+
+	// TestOpenAPIDocumentsEveryRoute fails on these, a build that got past
+	// it shouldn't refuse to start
+	undocumented, err := openapi.Undocumented(router, openAPIDoc, constants.GqlRootApiPrefix)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to check the openapi document against the router")
+	} else if len(undocumented) != 0 {
+		log.Warn().Strs("routes", undocumented).Msg("routes missing from the openapi document")
+	}
 
 	host := app.Cfg.Host
 	if host == "" {
@@ -156,6 +337,100 @@ func (app *App) Init(cfg *config.Config) error {
 	return nil
 }
 
+const idempotencyKeyPurgeInterval = 1 * time.Hour
+
+// Expired keys are ignored anyway, this only keeps the table from growing
+func purgeExpiredIdempotencyKeys(idempotencyStore *store.IdempotencyStore) {
+	ticker := time.NewTicker(idempotencyKeyPurgeInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		count, err := idempotencyStore.DeleteExpired(context.Background(), now)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to purge expired idempotency keys")
+			continue
+		}
+		log.Debug().Int64("count", count).Msg("purged expired idempotency keys")
+	}
+}
+
+// Returns nil if magic link login is off
+func newMagicLinks(cfg config.MagicLinkConfig, authenticator *auth.Authenticator, magicLinkStore *store.MagicLinkStore) (*auth.MagicLinks, error) {
+	if cfg.LinkURL == "" {
+		return nil, nil
+	}
+
+	var sender auth.MagicLinkSender
+	switch cfg.Sender {
+	case config.MagicLinkSenderLog, "":
+		sender = auth.LogMagicLinkSender{}
+	case config.MagicLinkSenderFile:
+		if cfg.FileSenderDir == "" {
+			return nil, errors.New("the file sender needs file_sender_dir")
+		}
+		sender = auth.FileMagicLinkSender{Dir: cfg.FileSenderDir}
+	default:
+		return nil, fmt.Errorf("unknown sender %q", cfg.Sender)
+	}
+
+	return auth.NewMagicLinks(authenticator, magicLinkStore, sender, cfg.LinkURL, cfg.TTL(), cfg.BindUserAgent)
+}
+
+// Provider names end up in URLs and in user_identities
+var oidcProviderNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Returns nil logins if no providers are configured
+func newOIDCLogins(cfg config.OIDCConfig, authenticator *auth.Authenticator, identityStore *store.IdentityStore) (*auth.OIDCLogins, *url.URL, error) {
+	if len(cfg.Providers) == 0 {
+		return nil, nil, nil
+	}
+
+	frontendURL, err := url.Parse(cfg.FrontendURL)
+	if err != nil || !frontendURL.IsAbs() {
+		return nil, nil, fmt.Errorf("frontend_url must be an absolute url, got %q", cfg.FrontendURL)
+	}
+	if baseURL, err := url.Parse(cfg.RedirectBaseURL); err != nil || !baseURL.IsAbs() {
+		return nil, nil, fmt.Errorf("redirect_base_url must be an absolute url, got %q", cfg.RedirectBaseURL)
+	}
+
+	providers := make(map[string]*oidc.Provider, len(cfg.Providers))
+	for _, providerCfg := range cfg.Providers {
+		if !oidcProviderNameRegex.MatchString(providerCfg.Name) {
+			return nil, nil, fmt.Errorf("provider name %q must be lowercase letters, digits, - and _", providerCfg.Name)
+		}
+		if _, ok := providers[providerCfg.Name]; ok {
+			return nil, nil, fmt.Errorf("duplicate provider %q", providerCfg.Name)
+		}
+		callbackPath := strings.Replace(constants.LoginOIDCCallbackPath, "{provider}", providerCfg.Name, 1)
+		provider, err := oidc.NewProvider(oidc.ProviderConfig{
+			IssuerURL:    providerCfg.IssuerURL,
+			ClientID:     providerCfg.ClientID,
+			ClientSecret: providerCfg.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(cfg.RedirectBaseURL, "/") + callbackPath,
+			Scopes:       providerCfg.Scopes,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("provider %q: %w", providerCfg.Name, err)
+		}
+		providers[providerCfg.Name] = provider
+	}
+	return auth.NewOIDCLogins(authenticator, identityStore, providers), frontendURL, nil
+}
+
+const magicLinkTokenPurgeInterval = 1 * time.Hour
+
+func purgeExpiredMagicLinkTokens(magicLinkStore *store.MagicLinkStore) {
+	ticker := time.NewTicker(magicLinkTokenPurgeInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		count, err := magicLinkStore.DeleteExpired(context.Background(), now)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to purge expired magic link tokens")
+			continue
+		}
+		log.Debug().Int64("count", count).Msg("purged expired magic link tokens")
+	}
+}
+
 func (app *App) RunServer(cfg *config.Config) error {
 	if cfg.UseSelfSignedTLS {
 		listenAddr := fmt.Sprintf("%s:%d", app.Cfg.Host, app.Cfg.TLSPort)
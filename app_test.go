@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/util"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testUserEmail    = "jane@example.com"
+	testUserPassword = "sigmamale"
+)
+
+// Migrates and seeds a database of its own and initializes the app with the
+// config of newTestConfig
+func newTestApp(t *testing.T, configure func(cfg *config.Config)) *App {
+	t.Helper()
+
+	cfg := newTestConfig(t, configure)
+	if err := store.RunDatabaseMigrations(cfg); err != nil {
+		if errors.Is(err, store.ErrFTS5Unavailable) {
+			t.Skip("run the tests with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DB.Logger = logger.Default.LogMode(logger.Silent)
+	if err := SeedDatabase(app.DB); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	app.Authenticator.LoginDelay = 0
+	return app
+}
+
+// A config with a database file of its own, adjusted by configure
+func newTestConfig(t *testing.T, configure func(cfg *config.Config)) *config.Config {
+	t.Helper()
+	cfg := &config.Config{
+		MigrationsPath:  "./db/migrations/",
+		CookieSecretKey: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		CookieName:      "WORKOUT",
+	}
+	cfg.Sqlite.File = filepath.Join(t.TempDir(), "test.sqlite3")
+	cfg.RateLimit.Disabled = true
+	cfg.TOTP.SecretKey = "2f2e2d2c2b2a292827262524232221201f1e1d1c1b1a19181716151413121110"
+	if configure != nil {
+		configure(cfg)
+	}
+	return cfg
+}
+
+func createTestUser(t *testing.T, app *App, userName, email, password string) model.User {
+	t.Helper()
+	passwordHash, err := util.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.User{UserName: userName, Email: email, PasswordHash: passwordHash}
+	if err := app.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// A browser: keeps cookies by their domain and path and echoes the CSRF token
+// cookie in the header. Redirects aren't followed.
+type testClient struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+	// Sent with every request
+	header http.Header
+	// Leaves out the CSRF token header, as a cross-site page would
+	omitCSRFHeader bool
+}
+
+// The session cookie is Secure, so the server speaks TLS for the jar to send
+// it back
+func newTestClient(t *testing.T, app *App) *testClient {
+	t.Helper()
+	server := httptest.NewTLSServer(app.Router)
+	t.Cleanup(server.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
+	client.Jar = jar
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &testClient{t: t, server: server, client: client}
+}
+
+func (c *testClient) request(method, path, contentType string, body []byte) (*http.Response, []byte) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token := c.cookie(config.DefaultCSRFCookieName); token != nil && !c.omitCSRFHeader {
+		req.Header.Set(config.DefaultCSRFHeaderName, token.Value)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, respBody
+}
+
+func (c *testClient) get(path string) (*http.Response, []byte) {
+	c.t.Helper()
+	return c.request(http.MethodGet, path, "", nil)
+}
+
+func (c *testClient) postJSON(path string, body interface{}) (*http.Response, []byte) {
+	c.t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.request(http.MethodPost, path, "application/json", encoded)
+}
+
+// Runs the graphql operation and decodes its data into v, failing on errors
+func (c *testClient) graphql(query string, variables map[string]interface{}, v interface{}) {
+	c.t.Helper()
+	if codes := c.graphqlErrors(query, variables, v); len(codes) != 0 {
+		c.t.Fatalf("graphql errors: %v", codes)
+	}
+}
+
+// Like graphql, returning the codes of the errors instead
+func (c *testClient) graphqlErrors(query string, variables map[string]interface{}, v interface{}) []string {
+	c.t.Helper()
+	_, body := c.postJSON("/gql/query", map[string]interface{}{"query": query, "variables": variables})
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		c.t.Fatalf("malformed graphql response %s: %v", body, err)
+	}
+	if v != nil && len(resp.Data) != 0 && string(resp.Data) != "null" {
+		if err := json.Unmarshal(resp.Data, v); err != nil {
+			c.t.Fatalf("failed to decode graphql data %s: %v", resp.Data, err)
+		}
+	}
+	var codes []string
+	for _, gqlErr := range resp.Errors {
+		codes = append(codes, gqlErr.Extensions.Code)
+	}
+	return codes
+}
+
+// The cookie the jar would send to the root path
+func (c *testClient) cookie(name string) *http.Cookie {
+	rootURL, _ := url.Parse(c.server.URL + "/")
+	for _, cookie := range c.client.Jar.Cookies(rootURL) {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// Checks the session from outside the path the login happened on
+func (c *testClient) assertLoggedIn(loggedIn bool) {
+	c.t.Helper()
+	_, body := c.get("/am-i-logged-in")
+	var resp struct {
+		Data struct {
+			LoggedIn bool `json:"logged_in"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		c.t.Fatalf("malformed response %s: %v", body, err)
+	}
+	if resp.Data.LoggedIn != loggedIn {
+		c.t.Fatalf("logged in: got %v, want %v", resp.Data.LoggedIn, loggedIn)
+	}
+
+	resp2, body := c.get("/api/v1/profile")
+	if wantStatus := map[bool]int{true: http.StatusOK, false: http.StatusUnauthorized}[loggedIn]; resp2.StatusCode != wantStatus {
+		c.t.Fatalf("GET /api/v1/profile: got %d %s, want %d", resp2.StatusCode, strings.TrimSpace(string(body)), wantStatus)
+	}
+}
@@ -0,0 +1,174 @@
+// Package apperror is the error model shared by the graphql resolvers and the
+// REST handlers. An *Error carries a machine readable code from constants
+// which is what clients see. Anything that isn't an *Error (or one of the
+// sentinels in constants) is treated as an internal error, and its message is
+// not sent to the client since it may contain database details.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+type Code string
+
+const (
+	CodeInternal             Code = constants.ResponseErrCodeUnexpectedServerError
+	CodeUnauthenticated      Code = constants.ResponseErrCodeUserNotLoggedIn
+	CodeNotFound             Code = constants.ResponseErrCodeNotFound
+	CodeInvalidInput         Code = constants.ResponseErrCodeInvalidInput
+	CodeForbidden            Code = constants.ResponseErrCodeForbidden
+	CodeConflict             Code = constants.ResponseErrCodeConflict
+	CodeWrongPassword        Code = constants.ResponseErrCodeWrongPassword
+	CodeMethodNotAllowed     Code = constants.ResponseErrCodeMethodNotAllowed
+	CodeUnsupportedMediaType Code = constants.ResponseErrCodeUnsupportedMediaType
+	// The CSRF token header is missing or doesn't match the cookie
+	CodeCSRFTokenMismatch Code = constants.ResponseErrCodeCSRFTokenMismatch
+	CodeRateLimited       Code = constants.ResponseErrCodeRateLimited
+	// The TOTP or recovery code is wrong, or was used already
+	CodeWrongSecondFactor Code = constants.ResponseErrCodeWrongSecondFactor
+)
+
+// Message sent in place of the real one for internal errors
+const internalErrorMessage = "unexpected server side error"
+
+// Points at an offending input field, so clients can show the message next to
+// it.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldError
+	// Extra machine readable information, e.g. the current server state of
+	// an entity for conflicts.
+	Details map[string]interface{}
+	// The underlying cause, for logging. Never sent to clients.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func Wrap(code Code, err error, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func NotFound(format string, args ...interface{}) *Error {
+	return New(CodeNotFound, format, args...)
+}
+
+func InvalidInput(format string, args ...interface{}) *Error {
+	return New(CodeInvalidInput, format, args...)
+}
+
+// Returns an invalid input error carrying all the given field errors
+func InvalidFields(fields []FieldError) *Error {
+	return &Error{Code: CodeInvalidInput, Message: "invalid input", Fields: fields}
+}
+
+func Unauthenticated(format string, args ...interface{}) *Error {
+	return New(CodeUnauthenticated, format, args...)
+}
+
+func Forbidden(format string, args ...interface{}) *Error {
+	return New(CodeForbidden, format, args...)
+}
+
+func Internal(err error) *Error {
+	return Wrap(CodeInternal, err, internalErrorMessage)
+}
+
+// Classifies err. An *Error anywhere in the chain wins, then the sentinel
+// errors from constants, and everything else is internal.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	switch {
+	case errors.Is(err, constants.ErrCodeNotFound):
+		return Wrap(CodeNotFound, err, "not found")
+	case errors.Is(err, constants.ErrCodeVersionConflict):
+		return Wrap(CodeConflict, err, "modified concurrently, refetch and retry")
+	case errors.Is(err, constants.ErrCodeAlreadyExists):
+		return Wrap(CodeConflict, err, "already exists")
+	case errors.Is(err, constants.ErrCodeInvalidValue):
+		return Wrap(CodeInvalidInput, err, clientMessage(err, constants.ErrCodeInvalidValue, "invalid value"))
+	case errors.Is(err, constants.ErrCodeWrongEnumString):
+		return Wrap(CodeInvalidInput, err, clientMessage(err, constants.ErrCodeWrongEnumString, "unknown enum value"))
+	case errors.Is(err, constants.ErrCodeMaxSizeExceeded):
+		return Wrap(CodeInvalidInput, err, clientMessage(err, constants.ErrCodeMaxSizeExceeded, "too large"))
+	}
+	return Internal(err)
+}
+
+// The sentinels are wrapped with a description of what was invalid, which is
+// what the client is told. The sentinel's own text is an internal code and is
+// left out, and a bare sentinel gets the fallback message.
+func clientMessage(err, sentinel error, fallback string) string {
+	code := sentinel.Error()
+	message := strings.ReplaceAll(err.Error(), code+": ", "")
+	message = strings.TrimSuffix(message, ": "+code)
+	if message == code {
+		return fallback
+	}
+	return message
+}
+
+func HTTPStatus(code Code) int {
+	switch code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeInvalidInput:
+		return http.StatusUnprocessableEntity
+	case CodeUnauthenticated, CodeWrongPassword, CodeWrongSecondFactor:
+		return http.StatusUnauthorized
+	case CodeForbidden, CodeCSRFTokenMismatch:
+		return http.StatusForbidden
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case CodeUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Returns the REST response body and status code for err
+func Response(err error) (int, model.ResponseFormatJSON) {
+	appErr := From(err)
+	resp := model.ResponseFormatJSON{
+		ErrorCode:    string(appErr.Code),
+		ErrorMessage: appErr.Message,
+	}
+	if len(appErr.Fields) != 0 {
+		resp.ErrorFields = appErr.Fields
+	}
+	return HTTPStatus(appErr.Code), resp
+}
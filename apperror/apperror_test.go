@@ -0,0 +1,66 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/constants"
+)
+
+func TestFrom(t *testing.T) {
+	appErr := Forbidden("not yours")
+	tests := []struct {
+		name    string
+		err     error
+		code    Code
+		message string
+	}{
+		{"app error", appErr, CodeForbidden, "not yours"},
+		{"wrapped app error", fmt.Errorf("resolving: %w", appErr), CodeForbidden, "not yours"},
+		{"not found", fmt.Errorf("workout 3: %w", constants.ErrCodeNotFound), CodeNotFound, "not found"},
+		{"version conflict", constants.ErrCodeVersionConflict, CodeConflict, "modified concurrently, refetch and retry"},
+		{"already exists", constants.ErrCodeAlreadyExists, CodeConflict, "already exists"},
+		{
+			"invalid value",
+			fmt.Errorf("%w: invalid id x, expected base-10 unsigned integer", constants.ErrCodeInvalidValue),
+			CodeInvalidInput,
+			"invalid id x, expected base-10 unsigned integer",
+		},
+		{
+			"invalid value wrapped again",
+			fmt.Errorf("moving workout: %w", fmt.Errorf("%w: empty order key", constants.ErrCodeInvalidValue)),
+			CodeInvalidInput,
+			"moving workout: empty order key",
+		},
+		{
+			"invalid value at the end",
+			fmt.Errorf("failed to decode base64 encoded cookie bytes: %w", constants.ErrCodeInvalidValue),
+			CodeInvalidInput,
+			"failed to decode base64 encoded cookie bytes",
+		},
+		{"bare invalid value", constants.ErrCodeInvalidValue, CodeInvalidInput, "invalid value"},
+		{"bare wrong enum string", constants.ErrCodeWrongEnumString, CodeInvalidInput, "unknown enum value"},
+		{"bare max size exceeded", constants.ErrCodeMaxSizeExceeded, CodeInvalidInput, "too large"},
+		{"internal", errors.New("no such table: workouts"), CodeInternal, internalErrorMessage},
+	}
+	for _, tc := range tests {
+		got := From(tc.err)
+		if got.Code != tc.code || got.Message != tc.message {
+			t.Errorf("%s: got %s %q, want %s %q", tc.name, got.Code, got.Message, tc.code, tc.message)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	status, resp := Response(InvalidFields([]FieldError{{Field: "reps", Message: "must be between 1 and 10, got 0"}}))
+	if status != http.StatusUnprocessableEntity || resp.ErrorCode != string(CodeInvalidInput) || resp.ErrorFields == nil {
+		t.Fatalf("got %d %+v", status, resp)
+	}
+
+	status, resp = Response(errors.New("database is locked"))
+	if status != http.StatusInternalServerError || resp.ErrorMessage != internalErrorMessage || resp.ErrorFields != nil {
+		t.Fatalf("got %d %+v", status, resp)
+	}
+}
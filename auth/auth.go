@@ -0,0 +1,219 @@
+// Package auth logs users in, shared by the /login handler and the graphql
+// login mutation. A login creates a row in user_sessions and writes its id,
+// encrypted, into the session cookie. Users with two factor authentication get
+// a challenge first, see two_factor.go. Passwordless login through emailed
+// links is in magic_link.go, and through OpenID Connect providers in oidc.go.
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/util"
+	"github.com/rs/zerolog/log"
+)
+
+type Authenticator struct {
+	userStore      *store.UserStore
+	twoFactorStore *store.TwoFactorStore
+	cookieInfo     model.SessionCookieInfo
+	keyring        *util.AESKeyring
+	passwordHasher *util.PasswordHasher
+	// Name of the double-submit CSRF token cookie written along with the
+	// session cookie. No token is issued if empty.
+	CSRFCookieName string
+	// Encrypts the TOTP secrets at rest. Users can't enroll while it's nil.
+	TOTPCipher *util.AESCipher
+	TOTPIssuer string
+	// Every password login waits this long, to slow down guessing
+	LoginDelay time.Duration
+	// The clock, replaceable for tests
+	Now func() time.Time
+}
+
+const DefaultLoginDelay = 2 * time.Second
+
+func NewAuthenticator(userStore *store.UserStore, twoFactorStore *store.TwoFactorStore, cookieInfo model.SessionCookieInfo, keyring *util.AESKeyring, passwordHasher *util.PasswordHasher) *Authenticator {
+	return &Authenticator{
+		userStore:      userStore,
+		twoFactorStore: twoFactorStore,
+		cookieInfo:     cookieInfo,
+		keyring:        keyring,
+		passwordHasher: passwordHasher,
+		LoginDelay:     DefaultLoginDelay,
+		Now:            time.Now,
+	}
+}
+
+// Outcome of a login with the right password. Users with two factor
+// authentication get a Challenge instead of a Session, to be answered through
+// CompleteSecondFactor.
+type LoginResult struct {
+	Session   model.UserSession
+	Challenge *SecondFactorChallenge
+}
+
+// Checks the credentials, then creates a session and writes its cookie to w
+func (a *Authenticator) Login(ctx context.Context, w http.ResponseWriter, credentials model.UserLoginRequestBody, userAgent string) (LoginResult, error) {
+	select {
+	case <-time.After(a.LoginDelay):
+	case <-ctx.Done():
+		return LoginResult{}, ctx.Err()
+	}
+
+	user, err := a.userStore.GetUserWithEmail(ctx, credentials.Email)
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		return LoginResult{}, apperror.NotFound("no user with given email")
+	}
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	// Users created through an identity provider have no password
+	if user.PasswordHash == "" {
+		log.Debug().Str("auth", "user has no password").Uint64("userID", user.ID).Send()
+		return LoginResult{}, apperror.New(apperror.CodeWrongPassword, "wrong password")
+	}
+	passwordMatches, err := util.PasswordMatchesHash(credentials.Password, user.PasswordHash)
+	if err != nil {
+		// The stored hash is malformed, not something the client can fix
+		return LoginResult{}, fmt.Errorf("failed to match password of user %d: %w", user.ID, err)
+	}
+	if !passwordMatches {
+		log.Debug().Str("auth", "password does not match").Uint64("userID", user.ID).Send()
+		return LoginResult{}, apperror.New(apperror.CodeWrongPassword, "wrong password")
+	}
+	a.rehashPasswordIfOutdated(ctx, user, credentials.Password)
+
+	return a.completeFirstFactor(ctx, w, user, userAgent)
+}
+
+// Logs in the user who has given the first factor, a password or a magic link,
+// or returns the challenge for the second one
+func (a *Authenticator) completeFirstFactor(ctx context.Context, w http.ResponseWriter, user model.User, userAgent string) (LoginResult, error) {
+	hasTOTP, err := a.twoFactorStore.HasConfirmedTOTP(ctx, user.ID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if hasTOTP {
+		challenge, err := a.newSecondFactorChallenge(user.ID)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		log.Info().Str("auth", "second factor required").Uint64("userID", user.ID).Send()
+		return LoginResult{Challenge: &challenge}, nil
+	}
+
+	session, err := a.StartSession(ctx, w, user, userAgent)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	log.Info().Str("auth", "logged in user").Uint64("userID", user.ID).Send()
+	return LoginResult{Session: session}, nil
+}
+
+// Replaces a hash made with an old algorithm or weaker parameters while the
+// plaintext is at hand. The login goes on if it fails, the old hash still
+// works.
+func (a *Authenticator) rehashPasswordIfOutdated(ctx context.Context, user model.User, password string) {
+	if !a.passwordHasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	passwordHash, err := a.passwordHasher.Hash(password)
+	if err == nil {
+		err = a.userStore.UpdatePasswordHash(ctx, user.ID, passwordHash)
+	}
+	if err != nil {
+		log.Warn().Str("auth", "failed to rehash password").Err(err).Uint64("userID", user.ID).Send()
+		return
+	}
+	log.Info().Str("auth", "rehashed password").Uint64("userID", user.ID).Send()
+}
+
+// Creates a session for the already authenticated user and writes its cookie
+func (a *Authenticator) StartSession(ctx context.Context, w http.ResponseWriter, user model.User, userAgent string) (model.UserSession, error) {
+	now := a.Now()
+	session, err := a.userStore.CreateSession(ctx, user.ID, now, now.Add(a.cookieInfo.Lifetime), userAgent)
+	if err != nil {
+		return session, err
+	}
+	session.User = user
+
+	if err := WriteSessionCookie(w, a.cookieInfo, a.keyring, &session); err != nil {
+		return session, err
+	}
+	if err := a.IssueCSRFToken(w, session.ExpiresAt); err != nil {
+		return session, err
+	}
+	return session, nil
+}
+
+// Writes the cookie holding the session's id, encrypted with the primary key.
+// Also used to re-encrypt cookies of existing sessions after key rotation.
+func WriteSessionCookie(w http.ResponseWriter, cookieInfo model.SessionCookieInfo, keyring *util.AESKeyring, session *model.UserSession) error {
+	cookie := http.Cookie{
+		Name: cookieInfo.CookieName,
+		// Without a path browsers scope the cookie to the directory of
+		// the login URL, e.g. /gql for the login mutation
+		Path:     "/",
+		Domain:   cookieInfo.Domain,
+		Expires:  session.ExpiresAt,
+		HttpOnly: cookieInfo.HttpOnly,
+		SameSite: cookieInfo.SameSite,
+		Secure:   cookieInfo.Secure,
+	}
+
+	cookieValue := model.SessionCookieValue{
+		SessionID: strconv.FormatUint(session.ID, 10),
+	}
+	cookieValueBuf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(cookieValueBuf).Encode(&cookieValue); err != nil {
+		return fmt.Errorf("failed to JSON encode cookie value: %w", err)
+	}
+
+	if err := util.EncryptThenEncodeB64ThenWriteCookie(w, cookie, keyring, cookieValueBuf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write cookie: %w", err)
+	}
+	return nil
+}
+
+// Length of the CSRF token before base64 encoding
+const csrfTokenBytes = 32
+
+// Writes a new random CSRF token cookie. Unlike the session cookie it's
+// readable by scripts, since the client echoes it in a header that sites
+// without access to the cookie can't forge.
+func (a *Authenticator) IssueCSRFToken(w http.ResponseWriter, expires time.Time) error {
+	if a.CSRFCookieName == "" {
+		return nil
+	}
+
+	token := make([]byte, csrfTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("failed to generate csrf token: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.CSRFCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(token),
+		Path:     "/",
+		Domain:   a.cookieInfo.Domain,
+		Expires:  expires,
+		HttpOnly: false,
+		SameSite: a.cookieInfo.SameSite,
+		Secure:   a.cookieInfo.Secure,
+	})
+	return nil
+}
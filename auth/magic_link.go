@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/rs/zerolog/log"
+)
+
+// Delivers login links, see magic_link_sender.go for the implementations
+type MagicLinkSender interface {
+	SendMagicLink(ctx context.Context, email, link string, expiresAt time.Time) error
+}
+
+const (
+	DefaultMagicLinkLifetime = 15 * time.Minute
+
+	magicLinkTokenBytes = 32
+	// Requests are handled after the response, bounded by this
+	magicLinkSendTimeout = 30 * time.Second
+)
+
+// Passwordless login. A requested link carries a random single use token of
+// which only the hash is stored. Following it logs in like a password would,
+// so users with two factor authentication still get a challenge.
+type MagicLinks struct {
+	authenticator *Authenticator
+	store         *store.MagicLinkStore
+	sender        MagicLinkSender
+	linkURL       *url.URL
+	lifetime      time.Duration
+	// Only the browser the link was requested from can use it
+	bindUserAgent bool
+}
+
+// The token is added to linkURL as the token query parameter. The page there
+// should POST it to the verify endpoint, so that link scanners fetching it
+// don't use up the token.
+func NewMagicLinks(authenticator *Authenticator, store *store.MagicLinkStore, sender MagicLinkSender, linkURL string, lifetime time.Duration, bindUserAgent bool) (*MagicLinks, error) {
+	parsedURL, err := url.Parse(linkURL)
+	if err != nil || !parsedURL.IsAbs() {
+		return nil, fmt.Errorf("magic link url must be an absolute url, got %q", linkURL)
+	}
+	if lifetime == 0 {
+		lifetime = DefaultMagicLinkLifetime
+	}
+	return &MagicLinks{
+		authenticator: authenticator,
+		store:         store,
+		sender:        sender,
+		linkURL:       parsedURL,
+		lifetime:      lifetime,
+		bindUserAgent: bindUserAgent,
+	}, nil
+}
+
+// Sends a login link if the email belongs to a user. Whether it does isn't
+// revealed: the returned expiry is the same either way, and looking the user
+// up, storing the token and sending the link all happen in the background, so
+// the response takes as long for an unknown email as for a known one.
+func (m *MagicLinks) Request(email, userAgent string) time.Time {
+	expiresAt := m.authenticator.Now().Add(m.lifetime)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), magicLinkSendTimeout)
+		defer cancel()
+		if err := m.send(ctx, email, userAgent, expiresAt); err != nil {
+			log.Error().Err(err).Str("auth", "failed to send magic link").Send()
+		}
+	}()
+	return expiresAt
+}
+
+func (m *MagicLinks) send(ctx context.Context, email, userAgent string, expiresAt time.Time) error {
+	user, err := m.authenticator.userStore.GetUserWithEmail(ctx, email)
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		log.Debug().Str("auth", "magic link requested for unknown email").Send()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rawToken := make([]byte, magicLinkTokenBytes)
+	if _, err := rand.Read(rawToken); err != nil {
+		return fmt.Errorf("failed to generate magic link token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(rawToken)
+
+	record := model.MagicLinkToken{
+		UserID:    user.ID,
+		TokenHash: hashMagicLinkValue(token),
+		ExpiresAt: expiresAt,
+	}
+	if m.bindUserAgent {
+		record.UserAgentHash = hashMagicLinkValue(userAgent)
+	}
+	if err := m.store.CreateToken(ctx, &record); err != nil {
+		return err
+	}
+
+	link := *m.linkURL
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	if err := m.sender.SendMagicLink(ctx, user.Email, link.String(), expiresAt); err != nil {
+		return fmt.Errorf("user %d: %w", user.ID, err)
+	}
+	log.Info().Str("auth", "sent magic link").Uint64("userID", user.ID).Send()
+	return nil
+}
+
+// Uses up the token and logs its user in
+func (m *MagicLinks) Verify(ctx context.Context, w http.ResponseWriter, token, userAgent string) (LoginResult, error) {
+	invalid := apperror.Unauthenticated("login link is invalid, expired or already used")
+	now := m.authenticator.Now()
+
+	record, err := m.store.GetUsableToken(ctx, hashMagicLinkValue(token), now)
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		return LoginResult{}, invalid
+	}
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	// Checked before using the token up, so that someone who got hold of
+	// the link can't burn it for its owner
+	if record.UserAgentHash != "" &&
+		subtle.ConstantTimeCompare([]byte(record.UserAgentHash), []byte(hashMagicLinkValue(userAgent))) != 1 {
+		log.Info().Str("auth", "magic link used from another user agent").Uint64("userID", record.UserID).Send()
+		return LoginResult{}, invalid
+	}
+
+	used, err := m.store.MarkTokenUsed(ctx, record.ID, now)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if !used {
+		return LoginResult{}, invalid
+	}
+
+	user, err := m.authenticator.userStore.GetUser(ctx, record.UserID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return m.authenticator.completeFirstFactor(ctx, w, user, userAgent)
+}
+
+func hashMagicLinkValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
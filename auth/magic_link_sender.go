@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Logs the links instead of sending them. For local development only, anyone
+// reading the logs can log in as the users.
+type LogMagicLinkSender struct{}
+
+func (LogMagicLinkSender) SendMagicLink(ctx context.Context, email, link string, expiresAt time.Time) error {
+	log.Info().Str("magic-link", link).Str("email", email).Time("expiresAt", expiresAt).Msg("magic link not sent, logged instead")
+	return nil
+}
+
+// Writes each message to a file of its own in Dir, for local development and
+// for reading the links in end to end tests
+type FileMagicLinkSender struct {
+	Dir string
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9@._-]`)
+
+func (s FileMagicLinkSender) SendMagicLink(ctx context.Context, email, link string, expiresAt time.Time) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create magic link directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%d-%s.txt", time.Now().UnixNano(), unsafeFileNameChars.ReplaceAllString(email, "_"))
+	message := fmt.Sprintf("To: %s\nSubject: Your login link\n\nLog in by opening this link before %s:\n\n%s\n",
+		email, expiresAt.UTC().Format(time.RFC1123), link)
+
+	if err := os.WriteFile(filepath.Join(s.Dir, name), []byte(message), 0o600); err != nil {
+		return fmt.Errorf("failed to write magic link file: %w", err)
+	}
+	return nil
+}
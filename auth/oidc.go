@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/oidc"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/util"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Holds the state, nonce and PKCE verifier between the redirect to the
+	// provider and the callback
+	oidcFlowCookieName = "oidc_flow"
+	oidcFlowCookiePath = "/login/oidc/"
+	oidcFlowLifetime   = 10 * time.Minute
+)
+
+// Login with external OpenID Connect providers. The provider account is linked
+// to a user through user_identities on first login: to the user with the same
+// email if the provider has verified it, otherwise to a new user. Logging in
+// this way counts as the first factor, users with two factor authentication
+// still get a challenge.
+type OIDCLogins struct {
+	authenticator *Authenticator
+	store         *store.IdentityStore
+	// Keyed by the provider names in the config
+	providers map[string]*oidc.Provider
+}
+
+func NewOIDCLogins(authenticator *Authenticator, store *store.IdentityStore, providers map[string]*oidc.Provider) *OIDCLogins {
+	return &OIDCLogins{authenticator: authenticator, store: store, providers: providers}
+}
+
+// Written encrypted into the flow cookie
+type oidcFlow struct {
+	Provider     string    `json:"provider"`
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Starts a login with the provider, returning the URL to redirect the browser
+// to. The flow cookie written to w ties the callback to this browser.
+func (o *OIDCLogins) Begin(ctx context.Context, w http.ResponseWriter, providerName string) (string, error) {
+	provider, ok := o.providers[providerName]
+	if !ok {
+		return "", apperror.NotFound("no identity provider named %s", providerName)
+	}
+
+	var flow oidcFlow
+	for _, value := range []*string{&flow.State, &flow.Nonce, &flow.CodeVerifier} {
+		var err error
+		if *value, err = oidc.RandomValue(); err != nil {
+			return "", err
+		}
+	}
+	flow.Provider = providerName
+	flow.ExpiresAt = o.authenticator.Now().Add(oidcFlowLifetime)
+
+	authURL, err := provider.AuthCodeURL(ctx, flow.State, flow.Nonce, oidc.CodeChallengeS256(flow.CodeVerifier))
+	if err != nil {
+		return "", err
+	}
+
+	flowJSON, err := json.Marshal(&flow)
+	if err != nil {
+		return "", err
+	}
+	cookie := o.flowCookie()
+	cookie.Expires = flow.ExpiresAt
+	cookie.MaxAge = int(oidcFlowLifetime.Seconds())
+	if err := util.EncryptThenEncodeB64ThenWriteCookie(w, cookie, o.authenticator.keyring, flowJSON); err != nil {
+		return "", fmt.Errorf("failed to write oidc flow cookie: %w", err)
+	}
+	return authURL, nil
+}
+
+// Handles the provider's redirect back: checks it against the flow cookie,
+// trades the code for the ID token and logs in the user the provider account
+// is linked to
+func (o *OIDCLogins) Complete(w http.ResponseWriter, r *http.Request, providerName string) (LoginResult, error) {
+	ctx := r.Context()
+	provider, ok := o.providers[providerName]
+	if !ok {
+		return LoginResult{}, apperror.NotFound("no identity provider named %s", providerName)
+	}
+
+	flowJSON, _, err := util.ReadCookieDecodeB64ThenDecrypt(r, oidcFlowCookieName, o.authenticator.keyring)
+	if err != nil {
+		return LoginResult{}, apperror.Wrap(apperror.CodeUnauthenticated, err, "login with %s expired or was started in another browser", providerName)
+	}
+	// A flow is good for one callback
+	expired := o.flowCookie()
+	expired.MaxAge = -1
+	http.SetCookie(w, &expired)
+
+	var flow oidcFlow
+	if err := json.Unmarshal([]byte(flowJSON), &flow); err != nil {
+		return LoginResult{}, fmt.Errorf("failed to decode oidc flow cookie: %w", err)
+	}
+	now := o.authenticator.Now()
+	if flow.Provider != providerName || !now.Before(flow.ExpiresAt) {
+		return LoginResult{}, apperror.Unauthenticated("login with %s expired or was started in another browser", providerName)
+	}
+
+	query := r.URL.Query()
+	// RFC 6749 section 4.1.2.1, e.g. the user declined
+	if errCode := query.Get("error"); errCode != "" {
+		log.Info().Str("auth", "identity provider returned an error").Str("provider", providerName).
+			Str("error", errCode).Str("description", query.Get("error_description")).Send()
+		return LoginResult{}, apperror.Unauthenticated("login with %s failed: %s", providerName, errCode)
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		return LoginResult{}, apperror.Unauthenticated("login with %s failed: state mismatch", providerName)
+	}
+	code := query.Get("code")
+	if code == "" {
+		return LoginResult{}, apperror.InvalidInput("missing code")
+	}
+
+	claims, err := exchangeForClaims(ctx, provider, code, &flow, now)
+	if errors.Is(err, oidc.ErrExchange) || errors.Is(err, oidc.ErrInvalidToken) {
+		log.Info().Err(err).Str("auth", "oidc login failed").Str("provider", providerName).Send()
+		return LoginResult{}, apperror.Wrap(apperror.CodeUnauthenticated, err, "login with %s failed", providerName)
+	}
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	user, err := o.userForClaims(ctx, providerName, claims)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return o.authenticator.completeFirstFactor(ctx, w, user, r.UserAgent())
+}
+
+func exchangeForClaims(ctx context.Context, provider *oidc.Provider, code string, flow *oidcFlow, now time.Time) (oidc.Claims, error) {
+	rawIDToken, err := provider.Exchange(ctx, code, flow.CodeVerifier)
+	if err != nil {
+		return oidc.Claims{}, err
+	}
+	return provider.VerifyIDToken(ctx, rawIDToken, flow.Nonce, now)
+}
+
+func (o *OIDCLogins) flowCookie() http.Cookie {
+	return http.Cookie{
+		Name:     oidcFlowCookieName,
+		Path:     oidcFlowCookiePath,
+		Secure:   o.authenticator.cookieInfo.Secure,
+		HttpOnly: true,
+		// Lax, since the callback is a cross site navigation from the
+		// provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Finds the user the provider account is linked to, linking it first if this
+// is its first login
+func (o *OIDCLogins) userForClaims(ctx context.Context, providerName string, claims oidc.Claims) (model.User, error) {
+	identity, err := o.store.GetIdentity(ctx, providerName, claims.Subject)
+	if err == nil {
+		if claims.Email != "" && claims.Email != identity.Email {
+			if err := o.store.UpdateIdentityEmail(ctx, identity.ID, claims.Email); err != nil {
+				log.Warn().Err(err).Str("auth", "failed to update identity email").Uint64("userID", identity.UserID).Send()
+			}
+		}
+		return o.authenticator.userStore.GetUser(ctx, identity.UserID)
+	}
+	if !errors.Is(err, constants.ErrCodeNotFound) {
+		return model.User{}, err
+	}
+
+	// An unverified email could belong to someone else, linking or
+	// creating an account by it would let the provider account take the
+	// email's owner's place
+	if claims.Email == "" || !claims.EmailVerified {
+		return model.User{}, apperror.Forbidden("%s did not share a verified email", providerName)
+	}
+	identity = model.UserIdentity{Provider: providerName, Subject: claims.Subject, Email: claims.Email}
+
+	user, err := o.authenticator.userStore.GetUserWithEmail(ctx, claims.Email)
+	if err == nil {
+		identity.UserID = user.ID
+		if err := o.store.CreateIdentity(ctx, &identity); err != nil {
+			if errors.Is(err, constants.ErrCodeAlreadyExists) {
+				return model.User{}, apperror.New(apperror.CodeConflict, "the %s account was linked in the meantime, log in again", providerName)
+			}
+			return model.User{}, err
+		}
+		log.Info().Str("auth", "linked identity to existing user").Str("provider", providerName).Uint64("userID", user.ID).Send()
+		return user, nil
+	}
+	if !errors.Is(err, constants.ErrCodeNotFound) {
+		return model.User{}, err
+	}
+
+	// No password, the user logs in through the provider or a magic link
+	user = model.User{UserName: userNameFromClaims(claims), Email: claims.Email}
+	if err := o.store.CreateUserWithIdentity(ctx, &user, &identity); err != nil {
+		if errors.Is(err, constants.ErrCodeAlreadyExists) {
+			return model.User{}, apperror.New(apperror.CodeConflict, "the %s account was linked in the meantime, log in again", providerName)
+		}
+		return model.User{}, err
+	}
+	log.Info().Str("auth", "created user for identity").Str("provider", providerName).Uint64("userID", user.ID).Send()
+	return user, nil
+}
+
+func userNameFromClaims(claims oidc.Claims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+	if claims.Name != "" {
+		return claims.Name
+	}
+	localPart, _, _ := strings.Cut(claims.Email, "@")
+	return localPart
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/totp"
+	"github.com/nrawrx3/workout-backend/util"
+	"github.com/rs/zerolog/log"
+)
+
+// A login with the right password, waiting for the second factor. The token is
+// stateless, the user id and expiry encrypted with the cookie keyring, and can
+// be answered until it expires. Guesses are limited per user instead: after
+// MaxSecondFactorFailures wrong codes no code is accepted for
+// SecondFactorLockout, whichever challenge it answers. Replayed TOTP codes are
+// rejected regardless.
+type SecondFactorChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+const (
+	SecondFactorChallengeLifetime = 5 * time.Minute
+	MaxSecondFactorFailures       = 5
+	SecondFactorLockout           = 15 * time.Minute
+)
+
+const (
+	// Associated data of the challenge token, so that no other value
+	// encrypted with the keyring passes for one
+	challengeAssociatedData = "second-factor-challenge"
+
+	recoveryCodeCount = 10
+	// 50 bits, written as two groups of 5 base32 characters
+	recoveryCodeChars = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type challengeTokenValue struct {
+	UserID    uint64 `json:"user_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (a *Authenticator) newSecondFactorChallenge(userID uint64) (SecondFactorChallenge, error) {
+	expiresAt := a.Now().Add(SecondFactorChallengeLifetime)
+	value, err := json.Marshal(challengeTokenValue{UserID: userID, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return SecondFactorChallenge{}, err
+	}
+	keyID, encrypted, err := a.keyring.EncryptWithAD(value, []byte(challengeAssociatedData))
+	if err != nil {
+		return SecondFactorChallenge{}, err
+	}
+	return SecondFactorChallenge{
+		Token:     util.JoinKeyID(keyID, base64.RawURLEncoding.EncodeToString(encrypted)),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Returns the id of the user the challenge was issued to
+func (a *Authenticator) readSecondFactorChallenge(token string) (uint64, error) {
+	invalid := apperror.Unauthenticated("login challenge is invalid or expired, log in again")
+
+	keyID, encoded := util.SplitKeyID(token)
+	encrypted, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, invalid
+	}
+	decrypted, _, err := a.keyring.DecryptWithAD(keyID, encrypted, []byte(challengeAssociatedData))
+	if err != nil {
+		return 0, invalid
+	}
+	var value challengeTokenValue
+	if err := json.Unmarshal(decrypted, &value); err != nil {
+		return 0, invalid
+	}
+	if !a.Now().Before(time.Unix(value.ExpiresAt, 0)) {
+		return 0, invalid
+	}
+	return value.UserID, nil
+}
+
+// Answers the challenge of Login with a TOTP code or one of the recovery codes,
+// then creates the session like Login would have
+func (a *Authenticator) CompleteSecondFactor(ctx context.Context, w http.ResponseWriter, challengeToken, code, userAgent string) (model.UserSession, error) {
+	userID, err := a.readSecondFactorChallenge(challengeToken)
+	if err != nil {
+		return model.UserSession{}, err
+	}
+
+	user, err := a.userStore.GetUser(ctx, userID)
+	if err != nil {
+		return model.UserSession{}, err
+	}
+
+	if err := a.verifySecondFactor(ctx, userID, code); err != nil {
+		return model.UserSession{}, err
+	}
+
+	session, err := a.StartSession(ctx, w, user, userAgent)
+	if err != nil {
+		return model.UserSession{}, err
+	}
+	log.Info().Str("auth", "logged in user with second factor").Uint64("userID", user.ID).Send()
+	return session, nil
+}
+
+func (a *Authenticator) verifySecondFactor(ctx context.Context, userID uint64, code string) error {
+	userTOTP, err := a.twoFactorStore.GetTOTP(ctx, userID)
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		// Turned off since the challenge was issued
+		return apperror.Unauthenticated("login challenge is invalid or expired, log in again")
+	}
+	if err != nil {
+		return err
+	}
+	now := a.Now()
+	if userTOTP.Locked(now) {
+		return apperror.New(apperror.CodeRateLimited, "too many wrong codes, try again later")
+	}
+
+	ok, err := a.checkSecondFactorCode(ctx, &userTOTP, code, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		locked, err := a.twoFactorStore.RecordSecondFactorFailure(ctx, userID, MaxSecondFactorFailures, now.Add(SecondFactorLockout))
+		if err != nil {
+			return err
+		}
+		if locked {
+			log.Warn().Str("auth", "locked second factor after too many wrong codes").Uint64("userID", userID).Send()
+		}
+		return apperror.New(apperror.CodeWrongSecondFactor, "wrong or already used code")
+	}
+	if userTOTP.FailedAttempts != 0 {
+		return a.twoFactorStore.ResetSecondFactorFailures(ctx, userID)
+	}
+	return nil
+}
+
+// Whether code is an unused TOTP or recovery code of the user, using it up if
+// so
+func (a *Authenticator) checkSecondFactorCode(ctx context.Context, userTOTP *model.UserTOTP, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != totp.Digits {
+		used, err := a.twoFactorStore.UseRecoveryCode(ctx, userTOTP.UserID, hashRecoveryCode(code), now)
+		if err != nil {
+			return false, err
+		}
+		if used {
+			log.Info().Str("auth", "used recovery code").Uint64("userID", userTOTP.UserID).Send()
+		}
+		return used, nil
+	}
+
+	secret, err := a.decryptTOTPSecret(userTOTP)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return false, nil
+	}
+	return a.twoFactorStore.UseTOTPStep(ctx, userTOTP.UserID, step)
+}
+
+type TOTPEnrollment struct {
+	// otpauth:// URI for authenticator apps, usually shown as a QR code
+	URI string
+	// The secret in base32, for typing into the app instead
+	Secret string
+}
+
+// Starts enrolling the user, replacing an earlier enrollment that wasn't
+// confirmed. Codes aren't asked for at login until ConfirmTOTP succeeds.
+func (a *Authenticator) EnrollTOTP(ctx context.Context, user model.User) (TOTPEnrollment, error) {
+	if a.TOTPCipher == nil {
+		return TOTPEnrollment{}, apperror.New(apperror.CodeForbidden, "two factor authentication is not enabled on this server")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	encryptedSecret, err := a.TOTPCipher.EncryptWithAD(secret, totpSecretAssociatedData(user.ID))
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	err = a.twoFactorStore.SaveUnconfirmedTOTP(ctx, user.ID, encryptedSecret)
+	if errors.Is(err, constants.ErrCodeAlreadyExists) {
+		return TOTPEnrollment{}, apperror.New(apperror.CodeConflict, "two factor authentication is already enabled")
+	}
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	return TOTPEnrollment{
+		URI:    totp.URI(a.TOTPIssuer, user.Email, secret),
+		Secret: totp.EncodeSecret(secret),
+	}, nil
+}
+
+// Checks a code from the authenticator app against the pending enrollment and
+// turns on two factor authentication. Returns the recovery codes, which are
+// only stored hashed and can't be shown again.
+func (a *Authenticator) ConfirmTOTP(ctx context.Context, userID uint64, code string) ([]string, error) {
+	userTOTP, err := a.twoFactorStore.GetTOTP(ctx, userID)
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		return nil, apperror.NotFound("no pending two factor enrollment, enroll first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userTOTP.Confirmed() {
+		return nil, apperror.New(apperror.CodeConflict, "two factor authentication is already enabled")
+	}
+
+	secret, err := a.decryptTOTPSecret(&userTOTP)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, strings.TrimSpace(code), a.Now())
+	if !ok {
+		return nil, apperror.New(apperror.CodeWrongSecondFactor, "wrong code")
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = generateRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	err = a.twoFactorStore.ConfirmTOTP(ctx, userID, step, hashes, a.Now())
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		// Confirmed concurrently
+		return nil, apperror.New(apperror.CodeConflict, "two factor authentication is already enabled")
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("auth", "enabled two factor authentication").Uint64("userID", userID).Send()
+	return codes, nil
+}
+
+// Binds the ciphertext to the user, so a secret copied to another user's row
+// doesn't decrypt
+func totpSecretAssociatedData(userID uint64) []byte {
+	return []byte("totp-secret:" + strconv.FormatUint(userID, 10))
+}
+
+func (a *Authenticator) decryptTOTPSecret(userTOTP *model.UserTOTP) ([]byte, error) {
+	if a.TOTPCipher == nil {
+		return nil, errors.New("user has two factor authentication but no totp secret_key is configured")
+	}
+	return a.TOTPCipher.DecryptWithAD(userTOTP.EncryptedSecret, totpSecretAssociatedData(userTOTP.UserID))
+}
+
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, (recoveryCodeChars*5+7)/8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))[:recoveryCodeChars]
+	return code[:recoveryCodeChars/2] + "-" + code[recoveryCodeChars/2:], nil
+}
+
+// The codes are random enough that a plain hash is safe to store. The dash and
+// case don't matter when typing them.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,20 +3,51 @@ package main
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
+	"github.com/nrawrx3/workout-backend/config"
 	"github.com/nrawrx3/workout-backend/util"
 	"github.com/urfave/cli/v2"
 )
 
 var cliFlags struct {
 	hexKey       string
+	keyringFile  string
+	cookieName   string
 	dataString   string
 	encodeBase64 bool
 }
 
+// Builds the keyring from either a single key or a keyring file. A single key
+// has no ID, like the cookie_secret_key of the config.
+func loadKeyring() (*util.AESKeyring, error) {
+	switch {
+	case cliFlags.hexKey != "" && cliFlags.keyringFile != "":
+		return nil, errors.New("give only one of --key and --keyring")
+	case cliFlags.hexKey != "":
+		return util.NewAESKeyring("", nil, cliFlags.hexKey)
+	case cliFlags.keyringFile != "":
+		data, err := os.ReadFile(cliFlags.keyringFile)
+		if err != nil {
+			return nil, err
+		}
+		var keyringCfg config.CookieKeyringConfig
+		if err := json.Unmarshal(data, &keyringCfg); err != nil {
+			return nil, fmt.Errorf("failed to parse keyring file: %w", err)
+		}
+		keys, err := keyringCfg.HexKeys()
+		if err != nil {
+			return nil, err
+		}
+		return util.NewAESKeyring(keyringCfg.PrimaryKeyID, keys, "")
+	}
+	return nil, errors.New("either --key or --keyring is required")
+}
+
 func main() {
 	app := cli.App{
 		Name:  "encrypt-with-aes-gcm",
@@ -27,9 +58,18 @@ func main() {
 				Name:        "key",
 				Aliases:     []string{"k"},
 				Usage:       "hex key (64 chars, 256 bit)",
-				Required:    true,
 				Destination: &cliFlags.hexKey,
 			},
+			&cli.StringFlag{
+				Name:        "keyring",
+				Usage:       `JSON file in the format of the cookie_keyring config, {"primary_key_id": ..., "keys": [{"id": ..., "key": ...}]}. Encrypted strings are prefixed with the key ID and a '.'`,
+				Destination: &cliFlags.keyringFile,
+			},
+			&cli.StringFlag{
+				Name:        "cookie",
+				Usage:       "work with values of the cookie of this name, as written by the server. The cookie name is bound to the ciphertext and the output is always base64",
+				Destination: &cliFlags.cookieName,
+			},
 		},
 
 		Commands: []*cli.Command{
@@ -39,19 +79,28 @@ func main() {
 				Aliases: []string{"enc", "e"},
 
 				Action: func(ctx *cli.Context) error {
-					aesCipher, err := util.NewAESCipher(cliFlags.hexKey)
+					keyring, err := loadKeyring()
 					if err != nil {
 						return err
 					}
-					encBytes, err := aesCipher.Encrypt([]byte(cliFlags.dataString))
+					if cliFlags.cookieName != "" {
+						cookieValue, err := util.EncryptCookieValue(keyring, cliFlags.cookieName, []byte(cliFlags.dataString))
+						if err != nil {
+							return err
+						}
+						fmt.Printf("%s\n", cookieValue)
+						return nil
+					}
+
+					keyID, encBytes, err := keyring.Encrypt([]byte(cliFlags.dataString))
 					if err != nil {
 						return err
 					}
 
 					if cliFlags.encodeBase64 {
-						fmt.Printf("%s\n", base64.URLEncoding.EncodeToString(encBytes))
+						fmt.Printf("%s\n", util.JoinKeyID(keyID, base64.URLEncoding.EncodeToString(encBytes)))
 					} else {
-						fmt.Printf("%s\n", hex.EncodeToString(encBytes))
+						fmt.Printf("%s\n", util.JoinKeyID(keyID, hex.EncodeToString(encBytes)))
 					}
 					return nil
 				},
@@ -76,28 +125,36 @@ func main() {
 				Aliases: []string{"dec", "d"},
 
 				Action: func(ctx *cli.Context) error {
-					aesCipher, err := util.NewAESCipher(cliFlags.hexKey)
+					keyring, err := loadKeyring()
 					if err != nil {
 						return err
 					}
 
-					var encryptedBytes []byte
+					keyID, encoded := util.SplitKeyID(cliFlags.dataString)
+					if keyID != "" && cliFlags.keyringFile == "" {
+						return fmt.Errorf("string is encrypted with key %q, decrypt it with --keyring", keyID)
+					}
 
-					if cliFlags.encodeBase64 {
-						bytes, err := base64.URLEncoding.DecodeString(cliFlags.dataString)
+					if cliFlags.cookieName != "" {
+						decryptedBytes, _, err := util.DecryptCookieValue(keyring, cliFlags.cookieName, cliFlags.dataString)
 						if err != nil {
-							return nil
+							return err
 						}
-						encryptedBytes = bytes
+						fmt.Printf("%s", string(decryptedBytes))
+						return nil
+					}
+
+					var encryptedBytes []byte
+					if cliFlags.encodeBase64 {
+						encryptedBytes, err = base64.URLEncoding.DecodeString(encoded)
 					} else {
-						bytes, err := hex.DecodeString(cliFlags.dataString)
-						if err != nil {
-							return nil
-						}
-						encryptedBytes = bytes
+						encryptedBytes, err = hex.DecodeString(encoded)
+					}
+					if err != nil {
+						return err
 					}
 
-					decryptedBytes, err := aesCipher.Decrypt(encryptedBytes)
+					decryptedBytes, _, err := keyring.Decrypt(keyID, encryptedBytes)
 					if err != nil {
 						return err
 					}
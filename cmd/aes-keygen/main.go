@@ -1,30 +1,235 @@
 package main
 
 import (
-	"flag"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/util"
+	"github.com/urfave/cli/v2"
 )
 
-const numBits = 256
-const digits = "0123456789abcdef"
+const (
+	purposeAES     = "aes"
+	purposeHMAC    = "hmac"
+	purposeEd25519 = "ed25519"
+
+	formatHex     = "hex"
+	formatBase64  = "base64"
+	formatKeyring = "keyring"
 
-var seed int64
+	aesKeyBytes         = 32
+	minHMACKeyBytes     = 32
+	defaultHMACKeyBytes = 32
+)
+
+var cliFlags struct {
+	purpose    string
+	format     string
+	hmacBytes  int
+	keyID      string
+	configFile string
+	primary    bool
+	testSeed   int64
+}
 
 func main() {
-	flag.Int64Var(&seed, "seed", 0xdeadc0de, "seed")
-	flag.Parse()
+	if err := newApp().Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newApp() *cli.App {
+	return &cli.App{
+		Name:  "aes-keygen",
+		Usage: "generate secret keys with a cryptographically secure random source",
+
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "purpose",
+				Usage:       "aes (256 bit cookie key), hmac or ed25519. For ed25519 the private key is output as its 32 byte seed and the public key is printed to stderr",
+				Value:       purposeAES,
+				Destination: &cliFlags.purpose,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Aliases:     []string{"f"},
+				Usage:       "hex, base64 or keyring (a cookie_keyring key entry, aes only)",
+				Value:       formatHex,
+				Destination: &cliFlags.format,
+			},
+			&cli.IntFlag{
+				Name:        "hmac-bytes",
+				Usage:       "length of hmac keys in bytes",
+				Value:       defaultHMACKeyBytes,
+				Destination: &cliFlags.hmacBytes,
+			},
+			&cli.StringFlag{
+				Name:        "id",
+				Usage:       "key ID for the keyring format and --config, defaults to k<yyyymmdd>",
+				Destination: &cliFlags.keyID,
+			},
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "add the key to the cookie_keyring of this config file instead of printing it (aes only). The file is rewritten with its keys sorted",
+				Destination: &cliFlags.configFile,
+			},
+			&cli.BoolFlag{
+				Name:        "primary",
+				Usage:       "with --config, make the new key the primary one. The first key of an empty keyring always is",
+				Destination: &cliFlags.primary,
+			},
+			&cli.Int64Flag{
+				Name:        "insecure-test-seed",
+				Usage:       "derive the key from this seed instead of crypto/rand. Only for reproducible test fixtures, never for real keys",
+				Destination: &cliFlags.testSeed,
+			},
+		},
+
+		Action: func(ctx *cli.Context) error {
+			var random io.Reader = cryptorand.Reader
+			if ctx.IsSet("insecure-test-seed") {
+				fmt.Fprintln(os.Stderr, "warning: key derived from --insecure-test-seed, do not use it outside tests")
+				random = mathrand.New(mathrand.NewSource(cliFlags.testSeed))
+			}
+
+			switch cliFlags.format {
+			case formatHex, formatBase64, formatKeyring:
+			default:
+				return fmt.Errorf("unknown format %q", cliFlags.format)
+			}
+			if cliFlags.purpose != purposeAES && (cliFlags.format == formatKeyring || cliFlags.configFile != "") {
+				return errors.New("only aes keys go in the cookie keyring")
+			}
+
+			if cliFlags.keyID == "" {
+				cliFlags.keyID = "k" + time.Now().UTC().Format("20060102")
+			}
+			if !util.IsValidKeyID(cliFlags.keyID) {
+				return fmt.Errorf("key ID %q must be 1 to 32 letters, digits, '-' or '_'", cliFlags.keyID)
+			}
+
+			key, publicKey, err := generateKey(random)
+			if err != nil {
+				return err
+			}
+			if publicKey != nil {
+				fmt.Fprintf(os.Stderr, "public key: %s\n", encodeKey(publicKey))
+			}
+
+			if cliFlags.configFile != "" {
+				return addToConfigFile(cliFlags.configFile, config.CookieKeyConfig{
+					ID:  cliFlags.keyID,
+					Key: hex.EncodeToString(key),
+				})
+			}
+
+			if cliFlags.format == formatKeyring {
+				entry, err := json.Marshal(config.CookieKeyConfig{
+					ID:  cliFlags.keyID,
+					Key: hex.EncodeToString(key),
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(ctx.App.Writer, "%s\n", entry)
+				return nil
+			}
+
+			fmt.Fprintf(ctx.App.Writer, "%s\n", encodeKey(key))
+			return nil
+		},
+	}
+}
 
-	rand.Seed(seed)
+// Returns the secret key, and for signing keys the public key as well
+func generateKey(random io.Reader) (key []byte, publicKey []byte, err error) {
+	switch cliFlags.purpose {
+	case purposeAES:
+		key = make([]byte, aesKeyBytes)
+	case purposeHMAC:
+		if cliFlags.hmacBytes < minHMACKeyBytes {
+			return nil, nil, fmt.Errorf("hmac keys should be at least %d bytes", minHMACKeyBytes)
+		}
+		key = make([]byte, cliFlags.hmacBytes)
+	case purposeEd25519:
+		public, private, err := ed25519.GenerateKey(random)
+		if err != nil {
+			return nil, nil, err
+		}
+		return private.Seed(), public, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown purpose %q", cliFlags.purpose)
+	}
 
-	numDigits := numBits / 4
-	digitsArray := []byte(digits)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return key, nil, nil
+}
 
-	key := make([]byte, numDigits)
+func encodeKey(key []byte) string {
+	if cliFlags.format == formatBase64 {
+		return base64.StdEncoding.EncodeToString(key)
+	}
+	return hex.EncodeToString(key)
+}
+
+// Appends the key to the cookie_keyring of the config file, leaving the other
+// fields as they are
+func addToConfigFile(configFile string, key config.CookieKeyConfig) error {
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
 
-	for i := 0; i < numDigits; i++ {
-		key[i] = digitsArray[rand.Intn(len(digitsArray))]
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	var keyring config.CookieKeyringConfig
+	if raw, ok := fields["cookie_keyring"]; ok {
+		if err := json.Unmarshal(raw, &keyring); err != nil {
+			return fmt.Errorf("failed to parse cookie_keyring: %w", err)
+		}
+	}
+	for _, existing := range keyring.Keys {
+		if existing.ID == key.ID {
+			return fmt.Errorf("cookie_keyring already has a key with ID %q", key.ID)
+		}
+	}
+
+	keyring.Keys = append(keyring.Keys, key)
+	if cliFlags.primary || keyring.PrimaryKeyID == "" {
+		keyring.PrimaryKeyID = key.ID
+	}
+	fields["cookie_keyring"], err = json.Marshal(keyring)
+	if err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(configFile, append(data, '\n'), info.Mode().Perm()); err != nil {
+		return err
 	}
 
-	fmt.Printf("%s", string(key))
+	fmt.Fprintf(os.Stderr, "added key %q to %s, primary key is %q\n", key.ID, configFile, keyring.PrimaryKeyID)
+	return nil
 }
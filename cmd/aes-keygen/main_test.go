@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/util"
+)
+
+// Runs aes-keygen with the arguments, returning what it printed to stdout
+func runKeygen(t *testing.T, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	app := newApp()
+	app.Writer = &out
+	if err := app.Run(append([]string{"aes-keygen"}, args...)); err != nil {
+		t.Fatalf("aes-keygen %v: %v", args, err)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func TestKeygenOutputLengths(t *testing.T) {
+	tests := []struct {
+		args  []string
+		bytes int
+	}{
+		{nil, aesKeyBytes},
+		{[]string{"--purpose", "hmac"}, defaultHMACKeyBytes},
+		{[]string{"--purpose", "hmac", "--hmac-bytes", "64"}, 64},
+		{[]string{"--purpose", "ed25519"}, 32},
+	}
+	for _, tc := range tests {
+		out := runKeygen(t, tc.args...)
+		key, err := hex.DecodeString(out)
+		if err != nil {
+			t.Fatalf("%v: output %q isn't hex: %v", tc.args, out, err)
+		}
+		if len(key) != tc.bytes {
+			t.Fatalf("%v: got a %d byte key, want %d", tc.args, len(key), tc.bytes)
+		}
+	}
+
+	if _, err := util.NewAESCipher(runKeygen(t)); err != nil {
+		t.Fatalf("aes key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(runKeygen(t, "--format", "base64"))
+	if err != nil || len(key) != aesKeyBytes {
+		t.Fatalf("base64 key of %d bytes: %v", len(key), err)
+	}
+	if runKeygen(t) == runKeygen(t) {
+		t.Fatal("two runs generated the same key")
+	}
+	if runKeygen(t, "--insecure-test-seed", "7") != runKeygen(t, "--insecure-test-seed", "7") {
+		t.Fatal("the same test seed generated different keys")
+	}
+}
+
+func TestKeygenRejectsBadArguments(t *testing.T) {
+	for _, args := range [][]string{
+		{"--format", "pem"},
+		{"--purpose", "rsa"},
+		{"--purpose", "hmac", "--hmac-bytes", "16"},
+		{"--purpose", "hmac", "--format", "keyring"},
+		{"--id", "no.dots"},
+	} {
+		app := newApp()
+		app.Writer = &bytes.Buffer{}
+		if err := app.Run(append([]string{"aes-keygen"}, args...)); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+// Writes a config file with the sample's fields and the given keyring
+func writeTestConfig(t *testing.T, keyring string) string {
+	t.Helper()
+	sample, err := os.ReadFile("../../sample.config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(sample, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["cookie_keyring"] = json.RawMessage(keyring)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Loads the config file the way the app does and builds its cookie keyring
+func loadKeyring(t *testing.T, path string) (*config.Config, *util.AESKeyring) {
+	t.Helper()
+	var cfg config.Config
+	if err := cfg.LoadFromJSONFile(path); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := cfg.CookieKeyring.HexKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := util.NewAESKeyring(cfg.CookieKeyring.PrimaryKeyID, keys, cfg.CookieSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cfg, keyring
+}
+
+func TestKeygenKeyringEntryLoads(t *testing.T) {
+	entry := runKeygen(t, "--format", "keyring", "--id", "k1")
+	path := writeTestConfig(t, `{"primary_key_id": "k1", "keys": [`+entry+`]}`)
+	_, keyring := loadKeyring(t, path)
+	if keyring.PrimaryKeyID() != "k1" {
+		t.Fatalf("primary key is %q", keyring.PrimaryKeyID())
+	}
+}
+
+func TestKeygenAddsToConfigFile(t *testing.T) {
+	path := writeTestConfig(t, `{}`)
+	runKeygen(t, "--config", path, "--id", "k1")
+	runKeygen(t, "--config", path, "--id", "k2")
+	cfg, keyring := loadKeyring(t, path)
+	if keyring.PrimaryKeyID() != "k1" || len(cfg.CookieKeyring.Keys) != 2 {
+		t.Fatalf("got keyring %+v, want k1 primary of two keys", cfg.CookieKeyring)
+	}
+	// The rest of the config survives the rewrite
+	if cfg.CookieName != "WORKOUT" {
+		t.Fatalf("cookie_name is %q after rewriting", cfg.CookieName)
+	}
+
+	runKeygen(t, "--config", path, "--id", "k3", "--primary")
+	if _, keyring := loadKeyring(t, path); keyring.PrimaryKeyID() != "k3" {
+		t.Fatalf("primary key is %q, want k3", keyring.PrimaryKeyID())
+	}
+
+	app := newApp()
+	if err := app.Run([]string{"aes-keygen", "--config", path, "--id", "k2"}); err == nil {
+		t.Fatal("added a second key with ID k2")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	backend "github.com/nrawrx3/workout-backend"
 	"github.com/nrawrx3/workout-backend/config"
 	"github.com/nrawrx3/workout-backend/graph"
+	"github.com/nrawrx3/workout-backend/graph/loader"
 	"github.com/nrawrx3/workout-backend/store"
 )
 
@@ -161,10 +162,13 @@ func main() {
 }
 
 func startGQLPlayground(db *gorm.DB, cfg *config.Config) error {
-	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{DB: db}}))
+	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{Resolvers: graph.NewResolver(db)}))
+	srv.SetErrorPresenter(graph.ErrorPresenter)
+	srv.SetRecoverFunc(graph.RecoverFunc)
 
 	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", srv)
+	loaderMiddle := loader.Middleware(store.NewUserStore(db), store.NewWorkoutStore(db))
+	http.Handle("/query", loaderMiddle(srv))
 
 	log.Printf("connect to http://localhost:%d/ for GraphQL playground", cfg.Port)
 	return http.ListenAndServe(fmt.Sprintf("localhost:%d", cfg.Port), nil)
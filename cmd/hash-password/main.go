@@ -5,26 +5,101 @@ import (
 	"log"
 	"os"
 
+	"github.com/nrawrx3/workout-backend/config"
 	"github.com/nrawrx3/workout-backend/util"
 	"github.com/urfave/cli/v2"
 )
 
 var cliFlags struct {
-	password           string
-	base64passwordHash string
+	password     string
+	passwordHash string
+	configFile   string
+	hashing      config.PasswordHashingConfig
+}
+
+// Builds the hasher from the password_hashing of the config file if given,
+// with the flags overriding it
+func loadPasswordHasher(ctx *cli.Context) (*util.PasswordHasher, error) {
+	var hashing config.PasswordHashingConfig
+	if cliFlags.configFile != "" {
+		var cfg config.Config
+		if err := cfg.LoadFromJSONFile(cliFlags.configFile); err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		hashing = cfg.PasswordHashing
+	}
+
+	if ctx.IsSet("algorithm") {
+		hashing.Algorithm = cliFlags.hashing.Algorithm
+	}
+	if ctx.IsSet("bcrypt-cost") {
+		hashing.BcryptCost = cliFlags.hashing.BcryptCost
+	}
+	if ctx.IsSet("argon2-memory") {
+		hashing.Argon2id.MemoryKiB = cliFlags.hashing.Argon2id.MemoryKiB
+	}
+	if ctx.IsSet("argon2-iterations") {
+		hashing.Argon2id.Iterations = cliFlags.hashing.Argon2id.Iterations
+	}
+	if ctx.IsSet("argon2-parallelism") {
+		hashing.Argon2id.Parallelism = cliFlags.hashing.Argon2id.Parallelism
+	}
+	return util.NewPasswordHasher(hashing.Params())
 }
 
 func main() {
+	var argon2Memory, argon2Iterations, argon2Parallelism uint
+
 	app := cli.App{
 		Name:  "password-hash",
-		Usage: "generate and compare base64 password hashes with bcrypt",
+		Usage: "generate and compare PHC formatted password hashes, with argon2id or bcrypt",
+
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "take the hashing parameters from the password_hashing of this config file",
+				Destination: &cliFlags.configFile,
+			},
+			&cli.StringFlag{
+				Name:        "algorithm",
+				Usage:       "argon2id or bcrypt",
+				Destination: &cliFlags.hashing.Algorithm,
+			},
+			&cli.IntFlag{
+				Name:        "bcrypt-cost",
+				Destination: &cliFlags.hashing.BcryptCost,
+			},
+			&cli.UintFlag{
+				Name:        "argon2-memory",
+				Usage:       "memory in KiB",
+				Destination: &argon2Memory,
+			},
+			&cli.UintFlag{
+				Name:        "argon2-iterations",
+				Destination: &argon2Iterations,
+			},
+			&cli.UintFlag{
+				Name:        "argon2-parallelism",
+				Destination: &argon2Parallelism,
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			cliFlags.hashing.Argon2id.MemoryKiB = uint32(argon2Memory)
+			cliFlags.hashing.Argon2id.Iterations = uint32(argon2Iterations)
+			cliFlags.hashing.Argon2id.Parallelism = uint8(argon2Parallelism)
+			return nil
+		},
 
 		Commands: []*cli.Command{
 			{
 				Name:  "generate",
-				Usage: "generate password hash with bcrypt",
+				Usage: "generate password hash",
 				Action: func(ctx *cli.Context) error {
-					hash, err := util.HashPasswordBase64(cliFlags.password)
+					hasher, err := loadPasswordHasher(ctx)
+					if err != nil {
+						return err
+					}
+					hash, err := hasher.Hash(cliFlags.password)
 					if err != nil {
 						log.Printf("failed to generate hash: %v", err)
 						return err
@@ -44,17 +119,24 @@ func main() {
 			},
 			{
 				Name:  "compare",
-				Usage: "compare password with a hash",
+				Usage: "compare password with a hash of any supported format, and tell if login would rehash it",
 				Action: func(ctx *cli.Context) error {
-					match, err := util.PasswordMatchesHash(cliFlags.password, cliFlags.base64passwordHash)
+					hasher, err := loadPasswordHasher(ctx)
+					if err != nil {
+						return err
+					}
+					match, err := util.PasswordMatchesHash(cliFlags.password, cliFlags.passwordHash)
 					if err != nil {
 						log.Print(err)
 						return err
 					}
-					if match {
-						fmt.Print("Match")
-					} else {
+					if !match {
 						fmt.Print("No match")
+						return nil
+					}
+					fmt.Print("Match")
+					if hasher.NeedsRehash(cliFlags.passwordHash) {
+						fmt.Print(", outdated hash")
 					}
 					return nil
 				},
@@ -67,11 +149,11 @@ func main() {
 						Destination: &cliFlags.password,
 					},
 					&cli.StringFlag{
-						Name:        "hash-bytes",
-						Aliases:     []string{"b"},
-						Usage:       "base64 hash to compare against",
+						Name:        "hash",
+						Aliases:     []string{"hash-bytes", "b"},
+						Usage:       "hash to compare against, PHC formatted or the older base64 encoded bcrypt",
 						Required:    true,
-						Destination: &cliFlags.base64passwordHash,
+						Destination: &cliFlags.passwordHash,
 					},
 				},
 			},
@@ -2,12 +2,15 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"strings"
 	"text/template"
+	"time"
 
 	config "github.com/golobby/config/v3"
 	"github.com/golobby/config/v3/pkg/feeder"
+	"github.com/nrawrx3/workout-backend/util"
 )
 
 type Config struct {
@@ -26,15 +29,350 @@ type Config struct {
 		AllowedOrigins []string `json:"allowed_origins"`
 		AllowAll       bool     `json:"allow_all"`
 	} `json:"cors"`
-	CookieSecretKey string `json:"cookie_secret_key"`
-	CookieName      string `json:"cookie_name"`
-	CookieDomain    string `json:"cookie_domain"`
+	GraphQL     GraphQLConfig     `json:"graphql"`
+	Idempotency IdempotencyConfig `json:"idempotency"`
+	CSRF        CSRFConfig        `json:"csrf"`
+	RateLimit   RateLimitConfig   `json:"rate_limit"`
+
+	PasswordHashing PasswordHashingConfig `json:"password_hashing"`
+	TOTP            TOTPConfig            `json:"totp"`
+	MagicLink       MagicLinkConfig       `json:"magic_link"`
+	OIDC            OIDCConfig            `json:"oidc"`
+
+	// Keyed by workout kind (e.g. "pushups"). The "default" entry applies to
+	// kinds without their own entry, and fills in the limits an entry leaves
+	// unset.
+	WorkoutLimits map[string]WorkoutLimits `json:"workout_limits"`
+
+	// Hex key the cookies are encrypted with when CookieKeyring has no
+	// keys. Otherwise it only decrypts cookies written before the keyring.
+	CookieSecretKey string              `json:"cookie_secret_key"`
+	CookieKeyring   CookieKeyringConfig `json:"cookie_keyring"`
+	CookieName      string              `json:"cookie_name"`
+	CookieDomain    string              `json:"cookie_domain"`
+	// Cookies written before they were bound to their name are accepted,
+	// and re-issued, until this RFC 3339 time. Set it to the deploy time
+	// plus the session lifetime when upgrading. Unset, they are rejected.
+	AcceptUnversionedCookiesUntil time.Time `json:"accept_unversioned_cookies_until"`
 
 	// For testing purposes. In production, use a SSL reverse proxy instead.
 	UseSelfSignedTLS bool `json:"use_self_signed_tls"`
 	UsePrettyLogger  bool `json:"use_pretty_logger"`
 }
 
+type GraphQLConfig struct {
+	// Introspection is on unless disabled, turn it off in production
+	DisableIntrospection bool `json:"disable_introspection"`
+	ComplexityLimit      int  `json:"complexity_limit"`
+	MaxDepth             int  `json:"max_depth"`
+	// Upper bound of the first argument of paginated list fields
+	MaxPageSize int `json:"max_page_size"`
+}
+
+const (
+	DefaultGraphQLComplexityLimit = 1000
+	DefaultGraphQLMaxDepth        = 10
+	DefaultGraphQLMaxPageSize     = 100
+)
+
+// Returns a copy with unset limits replaced by the defaults
+func (c GraphQLConfig) WithDefaults() GraphQLConfig {
+	if c.ComplexityLimit == 0 {
+		c.ComplexityLimit = DefaultGraphQLComplexityLimit
+	}
+	if c.MaxDepth == 0 {
+		c.MaxDepth = DefaultGraphQLMaxDepth
+	}
+	if c.MaxPageSize == 0 {
+		c.MaxPageSize = DefaultGraphQLMaxPageSize
+	}
+	return c
+}
+
+type IdempotencyConfig struct {
+	// How long the response to a request with an Idempotency-Key is kept
+	// for replay
+	KeyTTLSeconds int `json:"key_ttl_seconds"`
+}
+
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+func (c IdempotencyConfig) KeyTTL() time.Duration {
+	if c.KeyTTLSeconds == 0 {
+		return DefaultIdempotencyKeyTTL
+	}
+	return time.Duration(c.KeyTTLSeconds) * time.Second
+}
+
+// Cookies are encrypted with the primary key and carry its ID, cookies with the
+// ID of another key in the keyring are re-issued with the primary one. The same
+// format is read by cmd/aes-encrypt from a file.
+type CookieKeyringConfig struct {
+	PrimaryKeyID string            `json:"primary_key_id"`
+	Keys         []CookieKeyConfig `json:"keys"`
+}
+
+type CookieKeyConfig struct {
+	ID string `json:"id"`
+	// 64 character hex key
+	Key string `json:"key"`
+}
+
+// Returns the keys keyed by ID, or an error if an ID repeats
+func (c CookieKeyringConfig) HexKeys() (map[string]string, error) {
+	keys := make(map[string]string, len(c.Keys))
+	for _, key := range c.Keys {
+		if _, ok := keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate cookie key ID %q", key.ID)
+		}
+		keys[key.ID] = key.Key
+	}
+	return keys, nil
+}
+
+// Cross-site request forgery defenses of the endpoints authenticated by the
+// session cookie
+type CSRFConfig struct {
+	// Stops issuing the double-submit token cookie on login and checking
+	// the token header on state changing requests
+	DisableTokenCheck bool   `json:"disable_token_check"`
+	CookieName        string `json:"cookie_name"`
+	HeaderName        string `json:"header_name"`
+	// Stops serving graphql over GET at all. Mutations are refused over GET
+	// either way.
+	DisableGraphQLGET bool `json:"disable_graphql_get"`
+}
+
+const (
+	DefaultCSRFCookieName = "CSRF-TOKEN"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+)
+
+// Returns a copy with unset names replaced by the defaults
+func (c CSRFConfig) WithDefaults() CSRFConfig {
+	if c.CookieName == "" {
+		c.CookieName = DefaultCSRFCookieName
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = DefaultCSRFHeaderName
+	}
+	return c
+}
+
+type RateLimitConfig struct {
+	Disabled bool `json:"disabled"`
+	// IPs or CIDRs of reverse proxies whose X-Forwarded-For is trusted to
+	// find the client IP. Also used when the rate limit is disabled, to scope
+	// the idempotency keys of requests without a session.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Keyed by route group, one of the RateLimitGroup constants. Groups
+	// without an entry use the default limits.
+	Groups map[string]RateLimitGroupConfig `json:"groups"`
+}
+
+const (
+	RateLimitGroupGraphQL = "graphql"
+	RateLimitGroupREST    = "rest"
+	RateLimitGroupLogin   = "login"
+)
+
+type RateLimitGroupConfig struct {
+	// Requests a client can make at once after being idle
+	Burst int `json:"burst"`
+	// Sustained rate
+	RequestsPerMinute int `json:"requests_per_minute"`
+}
+
+var DefaultRateLimits = map[string]RateLimitGroupConfig{
+	RateLimitGroupGraphQL: {Burst: 60, RequestsPerMinute: 120},
+	RateLimitGroupREST:    {Burst: 60, RequestsPerMinute: 120},
+	// Slows down password guessing
+	RateLimitGroupLogin: {Burst: 5, RequestsPerMinute: 10},
+}
+
+// Returns the limits of group, with unset fields taken from the defaults
+func (c RateLimitConfig) Group(group string) RateLimitGroupConfig {
+	limits := c.Groups[group]
+	defaults := DefaultRateLimits[group]
+	if limits.Burst == 0 {
+		limits.Burst = defaults.Burst
+	}
+	if limits.RequestsPerMinute == 0 {
+		limits.RequestsPerMinute = defaults.RequestsPerMinute
+	}
+	return limits
+}
+
+// How new password hashes are made. Stored hashes made with another algorithm
+// or weaker parameters are replaced on the user's next login. Unset fields take
+// the defaults of util.DefaultPasswordHashParams.
+type PasswordHashingConfig struct {
+	// "argon2id" or "bcrypt"
+	Algorithm  string `json:"algorithm"`
+	BcryptCost int    `json:"bcrypt_cost"`
+	Argon2id   struct {
+		MemoryKiB   uint32 `json:"memory_kib"`
+		Iterations  uint32 `json:"iterations"`
+		Parallelism uint8  `json:"parallelism"`
+	} `json:"argon2id"`
+}
+
+func (c PasswordHashingConfig) Params() util.PasswordHashParams {
+	return util.PasswordHashParams{
+		Algorithm:         c.Algorithm,
+		BcryptCost:        c.BcryptCost,
+		Argon2Memory:      c.Argon2id.MemoryKiB,
+		Argon2Iterations:  c.Argon2id.Iterations,
+		Argon2Parallelism: c.Argon2id.Parallelism,
+	}.WithDefaults()
+}
+
+// Time-based one-time passwords as an optional second factor at login
+type TOTPConfig struct {
+	// Hex key the TOTP secrets are encrypted with at rest. Users can't
+	// enroll while it's unset.
+	SecretKey string `json:"secret_key"`
+	// Shown by authenticator apps along with the user's email
+	Issuer string `json:"issuer"`
+}
+
+const DefaultTOTPIssuer = "Workout"
+
+// Returns a copy with unset fields replaced by the defaults
+func (c TOTPConfig) WithDefaults() TOTPConfig {
+	if c.Issuer == "" {
+		c.Issuer = DefaultTOTPIssuer
+	}
+	return c
+}
+
+// Passwordless login through a link sent to the user's email
+type MagicLinkConfig struct {
+	// Page of the frontend the link points to, with the token added as the
+	// token query parameter. Magic link login is off while it's unset.
+	LinkURL    string `json:"link_url"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	// Only the browser the link was requested from can use it
+	BindUserAgent bool `json:"bind_user_agent"`
+	// "log" or "file", both meant for local development
+	Sender        string `json:"sender"`
+	FileSenderDir string `json:"file_sender_dir"`
+}
+
+const (
+	MagicLinkSenderLog  = "log"
+	MagicLinkSenderFile = "file"
+)
+
+func (c MagicLinkConfig) TTL() time.Duration {
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// Login with external OpenID Connect identity providers. It's off while no
+// providers are configured.
+type OIDCConfig struct {
+	// Public base URL of this server. The providers redirect back to
+	// <redirect_base_url>/login/oidc/<name>/callback, which must be
+	// registered with them.
+	RedirectBaseURL string `json:"redirect_base_url"`
+	// Page of the frontend the browser is sent to after the login, with
+	// either a second_factor_challenge or an error query parameter if the
+	// session cookie wasn't set
+	FrontendURL string               `json:"frontend_url"`
+	Providers   []OIDCProviderConfig `json:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	// Appears in the login URLs and identifies the provider in
+	// user_identities, so don't change it once users have logged in
+	Name         string `json:"name"`
+	IssuerURL    string `json:"issuer_url"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// Requested along with openid, defaults to email and profile
+	Scopes []string `json:"scopes"`
+}
+
+// Inclusive bounds on the values of a workout. Fields are pointers so that a
+// limit left out of the config can be told apart from a limit of 0.
+type WorkoutLimits struct {
+	MinReps            *int `json:"min_reps"`
+	MaxReps            *int `json:"max_reps"`
+	MinDurationSeconds *int `json:"min_duration_seconds"`
+	MaxDurationSeconds *int `json:"max_duration_seconds"`
+	MinRounds          *int `json:"min_rounds"`
+	MaxRounds          *int `json:"max_rounds"`
+}
+
+const DefaultWorkoutLimitsKey = "default"
+
+// Returns a copy with unset limits taken from defaults
+func (l WorkoutLimits) WithDefaults(defaults WorkoutLimits) WorkoutLimits {
+	if l.MinReps == nil {
+		l.MinReps = defaults.MinReps
+	}
+	if l.MaxReps == nil {
+		l.MaxReps = defaults.MaxReps
+	}
+	if l.MinDurationSeconds == nil {
+		l.MinDurationSeconds = defaults.MinDurationSeconds
+	}
+	if l.MaxDurationSeconds == nil {
+		l.MaxDurationSeconds = defaults.MaxDurationSeconds
+	}
+	if l.MinRounds == nil {
+		l.MinRounds = defaults.MinRounds
+	}
+	if l.MaxRounds == nil {
+		l.MaxRounds = defaults.MaxRounds
+	}
+	return l
+}
+
+// Returns an error if a minimum is over its maximum. Bounds that are unset
+// aren't checked.
+func (l WorkoutLimits) CheckRanges() error {
+	ranges := []struct {
+		name     string
+		min, max *int
+	}{
+		{"reps", l.MinReps, l.MaxReps},
+		{"duration_seconds", l.MinDurationSeconds, l.MaxDurationSeconds},
+		{"rounds", l.MinRounds, l.MaxRounds},
+	}
+	for _, r := range ranges {
+		if r.min != nil && r.max != nil && *r.min > *r.max {
+			return fmt.Errorf("min_%s %d is over max_%s %d", r.name, *r.min, r.name, *r.max)
+		}
+	}
+	return nil
+}
+
+var DefaultWorkoutLimits = WorkoutLimits{
+	MinReps:            intPtr(1),
+	MaxReps:            intPtr(1000),
+	MinDurationSeconds: intPtr(1),
+	MaxDurationSeconds: intPtr(2 * 60 * 60),
+	MinRounds:          intPtr(0),
+	MaxRounds:          intPtr(100),
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// Fills in the limits of each entry the way validation.NewWorkoutValidator
+// does and checks the ranges they end up with
+func (cfg *Config) checkWorkoutLimits() error {
+	defaults := cfg.WorkoutLimits[DefaultWorkoutLimitsKey].WithDefaults(DefaultWorkoutLimits)
+	for key, l := range cfg.WorkoutLimits {
+		if err := l.WithDefaults(defaults).CheckRanges(); err != nil {
+			return fmt.Errorf("workout_limits.%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
 type SqliteConfig struct {
 	File         string `json:"file"`
 	InMemoryMode bool   `json:"in_memory"`
@@ -121,5 +459,5 @@ func (cfg *Config) LoadFromJSONFile(configFile string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return cfg.checkWorkoutLimits()
 }
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, content string) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	return cfg.LoadFromJSONFile(path)
+}
+
+func TestLoadRejectsWorkoutLimitsWithMinOverMax(t *testing.T) {
+	err := loadTestConfig(t, `{"workout_limits": {"default": {"max_rounds": 10}, "burpees": {"min_rounds": 20}}}`)
+	if err == nil || !strings.Contains(err.Error(), "workout_limits.burpees") {
+		t.Fatalf("got error %v, want one about burpees", err)
+	}
+
+	var cfg Config
+	if err := cfg.LoadFromJSONFile("../sample.config.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTestConfig(t, `{"workout_limits": {"burpees": {"min_reps": 0, "max_reps": 0}}}`); err != nil {
+		t.Fatalf("limits of 0: %v", err)
+	}
+}
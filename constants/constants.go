@@ -5,7 +5,29 @@ const (
 	GqlQueryApiPath      = "/query"
 	GqlPlaygroundApiPath = "/playground"
 	LoginPath            = "/login"
-	AmILoggedInPath      = "/am-i-logged-in"
+	// Answers the challenge /login returns for users with two factor
+	// authentication
+	LoginSecondFactorPath = "/login/second-factor"
+	// Sends a login link, which the frontend answers at the verify path
+	LoginMagicLinkPath       = "/login/magic"
+	LoginMagicLinkVerifyPath = "/login/magic/verify"
+	// Sends the browser to an OpenID Connect provider, which sends it back to
+	// the callback
+	LoginOIDCPath         = "/login/oidc/{provider}"
+	LoginOIDCCallbackPath = "/login/oidc/{provider}/callback"
+	AmILoggedInPath       = "/am-i-logged-in"
 
 	WorkoutsListPath = "/workouts"
+
+	OpenAPIPath = "/openapi.json"
+
+	// Versioned REST API, paths below are relative to it
+	ApiV1Prefix            = "/api/v1"
+	ApiV1WorkoutsPath      = "/workouts"
+	ApiV1WorkoutPath       = "/workouts/{id:[0-9]+}"
+	ApiV1WorkoutMovePath   = "/workouts/{id:[0-9]+}/move"
+	ApiV1WorkoutsOrderPath = "/workouts/order"
+	ApiV1ProfilePath       = "/profile"
+	ApiV1SessionsPath      = "/sessions"
+	ApiV1SessionPath       = "/sessions/{id:[0-9]+}"
 )
@@ -8,4 +8,6 @@ var (
 	ErrCodeMaxSizeExceeded = errors.New("err_max_size_exceeded")
 	ErrCodeInvalidValue    = errors.New("err_invalid_value")
 	ErrCodeUnknown         = errors.New("err_unknown")
+	ErrCodeVersionConflict = errors.New("err_version_conflict")
+	ErrCodeAlreadyExists   = errors.New("err_already_exists")
 )
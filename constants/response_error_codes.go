@@ -1,7 +1,20 @@
 package constants
 
+// Machine readable error codes. These are sent as the error_code of
+// model.ResponseFormatJSON by the REST handlers and as extensions.code of
+// graphql errors.
 const (
 	ResponseErrCodeUnexpectedServerError = "internal-server-error"
 	ResponseErrCodeUserNotLoggedIn       = "user-not-logged-in-error"
 	ResponseInvalidSessionCookie         = "invalid-session-cookie"
+	ResponseErrCodeNotFound              = "not-found"
+	ResponseErrCodeInvalidInput          = "invalid-input"
+	ResponseErrCodeForbidden             = "forbidden"
+	ResponseErrCodeConflict              = "conflict"
+	ResponseErrCodeWrongPassword         = "wrong-password"
+	ResponseErrCodeMethodNotAllowed      = "method-not-allowed"
+	ResponseErrCodeUnsupportedMediaType  = "unsupported-media-type"
+	ResponseErrCodeCSRFTokenMismatch     = "csrf-token-mismatch"
+	ResponseErrCodeRateLimited           = "rate-limited"
+	ResponseErrCodeWrongSecondFactor     = "wrong-second-factor"
 )
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+func TestCSRFTokenCheckSwitch(t *testing.T) {
+	newWorkout := model.CreateWorkoutRequestJSON{Kind: "pushups", Reps: 10, DurationSeconds: 60}
+	for _, disabled := range []bool{false, true} {
+		app := newTestApp(t, func(cfg *config.Config) {
+			cfg.CSRF.DisableTokenCheck = disabled
+		})
+		c := newTestClient(t, app)
+		graphqlLogin(t, c, testUserEmail, testUserPassword)
+		if issued := c.cookie(config.DefaultCSRFCookieName) != nil; issued == disabled {
+			t.Fatalf("disable_token_check %v: token cookie issued %v", disabled, issued)
+		}
+
+		c.omitCSRFHeader = true
+		resp, body := c.postJSON("/api/v1/workouts", newWorkout)
+		want := http.StatusForbidden
+		if disabled {
+			want = http.StatusCreated
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("disable_token_check %v: got %d %s, want %d", disabled, resp.StatusCode, body, want)
+		}
+
+		c.omitCSRFHeader = false
+		if resp, body := c.postJSON("/api/v1/workouts", newWorkout); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("disable_token_check %v, with the header: got %d %s", disabled, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestGraphQLGETSwitch(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		app := newTestApp(t, func(cfg *config.Config) {
+			cfg.CSRF.DisableGraphQLGET = disabled
+		})
+		c := newTestClient(t, app)
+		graphqlLogin(t, c, testUserEmail, testUserPassword)
+
+		resp, body := c.get("/gql/query?query=" + url.QueryEscape("{ workout_list_version }"))
+		if served := resp.StatusCode == http.StatusOK; served == disabled {
+			t.Fatalf("disable_graphql_get %v: query over GET got %d %s", disabled, resp.StatusCode, body)
+		}
+		// Never mutations
+		resp, body = c.get("/gql/query?query=" + url.QueryEscape("mutation { enroll_totp { secret } }"))
+		if resp.StatusCode == http.StatusOK {
+			t.Fatalf("disable_graphql_get %v: mutation over GET got %d %s", disabled, resp.StatusCode, body)
+		}
+	}
+}
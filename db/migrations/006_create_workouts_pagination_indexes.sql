@@ -0,0 +1,9 @@
+-- +migrate Up
+CREATE INDEX idx_workouts__user_id__relative_order ON workouts (user_id, relative_order, id);
+CREATE INDEX idx_workouts__user_id__created_at ON workouts (user_id, created_at, id);
+CREATE INDEX idx_workouts__user_id__kind ON workouts (user_id, kind);
+
+-- +migrate Down
+DROP INDEX idx_workouts__user_id__kind;
+DROP INDEX idx_workouts__user_id__created_at;
+DROP INDEX idx_workouts__user_id__relative_order;
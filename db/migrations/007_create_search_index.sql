@@ -0,0 +1,42 @@
+-- +migrate Up
+CREATE VIRTUAL TABLE search_index USING fts5(
+  body,
+  entity_type UNINDEXED,
+  entity_id UNINDEXED,
+  user_id UNINDEXED,
+  tokenize = 'porter unicode61'
+);
+
+INSERT INTO search_index (body, entity_type, entity_id, user_id)
+  SELECT kind, 'workout', id, user_id FROM workouts WHERE deleted_at IS NULL;
+
+-- +migrate StatementBegin
+CREATE TRIGGER search_index__workouts_ai AFTER INSERT ON workouts
+WHEN new.deleted_at IS NULL
+BEGIN
+  INSERT INTO search_index (body, entity_type, entity_id, user_id)
+    VALUES (new.kind, 'workout', new.id, new.user_id);
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER search_index__workouts_au AFTER UPDATE ON workouts
+BEGIN
+  DELETE FROM search_index WHERE entity_type = 'workout' AND entity_id = old.id;
+  INSERT INTO search_index (body, entity_type, entity_id, user_id)
+    SELECT new.kind, 'workout', new.id, new.user_id WHERE new.deleted_at IS NULL;
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER search_index__workouts_ad AFTER DELETE ON workouts
+BEGIN
+  DELETE FROM search_index WHERE entity_type = 'workout' AND entity_id = old.id;
+END;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP TRIGGER search_index__workouts_ad;
+DROP TRIGGER search_index__workouts_au;
+DROP TRIGGER search_index__workouts_ai;
+DROP TABLE search_index;
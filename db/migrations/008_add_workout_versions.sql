@@ -0,0 +1,13 @@
+-- +migrate Up
+ALTER TABLE workouts
+  ADD version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE users
+  ADD workout_list_version integer NOT NULL DEFAULT 1;
+
+-- +migrate Down
+ALTER TABLE users
+  DROP workout_list_version;
+
+ALTER TABLE workouts
+  DROP version;
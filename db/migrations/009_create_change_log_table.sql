@@ -0,0 +1,43 @@
+-- +migrate Up
+CREATE TABLE change_log (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  user_id integer NOT NULL,
+  entity_type text NOT NULL,
+  entity_id integer NOT NULL,
+  op text NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_change_log__user_id__id ON change_log (user_id, id);
+
+-- Existing rows are reported to clients on their first sync
+INSERT INTO change_log (created_at, user_id, entity_type, entity_id, op)
+  SELECT datetime('now'), user_id, 'workout', id,
+    CASE WHEN deleted_at IS NULL THEN 'upsert' ELSE 'delete' END
+  FROM workouts ORDER BY id;
+
+CREATE TABLE sync_applied_ops (
+  id integer PRIMARY KEY,
+  created_at datetime,
+  user_id integer NOT NULL,
+  op_id text NOT NULL,
+  entity_id integer NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX unique_sync_applied_ops__user_id__op_id ON sync_applied_ops (user_id, op_id);
+
+ALTER TABLE workouts
+  ADD client_id text;
+
+CREATE UNIQUE INDEX unique_workouts__user_id__client_id ON workouts (user_id, client_id);
+
+-- +migrate Down
+DROP INDEX unique_workouts__user_id__client_id;
+
+ALTER TABLE workouts
+  DROP client_id;
+
+DROP TABLE sync_applied_ops;
+DROP TABLE change_log;
@@ -0,0 +1,22 @@
+-- +migrate Up
+CREATE TABLE idempotency_keys (
+  id integer PRIMARY KEY,
+  created_at datetime,
+  updated_at datetime,
+  expires_at datetime NOT NULL,
+  -- 0 for requests made without a session
+  user_id integer NOT NULL,
+  key text NOT NULL,
+  fingerprint text NOT NULL,
+  -- 0 while the first request with the key is still being handled
+  status_code integer NOT NULL DEFAULT 0,
+  content_type text NOT NULL DEFAULT '',
+  response_body blob
+);
+
+CREATE UNIQUE INDEX unique_idempotency_keys__user_id__key ON idempotency_keys (user_id, key);
+
+CREATE INDEX idx_idempotency_keys__expires_at ON idempotency_keys (expires_at);
+
+-- +migrate Down
+DROP TABLE idempotency_keys;
@@ -0,0 +1,45 @@
+-- +migrate Up
+ALTER TABLE workouts
+  ADD order_key text NOT NULL DEFAULT '';
+
+-- Zero padded ranks sort the same as the ranks. The suffix keeps keys from
+-- ending with the zero digit, which fractional keys must not.
+UPDATE workouts
+SET order_key = (
+  SELECT printf('%06dV', ranked.rank)
+  FROM (
+    SELECT id, row_number() OVER (PARTITION BY user_id ORDER BY relative_order, id) AS rank
+    FROM workouts
+    WHERE deleted_at IS NULL
+  ) ranked
+  WHERE ranked.id = workouts.id
+)
+WHERE deleted_at IS NULL;
+
+CREATE UNIQUE INDEX unique_workouts__user_id__order_key ON workouts (user_id, order_key) WHERE deleted_at IS NULL;
+
+DROP INDEX idx_workouts__user_id__relative_order;
+
+ALTER TABLE workouts
+  DROP relative_order;
+
+-- +migrate Down
+ALTER TABLE workouts
+  ADD relative_order integer;
+
+UPDATE workouts
+SET relative_order = (
+  SELECT ranked.rank
+  FROM (
+    SELECT id, row_number() OVER (PARTITION BY user_id ORDER BY order_key, id) - 1 AS rank
+    FROM workouts
+  ) ranked
+  WHERE ranked.id = workouts.id
+);
+
+CREATE INDEX idx_workouts__user_id__relative_order ON workouts (user_id, relative_order, id);
+
+DROP INDEX unique_workouts__user_id__order_key;
+
+ALTER TABLE workouts
+  DROP order_key;
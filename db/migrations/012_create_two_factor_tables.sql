@@ -0,0 +1,33 @@
+-- +migrate Up
+CREATE TABLE user_totps (
+  id integer PRIMARY KEY,
+  created_at datetime,
+  updated_at datetime,
+  user_id integer NOT NULL,
+  -- AES-GCM encrypted, bound to the user id
+  encrypted_secret blob NOT NULL,
+  -- NULL until the user proves the authenticator app has the secret
+  confirmed_at datetime,
+  -- Time step of the last accepted code. Codes of it or earlier steps are
+  -- rejected so that a code can't be replayed.
+  last_used_step integer NOT NULL DEFAULT 0,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX unique_user_totps__user_id ON user_totps (user_id);
+
+CREATE TABLE user_recovery_codes (
+  id integer PRIMARY KEY,
+  created_at datetime,
+  user_id integer NOT NULL,
+  code_hash text NOT NULL,
+  used_at datetime,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX unique_user_recovery_codes__user_id__code_hash ON user_recovery_codes (user_id, code_hash);
+
+-- +migrate Down
+DROP TABLE user_recovery_codes;
+
+DROP TABLE user_totps;
@@ -0,0 +1,21 @@
+-- +migrate Up
+CREATE TABLE magic_link_tokens (
+  id integer PRIMARY KEY,
+  created_at datetime,
+  user_id integer NOT NULL,
+  -- SHA-256 of the token, the token itself is only in the sent link
+  token_hash text NOT NULL,
+  -- SHA-256 of the User-Agent the link was requested with, empty if the
+  -- token isn't bound to it
+  user_agent_hash text NOT NULL DEFAULT '',
+  expires_at datetime NOT NULL,
+  used_at datetime,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX unique_magic_link_tokens__token_hash ON magic_link_tokens (token_hash);
+
+CREATE INDEX idx_magic_link_tokens__expires_at ON magic_link_tokens (expires_at);
+
+-- +migrate Down
+DROP TABLE magic_link_tokens;
@@ -0,0 +1,21 @@
+-- +migrate Up
+CREATE TABLE user_identities (
+  id integer PRIMARY KEY,
+  created_at datetime,
+  updated_at datetime,
+  user_id integer NOT NULL,
+  -- Name of the provider in the config
+  provider text NOT NULL,
+  -- The provider's sub claim, stable for the account at the provider
+  subject text NOT NULL,
+  -- Email the provider gave at the last login, informational only
+  email text NOT NULL DEFAULT '',
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX unique_user_identities__provider_subject ON user_identities (provider, subject);
+
+CREATE INDEX idx_user_identities__user_id ON user_identities (user_id);
+
+-- +migrate Down
+DROP TABLE user_identities;
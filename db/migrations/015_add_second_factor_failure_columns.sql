@@ -0,0 +1,17 @@
+-- +migrate Up
+-- Wrong second factor codes since the last right one. The challenge token is
+-- stateless, so guesses are counted per user.
+ALTER TABLE user_totps
+  ADD failed_attempts integer NOT NULL DEFAULT 0;
+
+-- Set when failed_attempts reaches the limit. No code, right or wrong, is
+-- accepted before then.
+ALTER TABLE user_totps
+  ADD locked_until datetime;
+
+-- +migrate Down
+ALTER TABLE user_totps
+  DROP locked_until;
+
+ALTER TABLE user_totps
+  DROP failed_attempts;
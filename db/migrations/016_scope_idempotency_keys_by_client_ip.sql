@@ -0,0 +1,29 @@
+-- +migrate Up
+-- Set for requests made without a session, which would otherwise all share
+-- user_id 0. Empty for users, whose retries may come from another address.
+ALTER TABLE idempotency_keys
+  ADD client_ip text NOT NULL DEFAULT '';
+
+-- JSON object of the response headers that are replayed along with the body,
+-- such as Location
+ALTER TABLE idempotency_keys
+  ADD response_headers text;
+
+DROP INDEX unique_idempotency_keys__user_id__key;
+
+CREATE UNIQUE INDEX unique_idempotency_keys__user_id__client_ip__key ON idempotency_keys (user_id, client_ip, key);
+
+-- +migrate Down
+DROP INDEX unique_idempotency_keys__user_id__client_ip__key;
+
+-- Keys of different clients may collide once the IP is gone
+DELETE FROM idempotency_keys
+WHERE user_id = 0;
+
+CREATE UNIQUE INDEX unique_idempotency_keys__user_id__key ON idempotency_keys (user_id, key);
+
+ALTER TABLE idempotency_keys
+  DROP response_headers;
+
+ALTER TABLE idempotency_keys
+  DROP client_ip;
@@ -0,0 +1,104 @@
+package graph
+
+import "github.com/nrawrx3/workout-backend/graph/model"
+
+// Cost of a field that hits the database on its own, as opposed to reading a
+// field off an already loaded object.
+const resolverFieldCost = 2
+
+// Mutations write and are never batched, so make them comparatively costly.
+const mutationCost = 10
+
+// A sync reads a batch of the change log and the entities in it
+const syncCost = 50
+
+// Out of range values of first are rejected by the resolvers, but costs are
+// computed before that. Charging them as given would let a negative first
+// lower the cost of the rest of the query.
+func listCost(childComplexity int, first *int, maxPageSize int) int {
+	n := defaultPageSize
+	if first != nil {
+		n = *first
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n > maxPageSize {
+		n = maxPageSize
+	}
+	return resolverFieldCost + n*childComplexity
+}
+
+// NewComplexityRoot returns per-field cost functions for use with the
+// extension.ComplexityLimit handler extension. List fields are charged by the
+// number of items they may return.
+func NewComplexityRoot(maxPageSize int) ComplexityRoot {
+	var c ComplexityRoot
+
+	c.Query.User = func(childComplexity int, id string) int {
+		return resolverFieldCost + childComplexity
+	}
+	c.Query.UserByEmail = func(childComplexity int, email string) int {
+		return resolverFieldCost + childComplexity
+	}
+	// Unpaginated, so charged for as many workouts as it returns at most
+	c.Query.Workouts = func(childComplexity int, userID string) int {
+		return resolverFieldCost + legacyWorkoutsLimit*childComplexity
+	}
+	c.Query.WorkoutsConnection = func(childComplexity int, first *int, after *string, filter *model.WorkoutFilter, orderBy *model.WorkoutOrderBy) int {
+		return listCost(childComplexity, first, maxPageSize)
+	}
+	c.Query.Search = func(childComplexity int, query string, first *int) int {
+		return listCost(childComplexity, first, maxPageSize)
+	}
+
+	c.Query.Sync = func(childComplexity int, sinceToken *string) int {
+		return syncCost + childComplexity
+	}
+
+	c.Workout.User = func(childComplexity int) int {
+		return resolverFieldCost + childComplexity
+	}
+
+	c.Mutation.CreateUser = func(childComplexity int, userName string, email string) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.Login = func(childComplexity int, email string, password string) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.LoginSecondFactor = func(childComplexity int, challenge string, code string) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.EnrollTotp = func(childComplexity int) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.ConfirmTotp = func(childComplexity int, code string) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.CreateWorkout = func(childComplexity int, userID string, kind model.WorkoutKind, reps int, durationSeconds int, rounds *int, order int) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.UpdateWorkout = func(childComplexity int, workoutID string, kind model.WorkoutKind, reps int, durationSeconds int, rounds int, order int, expectedVersion *int) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.AddWorkout = func(childComplexity int, input model.CreateWorkoutInput) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.PatchWorkout = func(childComplexity int, workoutID string, expectedVersion int, patch model.UpdateWorkoutPatch) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.MoveWorkout = func(childComplexity int, workoutID string, afterWorkoutID *string, expectedVersion *int) int {
+		return mutationCost + childComplexity
+	}
+	c.Mutation.CreateWorkouts = func(childComplexity int, inputs []*model.CreateWorkoutInput) int {
+		return mutationCost + len(inputs)*childComplexity
+	}
+	c.Mutation.PushChanges = func(childComplexity int, changes []*model.WorkoutChangeInput) int {
+		return mutationCost*len(changes) + childComplexity
+	}
+	c.Mutation.ReorderWorkouts = func(childComplexity int, workoutIDAtRow []string, expectedListVersion *int) int {
+		return mutationCost + len(workoutIDAtRow)
+	}
+
+	return c
+}
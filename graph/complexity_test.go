@@ -0,0 +1,40 @@
+package graph
+
+import "testing"
+
+func TestListCostClampsFirst(t *testing.T) {
+	const maxPageSize = 100
+	intPtr := func(n int) *int { return &n }
+
+	cases := []struct {
+		name  string
+		first *int
+		want  int
+	}{
+		{"default", nil, resolverFieldCost + defaultPageSize*3},
+		{"in range", intPtr(10), resolverFieldCost + 10*3},
+		{"negative", intPtr(-1000), resolverFieldCost},
+		{"above the max", intPtr(1 << 40), resolverFieldCost + maxPageSize*3},
+	}
+	for _, tc := range cases {
+		if got := listCost(3, tc.first, maxPageSize); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+// Costs must not depend on argument values the resolvers would reject, nor on
+// the configured page size for the unpaginated list
+func TestWorkoutListCostsAreBounded(t *testing.T) {
+	c := NewComplexityRoot(100)
+	negative := -1000000
+	if got := c.Query.WorkoutsConnection(5, &negative, nil, nil, nil); got < resolverFieldCost {
+		t.Errorf("workoutsConnection with a negative first costs %d", got)
+	}
+	if got := c.Query.Search(5, "push", &negative); got < resolverFieldCost {
+		t.Errorf("search with a negative first costs %d", got)
+	}
+	if a, b := NewComplexityRoot(10).Query.Workouts(5, "1"), NewComplexityRoot(1000).Query.Workouts(5, "1"); a != b || a != resolverFieldCost+legacyWorkoutsLimit*5 {
+		t.Errorf("workouts costs %d and %d, want %d", a, b, resolverFieldCost+legacyWorkoutsLimit*5)
+	}
+}
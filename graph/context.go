@@ -0,0 +1,59 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	backend_model "github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/util"
+)
+
+var errNoSessionInContext = apperror.Unauthenticated("no user session in request context")
+
+// The session is put into the context by middleware.SessionChecker which wraps
+// the graphql handler.
+func sessionFromContext(ctx context.Context) (backend_model.UserSession, error) {
+	session, ok := ctx.Value(backend_model.UserSessionContextKey{}).(backend_model.UserSession)
+	if !ok {
+		return session, errNoSessionInContext
+	}
+	return session, nil
+}
+
+// The writer is put into the context by middleware.ResponseWriterInContext.
+// It's missing for operations over websockets, whose response headers are
+// long gone.
+func responseWriterFromContext(ctx context.Context) (http.ResponseWriter, error) {
+	w, ok := ctx.Value(backend_model.ResponseWriterContextKey{}).(http.ResponseWriter)
+	if !ok {
+		return nil, apperror.InvalidInput("operation must be sent over http")
+	}
+	return w, nil
+}
+
+// Keeps a response carrying secrets out of caches, including the responses the
+// idempotency middleware stores for replays. Nothing stores responses sent
+// over websockets, so it's fine without a writer.
+func markNoStore(ctx context.Context) {
+	if w, ok := ctx.Value(backend_model.ResponseWriterContextKey{}).(http.ResponseWriter); ok {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+}
+
+// Parses the user_id argument of the deprecated operations that take one,
+// which must be the logged in user's
+func sessionUserID(ctx context.Context, userID string) (uint64, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	id, err := util.Uint64FromStringID(userID)
+	if err != nil {
+		return 0, err
+	}
+	if id != session.UserID {
+		return 0, apperror.Forbidden("user_id is not the logged in user's")
+	}
+	return id, nil
+}
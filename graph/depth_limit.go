@@ -0,0 +1,77 @@
+package graph
+
+import (
+	"context"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const errCodeDepthLimitExceeded = "DEPTH_LIMIT_EXCEEDED"
+
+// DepthLimit is a handler extension that rejects operations whose selection
+// sets nest deeper than MaxDepth. Introspection fields are not counted since
+// the standard introspection query is deep but cheap.
+type DepthLimit struct {
+	MaxDepth int
+}
+
+var _ interface {
+	graphql.OperationContextMutator
+	graphql.HandlerExtension
+} = DepthLimit{}
+
+func (d DepthLimit) ExtensionName() string {
+	return "DepthLimit"
+}
+
+func (d DepthLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (d DepthLimit) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	op := rc.Doc.Operations.ForName(rc.OperationName)
+	if op == nil {
+		return nil
+	}
+
+	depth := selectionSetDepth(op.SelectionSet, map[string]bool{})
+	if depth > d.MaxDepth {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", depth, d.MaxDepth)
+		errcode.Set(err, errCodeDepthLimitExceeded)
+		return err
+	}
+	return nil
+}
+
+// visiting guards against fragment cycles, which validation should have
+// already rejected.
+func selectionSetDepth(set ast.SelectionSet, visiting map[string]bool) int {
+	maxDepth := 0
+	for _, sel := range set {
+		depth := 0
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name, "__") {
+				continue
+			}
+			depth = 1 + selectionSetDepth(sel.SelectionSet, visiting)
+		case *ast.InlineFragment:
+			depth = selectionSetDepth(sel.SelectionSet, visiting)
+		case *ast.FragmentSpread:
+			if sel.Definition == nil || visiting[sel.Name] {
+				continue
+			}
+			visiting[sel.Name] = true
+			depth = selectionSetDepth(sel.Definition.SelectionSet, visiting)
+			delete(visiting, sel.Name)
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	return maxDepth
+}
@@ -0,0 +1,52 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/rs/zerolog/log"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// ErrorPresenter sets extensions.code on errors returned by resolvers and
+// replaces the message of internal errors with a generic one. Errors raised by
+// gqlgen itself (parse, validation, complexity) already carry a code and are
+// passed through.
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+	cause := gqlErr.Unwrap()
+	if cause == nil {
+		return gqlErr
+	}
+
+	appErr := apperror.From(cause)
+	if appErr.Code == apperror.CodeInternal {
+		log.Error().Err(cause).Str("gql_path", gqlErr.Path.String()).Msg("internal error in resolver")
+	}
+
+	presented := &gqlerror.Error{
+		Message:    appErr.Message,
+		Path:       gqlErr.Path,
+		Locations:  gqlErr.Locations,
+		Extensions: gqlErr.Extensions,
+	}
+	errcode.Set(presented, string(appErr.Code))
+	if len(appErr.Fields) != 0 {
+		presented.Extensions["fields"] = appErr.Fields
+	}
+	for k, v := range appErr.Details {
+		presented.Extensions[k] = v
+	}
+	return presented
+}
+
+// RecoverFunc logs panics in resolvers and turns them into internal errors so
+// that the panic value never reaches the client.
+func RecoverFunc(ctx context.Context, rec interface{}) error {
+	log.Error().Interface("panic", rec).Str("stack", string(debug.Stack())).Msg("recovered from panic in resolver")
+	return apperror.Internal(fmt.Errorf("panic: %v", rec))
+}
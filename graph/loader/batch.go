@@ -0,0 +1,156 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/rs/zerolog/log"
+)
+
+// fetchFunc loads the values for the given keys in one go. Keys missing from
+// the returned map are reported as constants.ErrCodeNotFound to the callers.
+type fetchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+type result[V any] struct {
+	value V
+	err   error
+	done  chan struct{}
+}
+
+type batch[K comparable, V any] struct {
+	keys    []K
+	results map[K]*result[V]
+}
+
+// batchLoader collects the keys requested by concurrently running resolvers
+// within a short window and loads them with a single fetch. Results are cached
+// for the lifetime of the loader, which is one request.
+type batchLoader[K comparable, V any] struct {
+	fetch    fetchFunc[K, V]
+	wait     time.Duration
+	maxBatch int
+
+	mu    sync.Mutex
+	cache map[K]*result[V]
+	batch *batch[K, V]
+}
+
+func newBatchLoader[K comparable, V any](fetch fetchFunc[K, V], wait time.Duration, maxBatch int) *batchLoader[K, V] {
+	return &batchLoader[K, V]{
+		fetch:    fetch,
+		wait:     wait,
+		maxBatch: maxBatch,
+		cache:    make(map[K]*result[V]),
+	}
+}
+
+func (l *batchLoader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	r := l.enqueue(ctx, key)
+	<-r.done
+	return r.value, r.err
+}
+
+// LoadMany returns the values and errors in the same order as keys, so that
+// callers can decide what to do with the keys that failed.
+func (l *batchLoader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, []error) {
+	results := make([]*result[V], len(keys))
+	for i, key := range keys {
+		results[i] = l.enqueue(ctx, key)
+	}
+
+	values := make([]V, len(keys))
+	errs := make([]error, len(keys))
+	for i, r := range results {
+		<-r.done
+		values[i], errs[i] = r.value, r.err
+	}
+	return values, errs
+}
+
+// Drops the cached value of key so that the next Load fetches it again. A
+// fetch still in flight is left alone.
+func (l *batchLoader[K, V]) Forget(key K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.cache[key]
+	if !ok {
+		return
+	}
+	select {
+	case <-r.done:
+		delete(l.cache, key)
+	default:
+	}
+}
+
+func (l *batchLoader[K, V]) enqueue(ctx context.Context, key K) *result[V] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r, ok := l.cache[key]; ok {
+		return r
+	}
+
+	r := &result[V]{done: make(chan struct{})}
+	l.cache[key] = r
+
+	if l.batch == nil {
+		l.batch = &batch[K, V]{results: make(map[K]*result[V])}
+		go l.dispatchAfterWait(ctx, l.batch)
+	}
+	b := l.batch
+	b.keys = append(b.keys, key)
+	b.results[key] = r
+
+	if len(b.keys) >= l.maxBatch {
+		l.batch = nil
+		go l.dispatch(ctx, b)
+	}
+	return r
+}
+
+func (l *batchLoader[K, V]) dispatchAfterWait(ctx context.Context, b *batch[K, V]) {
+	time.Sleep(l.wait)
+
+	l.mu.Lock()
+	if l.batch != b {
+		// Already dispatched because it hit maxBatch
+		l.mu.Unlock()
+		return
+	}
+	l.batch = nil
+	l.mu.Unlock()
+
+	l.dispatch(ctx, b)
+}
+
+func (l *batchLoader[K, V]) dispatch(ctx context.Context, b *batch[K, V]) {
+	values, err := l.fetchRecovered(ctx, b.keys)
+	for key, r := range b.results {
+		if err != nil {
+			r.err = err
+		} else if v, ok := values[key]; ok {
+			r.value = v
+		} else {
+			r.err = constants.ErrCodeNotFound
+		}
+		close(r.done)
+	}
+}
+
+// Runs in a goroutine of its own, where a panic would crash the server and
+// leave the waiting resolvers blocked. It fails the batch instead.
+func (l *batchLoader[K, V]) fetchRecovered(ctx context.Context, keys []K) (values map[K]V, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Error().Interface("panic", rec).Bytes("stack", debug.Stack()).Msg("batch loader fetch panicked")
+			err = fmt.Errorf("batch loader fetch panicked: %v", rec)
+		}
+	}()
+	return l.fetch(ctx, keys)
+}
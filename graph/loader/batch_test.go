@@ -0,0 +1,61 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+)
+
+func TestBatchLoaderBatchesConcurrentLoads(t *testing.T) {
+	var fetches [][]int
+	l := newBatchLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+		fetches = append(fetches, keys)
+		values := map[int]string{}
+		for _, key := range keys {
+			if key != 3 {
+				values[key] = "value"
+			}
+		}
+		return values, nil
+	}, time.Millisecond, 100)
+
+	values, errs := l.LoadMany(context.Background(), []int{1, 2, 3, 1})
+	if len(fetches) != 1 || len(fetches[0]) != 3 {
+		t.Fatalf("got fetches %v, want one of 3 keys", fetches)
+	}
+	for i, want := range []string{"value", "value", "", "value"} {
+		if values[i] != want {
+			t.Fatalf("value %d: got %q, want %q", i, values[i], want)
+		}
+	}
+	if !errors.Is(errs[2], constants.ErrCodeNotFound) || errs[0] != nil {
+		t.Fatalf("got errors %v", errs)
+	}
+}
+
+// The fetch runs in a goroutine of its own, a panic there must fail the loads
+// instead of crashing the process or blocking them forever
+func TestBatchLoaderFailsLoadsWhenFetchPanics(t *testing.T) {
+	for _, maxBatch := range []int{1, 100} {
+		l := newBatchLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+			panic("boom")
+		}, time.Millisecond, maxBatch)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := l.Load(context.Background(), 1)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatalf("maxBatch %d: load succeeded", maxBatch)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("maxBatch %d: load is still blocked", maxBatch)
+		}
+	}
+}
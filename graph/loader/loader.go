@@ -0,0 +1,80 @@
+// Package loader batches the by-id lookups made by graphql field resolvers so
+// that resolving a list of N objects doesn't issue N queries for a nested
+// field. A fresh set of loaders is attached to each request by Middleware.
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+)
+
+const (
+	batchWait = 2 * time.Millisecond
+	maxBatch  = 100
+)
+
+var ErrNoLoadersInContext = errors.New("no dataloaders in request context")
+
+type loadersContextKey struct{}
+
+type Loaders struct {
+	Users    *batchLoader[uint64, model.User]
+	Workouts *batchLoader[uint64, model.Workout]
+	// Positions of each user's workouts, keyed by user id
+	WorkoutPositions *batchLoader[uint64, map[uint64]int]
+}
+
+func NewLoaders(userStore *store.UserStore, workoutStore *store.WorkoutStore) *Loaders {
+	return &Loaders{
+		Users:    newBatchLoader(userStore.GetUsersByIDs, batchWait, maxBatch),
+		Workouts: newBatchLoader(workoutStore.GetWorkoutsByIDs, batchWait, maxBatch),
+
+		WorkoutPositions: newBatchLoader(workoutStore.GetWorkoutPositions, batchWait, maxBatch),
+	}
+}
+
+// Returns the position of the workout in its user's list. Positions are cached
+// for the request, so a workout created after they were loaded triggers one
+// reload. Moves made within the same request may not be reflected.
+func (l *Loaders) WorkoutPosition(ctx context.Context, userID, workoutID uint64) (int, error) {
+	for reloaded := false; ; reloaded = true {
+		positions, err := l.WorkoutPositions.Load(ctx, userID)
+		if err != nil {
+			return 0, err
+		}
+		if position, ok := positions[workoutID]; ok {
+			return position, nil
+		}
+		if reloaded {
+			return 0, fmt.Errorf("%w: workout %d is not in the list of user %d", constants.ErrCodeNotFound, workoutID, userID)
+		}
+		l.WorkoutPositions.Forget(userID)
+	}
+}
+
+// Middleware attaches a new set of loaders to every request so that cached
+// values never leak across requests (or users).
+func Middleware(userStore *store.UserStore, workoutStore *store.WorkoutStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			loaders := NewLoaders(userStore, workoutStore)
+			ctx := context.WithValue(r.Context(), loadersContextKey{}, loaders)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func FromContext(ctx context.Context) (*Loaders, error) {
+	loaders, ok := ctx.Value(loadersContextKey{}).(*Loaders)
+	if !ok {
+		return nil, ErrNoLoadersInContext
+	}
+	return loaders, nil
+}
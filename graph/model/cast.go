@@ -1,6 +1,12 @@
 package model
 
-import backend_model "github.com/nrawrx3/workout-backend/model"
+import (
+	"strconv"
+
+	backend_model "github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/validation"
+)
 
 func (w WorkoutKind) CastToModelKind() backend_model.WorkoutKind {
 	switch w {
@@ -31,3 +37,96 @@ func WorkoutKindFromModel(kind backend_model.WorkoutKind) WorkoutKind {
 		return WorkoutKindPushUps
 	}
 }
+
+func UserFromModel(u *backend_model.User) *User {
+	return &User{
+		ID:       strconv.FormatUint(u.ID, 10),
+		UserName: u.UserName,
+		Email:    u.Email,
+	}
+}
+
+func WorkoutFromModel(w *backend_model.Workout) *Workout {
+	return &Workout{
+		ID:              strconv.FormatUint(w.ID, 10),
+		Reps:            w.Reps,
+		Rounds:          w.Rounds,
+		DurationSeconds: w.DurationSeconds,
+		UserID:          strconv.FormatUint(w.UserID, 10),
+		Kind:            WorkoutKindFromModel(w.Kind),
+		OrderKey:        w.OrderKey,
+		Version:         w.Version,
+		ClientID:        w.ClientID,
+	}
+}
+
+func StringIDs(ids []uint64) []string {
+	strIDs := make([]string, len(ids))
+	for i, id := range ids {
+		strIDs[i] = strconv.FormatUint(id, 10)
+	}
+	return strIDs
+}
+
+func (f *WorkoutFilter) ToStoreFilter() store.WorkoutFilter {
+	var filter store.WorkoutFilter
+	if f == nil {
+		return filter
+	}
+	for _, k := range f.Kinds {
+		filter.Kinds = append(filter.Kinds, k.CastToModelKind())
+	}
+	if f.Created != nil {
+		filter.Created = store.TimeRange{After: f.Created.After, Before: f.Created.Before}
+	}
+	if f.Rounds != nil {
+		filter.Rounds = store.IntRange{Min: f.Rounds.Min, Max: f.Rounds.Max}
+	}
+	if f.Reps != nil {
+		filter.Reps = store.IntRange{Min: f.Reps.Min, Max: f.Reps.Max}
+	}
+	return filter
+}
+
+func (o *WorkoutOrderBy) ToStoreSort() (field store.WorkoutSortField, descending bool) {
+	if o == nil {
+		return store.WorkoutSortByOrder, false
+	}
+	switch o.Field {
+	case WorkoutOrderFieldCreatedAt:
+		field = store.WorkoutSortByCreatedAt
+	default:
+		field = store.WorkoutSortByOrder
+	}
+	return field, o.Direction != nil && *o.Direction == OrderDirectionDesc
+}
+
+// Applies the fields set in the patch to w, except for the order. Returns the
+// changed fields for validation and the columns to write.
+func (p *UpdateWorkoutPatch) Apply(w *backend_model.Workout) (validation.WorkoutFields, []string) {
+	var columns []string
+	if p.Kind != nil {
+		w.Kind = p.Kind.CastToModelKind()
+		columns = append(columns, "kind")
+	}
+	fields := validation.WorkoutFields{Kind: w.Kind}
+
+	if p.Reps != nil {
+		w.Reps = *p.Reps
+		fields.Reps = &w.Reps
+		columns = append(columns, "reps")
+	}
+	if p.DurationSeconds != nil {
+		w.DurationSeconds = *p.DurationSeconds
+		fields.DurationSeconds = &w.DurationSeconds
+		columns = append(columns, "duration_seconds")
+	}
+	if p.Rounds != nil {
+		w.Rounds = *p.Rounds
+		fields.Rounds = &w.Rounds
+		columns = append(columns, "rounds")
+	}
+	// Moving is done by the store, it only needs validating here
+	fields.Order = p.Order
+	return fields, columns
+}
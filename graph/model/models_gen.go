@@ -6,14 +6,113 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 )
 
+type ChangeResult struct {
+	OpID       string       `json:"op_id"`
+	Status     ChangeStatus `json:"status"`
+	Workout    *Workout     `json:"workout"`
+	UserErrors []*UserError `json:"user_errors"`
+}
+
+type CreateWorkoutInput struct {
+	Kind            WorkoutKind `json:"kind"`
+	Reps            int         `json:"reps"`
+	DurationSeconds int         `json:"duration_seconds"`
+	Rounds          *int        `json:"rounds"`
+	Order           *int        `json:"order"`
+}
+
+type CreateWorkoutsPayload struct {
+	Workouts   []*Workout   `json:"workouts"`
+	UserErrors []*UserError `json:"user_errors"`
+}
+
+type IntRange struct {
+	Min *int `json:"min"`
+	Max *int `json:"max"`
+}
+
+type LoginPayload struct {
+	User                  *User                  `json:"user"`
+	ExpiresAt             *time.Time             `json:"expires_at"`
+	SecondFactorChallenge *SecondFactorChallenge `json:"second_factor_challenge"`
+	UserErrors            []*UserError           `json:"user_errors"`
+}
+
+type PageInfo struct {
+	HasNextPage bool    `json:"has_next_page"`
+	EndCursor   *string `json:"end_cursor"`
+}
+
+type PushChangesPayload struct {
+	Results []*ChangeResult `json:"results"`
+}
+
+type SearchHit struct {
+	Kind    SearchHitKind `json:"kind"`
+	ID      string        `json:"id"`
+	Snippet string        `json:"snippet"`
+	Rank    float64       `json:"rank"`
+	Workout *Workout      `json:"workout"`
+}
+
+type SecondFactorChallenge struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SyncResult struct {
+	Token              string       `json:"token"`
+	HasMore            bool         `json:"has_more"`
+	Workouts           []*Workout   `json:"workouts"`
+	DeletedWorkouts    []*Tombstone `json:"deleted_workouts"`
+	WorkoutListVersion int          `json:"workout_list_version"`
+}
+
+type TimeRange struct {
+	After  *time.Time `json:"after"`
+	Before *time.Time `json:"before"`
+}
+
+type Tombstone struct {
+	ID        string    `json:"id"`
+	ClientID  *string   `json:"client_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type TotpConfirmationPayload struct {
+	RecoveryCodes []string     `json:"recovery_codes"`
+	UserErrors    []*UserError `json:"user_errors"`
+}
+
+type TotpEnrollmentPayload struct {
+	OtpauthURI *string      `json:"otpauth_uri"`
+	Secret     *string      `json:"secret"`
+	UserErrors []*UserError `json:"user_errors"`
+}
+
+type UpdateWorkoutPatch struct {
+	Kind            *WorkoutKind `json:"kind"`
+	Reps            *int         `json:"reps"`
+	DurationSeconds *int         `json:"duration_seconds"`
+	Rounds          *int         `json:"rounds"`
+	Order           *int         `json:"order"`
+}
+
 type User struct {
 	ID       string `json:"id"`
 	UserName string `json:"user_name"`
 	Email    string `json:"email"`
 }
 
+type UserError struct {
+	Field   *string `json:"field"`
+	Message string  `json:"message"`
+	Code    string  `json:"code"`
+}
+
 type Workout struct {
 	ID              string      `json:"id"`
 	Reps            int         `json:"reps"`
@@ -21,7 +120,218 @@ type Workout struct {
 	DurationSeconds int         `json:"duration_seconds"`
 	Kind            WorkoutKind `json:"kind"`
 	Order           int         `json:"order"`
+	OrderKey        string      `json:"order_key"`
 	UserID          string      `json:"user_id"`
+	User            *User       `json:"user"`
+	Version         int         `json:"version"`
+	ClientID        *string     `json:"client_id"`
+}
+
+type WorkoutChangeInput struct {
+	OpID            string              `json:"op_id"`
+	Op              ChangeOp            `json:"op"`
+	WorkoutID       *string             `json:"workout_id"`
+	ClientID        *string             `json:"client_id"`
+	ExpectedVersion *int                `json:"expected_version"`
+	Create          *CreateWorkoutInput `json:"create"`
+	Patch           *UpdateWorkoutPatch `json:"patch"`
+}
+
+type WorkoutConnection struct {
+	Edges      []*WorkoutEdge `json:"edges"`
+	PageInfo   *PageInfo      `json:"page_info"`
+	TotalCount int            `json:"total_count"`
+}
+
+type WorkoutEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Workout `json:"node"`
+}
+
+type WorkoutFilter struct {
+	Kinds   []WorkoutKind `json:"kinds"`
+	Created *TimeRange    `json:"created"`
+	Rounds  *IntRange     `json:"rounds"`
+	Reps    *IntRange     `json:"reps"`
+}
+
+type WorkoutOrderBy struct {
+	Field     WorkoutOrderField `json:"field"`
+	Direction *OrderDirection   `json:"direction"`
+}
+
+type WorkoutPayload struct {
+	Workout        *Workout     `json:"workout"`
+	UserErrors     []*UserError `json:"user_errors"`
+	CurrentWorkout *Workout     `json:"current_workout"`
+}
+
+type ChangeOp string
+
+const (
+	ChangeOpCreate ChangeOp = "CREATE"
+	ChangeOpUpdate ChangeOp = "UPDATE"
+	ChangeOpDelete ChangeOp = "DELETE"
+)
+
+var AllChangeOp = []ChangeOp{
+	ChangeOpCreate,
+	ChangeOpUpdate,
+	ChangeOpDelete,
+}
+
+func (e ChangeOp) IsValid() bool {
+	switch e {
+	case ChangeOpCreate, ChangeOpUpdate, ChangeOpDelete:
+		return true
+	}
+	return false
+}
+
+func (e ChangeOp) String() string {
+	return string(e)
+}
+
+func (e *ChangeOp) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ChangeOp(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ChangeOp", str)
+	}
+	return nil
+}
+
+func (e ChangeOp) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ChangeStatus string
+
+const (
+	ChangeStatusApplied   ChangeStatus = "APPLIED"
+	ChangeStatusDuplicate ChangeStatus = "DUPLICATE"
+	ChangeStatusConflict  ChangeStatus = "CONFLICT"
+	ChangeStatusRejected  ChangeStatus = "REJECTED"
+)
+
+var AllChangeStatus = []ChangeStatus{
+	ChangeStatusApplied,
+	ChangeStatusDuplicate,
+	ChangeStatusConflict,
+	ChangeStatusRejected,
+}
+
+func (e ChangeStatus) IsValid() bool {
+	switch e {
+	case ChangeStatusApplied, ChangeStatusDuplicate, ChangeStatusConflict, ChangeStatusRejected:
+		return true
+	}
+	return false
+}
+
+func (e ChangeStatus) String() string {
+	return string(e)
+}
+
+func (e *ChangeStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ChangeStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ChangeStatus", str)
+	}
+	return nil
+}
+
+func (e ChangeStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type OrderDirection string
+
+const (
+	OrderDirectionAsc  OrderDirection = "ASC"
+	OrderDirectionDesc OrderDirection = "DESC"
+)
+
+var AllOrderDirection = []OrderDirection{
+	OrderDirectionAsc,
+	OrderDirectionDesc,
+}
+
+func (e OrderDirection) IsValid() bool {
+	switch e {
+	case OrderDirectionAsc, OrderDirectionDesc:
+		return true
+	}
+	return false
+}
+
+func (e OrderDirection) String() string {
+	return string(e)
+}
+
+func (e *OrderDirection) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OrderDirection(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OrderDirection", str)
+	}
+	return nil
+}
+
+func (e OrderDirection) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type SearchHitKind string
+
+const (
+	SearchHitKindWorkout SearchHitKind = "WORKOUT"
+)
+
+var AllSearchHitKind = []SearchHitKind{
+	SearchHitKindWorkout,
+}
+
+func (e SearchHitKind) IsValid() bool {
+	switch e {
+	case SearchHitKindWorkout:
+		return true
+	}
+	return false
+}
+
+func (e SearchHitKind) String() string {
+	return string(e)
+}
+
+func (e *SearchHitKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SearchHitKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SearchHitKind", str)
+	}
+	return nil
+}
+
+func (e SearchHitKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
 
 type WorkoutKind string
@@ -68,3 +378,44 @@ func (e *WorkoutKind) UnmarshalGQL(v interface{}) error {
 func (e WorkoutKind) MarshalGQL(w io.Writer) {
 	fmt.Fprint(w, strconv.Quote(e.String()))
 }
+
+type WorkoutOrderField string
+
+const (
+	WorkoutOrderFieldOrder     WorkoutOrderField = "ORDER"
+	WorkoutOrderFieldCreatedAt WorkoutOrderField = "CREATED_AT"
+)
+
+var AllWorkoutOrderField = []WorkoutOrderField{
+	WorkoutOrderFieldOrder,
+	WorkoutOrderFieldCreatedAt,
+}
+
+func (e WorkoutOrderField) IsValid() bool {
+	switch e {
+	case WorkoutOrderFieldOrder, WorkoutOrderFieldCreatedAt:
+		return true
+	}
+	return false
+}
+
+func (e WorkoutOrderField) String() string {
+	return string(e)
+}
+
+func (e *WorkoutOrderField) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WorkoutOrderField(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WorkoutOrderField", str)
+	}
+	return nil
+}
+
+func (e WorkoutOrderField) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -0,0 +1,62 @@
+package graph
+
+import (
+	"fmt"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/graph/model"
+	backend_model "github.com/nrawrx3/workout-backend/model"
+)
+
+// Errors the client can fix by changing the input go into the user_errors of
+// mutation payloads. Everything else is returned as a graphql error.
+func isUserError(appErr *apperror.Error) bool {
+	switch appErr.Code {
+	case apperror.CodeInvalidInput, apperror.CodeNotFound, apperror.CodeConflict:
+		return true
+	}
+	return false
+}
+
+func userErrorsFrom(appErr *apperror.Error) []*model.UserError {
+	if len(appErr.Fields) == 0 {
+		return []*model.UserError{{Message: appErr.Message, Code: string(appErr.Code)}}
+	}
+
+	userErrors := make([]*model.UserError, 0, len(appErr.Fields))
+	for i := range appErr.Fields {
+		userErrors = append(userErrors, &model.UserError{
+			Field:   &appErr.Fields[i].Field,
+			Message: appErr.Fields[i].Message,
+			Code:    string(appErr.Code),
+		})
+	}
+	return userErrors
+}
+
+// The session's User must be loaded
+func loginPayloadFromSession(session *backend_model.UserSession) *model.LoginPayload {
+	return &model.LoginPayload{
+		User:       model.UserFromModel(&session.User),
+		ExpiresAt:  &session.ExpiresAt,
+		UserErrors: []*model.UserError{},
+	}
+}
+
+func workoutConflictPayload(current *backend_model.Workout) *model.WorkoutPayload {
+	return &model.WorkoutPayload{
+		UserErrors: []*model.UserError{{
+			Message: fmt.Sprintf("workout was modified concurrently, it is now at version %d", current.Version),
+			Code:    string(apperror.CodeConflict),
+		}},
+		CurrentWorkout: model.WorkoutFromModel(current),
+	}
+}
+
+func workoutPayloadFromError(err error) (*model.WorkoutPayload, error) {
+	appErr := apperror.From(err)
+	if !isUserError(appErr) {
+		return nil, err
+	}
+	return &model.WorkoutPayload{UserErrors: userErrorsFrom(appErr)}, nil
+}
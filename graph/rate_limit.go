@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// RateLimitFields is a handler extension throttling root mutation fields by a
+// rate limit group of their own, on top of the limit of the whole endpoint.
+// It's meant for login, which would otherwise allow far more password guesses
+// than POST /login. Each selection of a field takes a token, so aliasing it
+// doesn't multiply the attempts.
+type RateLimitFields struct {
+	// Root mutation field name to rate limit group
+	Fields map[string]string
+	// Takes a token of the group for the client, returning an error if it
+	// has none left
+	Take func(ctx context.Context, group string) error
+}
+
+var _ interface {
+	graphql.FieldInterceptor
+	graphql.HandlerExtension
+} = RateLimitFields{}
+
+func (l RateLimitFields) ExtensionName() string {
+	return "RateLimitFields"
+}
+
+func (l RateLimitFields) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (l RateLimitFields) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc != nil && fc.Object == "Mutation" {
+		if group, ok := l.Fields[fc.Field.Name]; ok {
+			if err := l.Take(ctx, group); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return next(ctx)
+}
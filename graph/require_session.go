@@ -0,0 +1,54 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/nrawrx3/workout-backend/apperror"
+	backend_model "github.com/nrawrx3/workout-backend/model"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// RequireSession is a handler extension that rejects operations sent without a
+// session, unless they only select root fields listed in PublicFields. The
+// graphql endpoint is wrapped in middleware.SessionChecker.OptionalHandler so
+// that the login mutation can be reached.
+type RequireSession struct {
+	// Root field names, e.g. "login"
+	PublicFields map[string]bool
+}
+
+var _ interface {
+	graphql.OperationContextMutator
+	graphql.HandlerExtension
+} = RequireSession{}
+
+func (s RequireSession) ExtensionName() string {
+	return "RequireSession"
+}
+
+func (s RequireSession) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (s RequireSession) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	if _, ok := ctx.Value(backend_model.UserSessionContextKey{}).(backend_model.UserSession); ok {
+		return nil
+	}
+
+	op := rc.Doc.Operations.ForName(rc.OperationName)
+	if op == nil {
+		return nil
+	}
+
+	for _, field := range graphql.CollectFields(rc, op.SelectionSet, nil) {
+		if field.Name == "__typename" || s.PublicFields[field.Name] {
+			continue
+		}
+		err := gqlerror.Errorf("must be logged in to query %s", field.Name)
+		errcode.Set(err, string(apperror.CodeUnauthenticated))
+		return err
+	}
+	return nil
+}
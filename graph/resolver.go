@@ -1,17 +1,59 @@
 package graph
 
-import "gorm.io/gorm"
+import (
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/auth"
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/validation"
+	"gorm.io/gorm"
+)
 
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
+const defaultPageSize = 20
+
+// The workouts query isn't paginated, it returns the first this many. Fixed
+// rather than MaxPageSize so that its cost doesn't change with the config.
+const legacyWorkoutsLimit = 100
+
 type Resolver struct {
-	DB *gorm.DB
+	DB           *gorm.DB
+	WorkoutStore *store.WorkoutStore
+	SearchStore  *store.SearchStore
+	SyncStore    *store.SyncStore
+	MaxPageSize  int
+	// Set by the app, used by the login mutation
+	Authenticator *auth.Authenticator
+
+	WorkoutValidator *validation.WorkoutValidator
 }
 
 func NewResolver(db *gorm.DB) *Resolver {
+	// Can't fail without limits to parse
+	workoutValidator, _ := validation.NewWorkoutValidator(nil)
+
 	return &Resolver{
-		DB: db,
+		DB:           db,
+		WorkoutStore: store.NewWorkoutStore(db),
+		SearchStore:  store.NewSearchStore(db),
+		SyncStore:    store.NewSyncStore(db),
+		MaxPageSize:  config.DefaultGraphQLMaxPageSize,
+
+		WorkoutValidator: workoutValidator,
+	}
+}
+
+// Returns the page size to use for the given first argument of a paginated
+// field, or an error if it's out of bounds.
+func (r *Resolver) pageSize(first *int) (int, error) {
+	if first == nil {
+		return defaultPageSize, nil
+	}
+	if *first < 0 || *first > r.MaxPageSize {
+		return 0, apperror.InvalidInput("first must be between 0 and %d, got %d", r.MaxPageSize, *first)
 	}
+	return *first, nil
 }
@@ -8,12 +8,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
 
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/graph/loader"
 	"github.com/nrawrx3/workout-backend/graph/model"
 	backend_model "github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
 	"github.com/nrawrx3/workout-backend/util"
+	"github.com/nrawrx3/workout-backend/validation"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
@@ -27,6 +32,9 @@ func (r *mutationResolver) CreateUser(ctx context.Context, userName string, emai
 
 	err := r.DB.Create(newUser).Error
 	if err != nil {
+		if store.IsUniqueConstraintError(err) {
+			return nil, apperror.New(apperror.CodeConflict, "user with email %s already exists", email)
+		}
 		return nil, fmt.Errorf("%w: failed to create user with email %s", err, email)
 	}
 
@@ -34,6 +42,97 @@ func (r *mutationResolver) CreateUser(ctx context.Context, userName string, emai
 	return &newUserID, nil
 }
 
+// Login is the resolver for the login field.
+func (r *mutationResolver) Login(ctx context.Context, email string, password string) (*model.LoginPayload, error) {
+	w, err := responseWriterFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := backend_model.UserLoginRequestBody{Email: email, Password: password}
+	result, err := r.Authenticator.Login(ctx, w, credentials, graphql.GetOperationContext(ctx).Headers.Get("User-Agent"))
+	if err != nil {
+		appErr := apperror.From(err)
+		if appErr.Code != apperror.CodeNotFound && appErr.Code != apperror.CodeWrongPassword {
+			return nil, err
+		}
+		return &model.LoginPayload{UserErrors: userErrorsFrom(appErr)}, nil
+	}
+
+	if result.Challenge != nil {
+		return &model.LoginPayload{
+			SecondFactorChallenge: &model.SecondFactorChallenge{
+				Challenge: result.Challenge.Token,
+				ExpiresAt: result.Challenge.ExpiresAt,
+			},
+			UserErrors: []*model.UserError{},
+		}, nil
+	}
+	return loginPayloadFromSession(&result.Session), nil
+}
+
+// LoginSecondFactor is the resolver for the login_second_factor field.
+func (r *mutationResolver) LoginSecondFactor(ctx context.Context, challenge string, code string) (*model.LoginPayload, error) {
+	w, err := responseWriterFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := r.Authenticator.CompleteSecondFactor(ctx, w, challenge, code, graphql.GetOperationContext(ctx).Headers.Get("User-Agent"))
+	if err != nil {
+		appErr := apperror.From(err)
+		if appErr.Code != apperror.CodeUnauthenticated && appErr.Code != apperror.CodeWrongSecondFactor {
+			return nil, err
+		}
+		return &model.LoginPayload{UserErrors: userErrorsFrom(appErr)}, nil
+	}
+	return loginPayloadFromSession(&session), nil
+}
+
+// EnrollTotp is the resolver for the enroll_totp field.
+func (r *mutationResolver) EnrollTotp(ctx context.Context) (*model.TotpEnrollmentPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	markNoStore(ctx)
+
+	enrollment, err := r.Authenticator.EnrollTOTP(ctx, session.User)
+	if err != nil {
+		appErr := apperror.From(err)
+		if appErr.Code != apperror.CodeConflict && appErr.Code != apperror.CodeForbidden {
+			return nil, err
+		}
+		return &model.TotpEnrollmentPayload{UserErrors: userErrorsFrom(appErr)}, nil
+	}
+	return &model.TotpEnrollmentPayload{
+		OtpauthURI: &enrollment.URI,
+		Secret:     &enrollment.Secret,
+		UserErrors: []*model.UserError{},
+	}, nil
+}
+
+// ConfirmTotp is the resolver for the confirm_totp field.
+func (r *mutationResolver) ConfirmTotp(ctx context.Context, code string) (*model.TotpConfirmationPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	markNoStore(ctx)
+
+	recoveryCodes, err := r.Authenticator.ConfirmTOTP(ctx, session.UserID, code)
+	if err != nil {
+		appErr := apperror.From(err)
+		if !isUserError(appErr) && appErr.Code != apperror.CodeWrongSecondFactor {
+			return nil, err
+		}
+		return &model.TotpConfirmationPayload{RecoveryCodes: []string{}, UserErrors: userErrorsFrom(appErr)}, nil
+	}
+	return &model.TotpConfirmationPayload{RecoveryCodes: recoveryCodes, UserErrors: []*model.UserError{}}, nil
+}
+
 // CreateWorkout is the resolver for the create_workout field.
 func (r *mutationResolver) CreateWorkout(ctx context.Context, userID string, kind model.WorkoutKind, reps int, durationSeconds int, rounds *int, order int) (*string, error) {
 	kindEnum := kind.CastToModelKind()
@@ -43,7 +142,20 @@ func (r *mutationResolver) CreateWorkout(ctx context.Context, userID string, kin
 		numRounds = *rounds
 	}
 
-	uintUserID, err := util.Uint64FromStringID(userID)
+	err := r.WorkoutValidator.Validate(validation.WorkoutFields{
+		Kind:            kindEnum,
+		Reps:            &reps,
+		DurationSeconds: &durationSeconds,
+		Rounds:          &numRounds,
+		Order:           &order,
+
+		AllowAppendOrder: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uintUserID, err := sessionUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -54,26 +166,16 @@ func (r *mutationResolver) CreateWorkout(ctx context.Context, userID string, kin
 		DurationSeconds: durationSeconds,
 		Rounds:          numRounds,
 		UserID:          uintUserID,
-		Order:           order,
 	}
 
-	// order -1 indicates we should insert with max order
-	if order == -1 {
-		var maxRelativeOrder struct {
-			Count int
-		}
-
-		const query = `select coalesce(max(relative_order), -1) as count from workouts where user_id = ?`
-		err := r.DB.Raw(query, []interface{}{uintUserID}).First(&maxRelativeOrder).Error
-		if err != nil {
-			log.Error().Str("gql_resolver", "failed to get max relative_order").Str("mutation", "create_workout").Err(err)
-			return nil, err
-		}
-		workout.Order = maxRelativeOrder.Count + 1
+	// order -1 indicates we should append to the end
+	var position *int
+	if order != validation.AppendWorkoutOrder {
+		position = &order
 	}
-
-	err = r.DB.Create(&workout).Error
+	err = r.WorkoutStore.CreateWorkout(ctx, &workout, position)
 	if err != nil {
+		log.Error().Str("gql_resolver", "failed to create workout").Str("mutation", "create_workout").Err(err).Send()
 		return nil, err
 	}
 
@@ -82,37 +184,63 @@ func (r *mutationResolver) CreateWorkout(ctx context.Context, userID string, kin
 }
 
 // UpdateWorkout is the resolver for the update_workout field.
-func (r *mutationResolver) UpdateWorkout(ctx context.Context, workoutID string, kind model.WorkoutKind, reps int, durationSeconds int, rounds int, order int) (*string, error) {
+func (r *mutationResolver) UpdateWorkout(ctx context.Context, workoutID string, kind model.WorkoutKind, reps int, durationSeconds int, rounds int, order int, expectedVersion *int) (*string, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	id, err := util.Uint64FromStringID(workoutID)
 	if err != nil {
 		return nil, err
 	}
 
-	w := backend_model.Workout{
-		BaseModel: backend_model.BaseModel{
-			ID: id,
-		},
+	err = r.WorkoutValidator.Validate(validation.WorkoutFields{
+		Kind:            kind.CastToModelKind(),
+		Reps:            &reps,
+		DurationSeconds: &durationSeconds,
+		Rounds:          &rounds,
+		Order:           &order,
+
+		AllowAppendOrder: true,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	updates := map[string]interface{}{
-		"kind":             kind.CastToModelKind(),
-		"reps":             reps,
-		"duration_seconds": durationSeconds,
-		"rounds":           rounds,
+	w, err := r.WorkoutStore.GetWorkoutOfUser(ctx, session.UserID, id)
+	if err != nil {
+		if errors.Is(err, constants.ErrCodeNotFound) {
+			return nil, apperror.NotFound("failed to update workout with id '%s': no such id", workoutID)
+		}
+		log.Error().Str("gql_resolver", "failed to update workout").Str("workout_id", workoutID).Str("mutation", "update_workout").Msg("query or db error")
+		return nil, fmt.Errorf("failed to update workout with id '%s': %w", workoutID, err)
 	}
 
-	if order != -1 {
-		updates["relative_order"] = order
+	// Without an expected version the last write wins
+	version := w.Version
+	if expectedVersion != nil {
+		if *expectedVersion != w.Version {
+			return nil, constants.ErrCodeVersionConflict
+		}
+		version = *expectedVersion
 	}
 
-	res := r.DB.Model(&w).Where("id = ?", []interface{}{id}).Updates(updates)
-	if res.Error != nil {
-		log.Error().Str("gql_resolver", "failed to update workout").Str("workout_id", workoutID).Str("mutation", "update_workout").Msg("query or db error")
-		return nil, fmt.Errorf("failed to update workout with id '%s': %w", workoutID, res.Error)
+	w.Kind = kind.CastToModelKind()
+	w.Reps = reps
+	w.DurationSeconds = durationSeconds
+	w.Rounds = rounds
+	columns := []string{"kind", "reps", "duration_seconds", "rounds"}
+
+	var moveTo *int
+	if order != validation.AppendWorkoutOrder {
+		moveTo = &order
 	}
-	if res.RowsAffected == 0 {
-		log.Error().Str("gql_resolver", "failed to update workout").Str("workout_id", workoutID).Str("mutation", "updated_workout").Msg("due to res.RowsAffected == 0")
-		return nil, fmt.Errorf("failed to update workout with id '%s': no such id", workoutID)
+
+	err = r.WorkoutStore.UpdateWorkoutColumns(ctx, &w, columns, moveTo, version)
+	if err != nil {
+		log.Error().Str("gql_resolver", "failed to update workout").Str("workout_id", workoutID).Str("mutation", "update_workout").Err(err).Send()
+		return nil, err
 	}
 
 	log.Info().Str("gql_resolver", "updated workout").Str("workput_id", workoutID)
@@ -120,24 +248,220 @@ func (r *mutationResolver) UpdateWorkout(ctx context.Context, workoutID string,
 	return &workoutID, nil
 }
 
+// AddWorkout is the resolver for the add_workout field.
+func (r *mutationResolver) AddWorkout(ctx context.Context, input model.CreateWorkoutInput) (*model.WorkoutPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	workout, err := r.workoutFromInput(session.UserID, &input)
+	if err != nil {
+		return workoutPayloadFromError(err)
+	}
+
+	err = r.WorkoutStore.CreateWorkout(ctx, &workout, input.Order)
+	if err != nil {
+		log.Error().Str("gql_resolver", "failed to create workout").Str("mutation", "add_workout").Err(err).Send()
+		return nil, err
+	}
+
+	return &model.WorkoutPayload{
+		Workout:    model.WorkoutFromModel(&workout),
+		UserErrors: []*model.UserError{},
+	}, nil
+}
+
+// CreateWorkouts is the resolver for the create_workouts field.
+func (r *mutationResolver) CreateWorkouts(ctx context.Context, inputs []*model.CreateWorkoutInput) (*model.CreateWorkoutsPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inputs) > r.MaxPageSize {
+		return nil, apperror.InvalidInput("at most %d workouts can be created at once, got %d", r.MaxPageSize, len(inputs))
+	}
+
+	workouts, err := r.workoutsFromInputs(session.UserID, inputs)
+	if err != nil {
+		appErr := apperror.From(err)
+		if !isUserError(appErr) {
+			return nil, err
+		}
+		return &model.CreateWorkoutsPayload{Workouts: []*model.Workout{}, UserErrors: userErrorsFrom(appErr)}, nil
+	}
+
+	err = r.WorkoutStore.CreateWorkouts(ctx, session.UserID, workouts)
+	if err != nil {
+		log.Error().Str("gql_resolver", "failed to create workouts").Str("mutation", "create_workouts").Err(err).Send()
+		return nil, err
+	}
+
+	payload := &model.CreateWorkoutsPayload{
+		Workouts:   make([]*model.Workout, 0, len(workouts)),
+		UserErrors: []*model.UserError{},
+	}
+	for _, w := range workouts {
+		payload.Workouts = append(payload.Workouts, model.WorkoutFromModel(w))
+	}
+	return payload, nil
+}
+
+// PatchWorkout is the resolver for the patch_workout field.
+func (r *mutationResolver) PatchWorkout(ctx context.Context, workoutID string, expectedVersion int, patch model.UpdateWorkoutPatch) (*model.WorkoutPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := util.Uint64FromStringID(workoutID)
+	if err != nil {
+		return workoutPayloadFromError(err)
+	}
+
+	workout, err := r.WorkoutStore.GetWorkoutOfUser(ctx, session.UserID, id)
+	if err != nil {
+		if errors.Is(err, constants.ErrCodeNotFound) {
+			return workoutPayloadFromError(apperror.NotFound("no workout with id '%s'", workoutID))
+		}
+		return nil, err
+	}
+
+	if workout.Version != expectedVersion {
+		return workoutConflictPayload(&workout), nil
+	}
+
+	columns, err := r.applyPatch(&workout, &patch)
+	if err != nil {
+		return workoutPayloadFromError(err)
+	}
+
+	err = r.WorkoutStore.UpdateWorkoutColumns(ctx, &workout, columns, patch.Order, expectedVersion)
+	if err != nil {
+		if errors.Is(err, constants.ErrCodeNotFound) {
+			return workoutPayloadFromError(apperror.NotFound("no workout with id '%s'", workoutID))
+		}
+		if errors.Is(err, constants.ErrCodeVersionConflict) {
+			current, err := r.WorkoutStore.GetWorkoutOfUser(ctx, session.UserID, id)
+			if err != nil {
+				return nil, err
+			}
+			return workoutConflictPayload(&current), nil
+		}
+		log.Error().Str("gql_resolver", "failed to update workout").Str("workout_id", workoutID).Str("mutation", "patch_workout").Err(err).Send()
+		return nil, err
+	}
+
+	return &model.WorkoutPayload{
+		Workout:    model.WorkoutFromModel(&workout),
+		UserErrors: []*model.UserError{},
+	}, nil
+}
+
+// MoveWorkout is the resolver for the move_workout field.
+func (r *mutationResolver) MoveWorkout(ctx context.Context, workoutID string, afterWorkoutID *string, expectedVersion *int) (*model.WorkoutPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := util.Uint64FromStringID(workoutID)
+	if err != nil {
+		return workoutPayloadFromError(err)
+	}
+
+	var afterID *uint64
+	if afterWorkoutID != nil {
+		anchor, err := util.Uint64FromStringID(*afterWorkoutID)
+		if err != nil {
+			return workoutPayloadFromError(err)
+		}
+		afterID = &anchor
+	}
+
+	workout, err := r.WorkoutStore.MoveWorkout(ctx, session.UserID, id, afterID, expectedVersion)
+	if errors.Is(err, constants.ErrCodeVersionConflict) {
+		current, err := r.WorkoutStore.GetWorkoutOfUser(ctx, session.UserID, id)
+		if err != nil {
+			return nil, err
+		}
+		return workoutConflictPayload(&current), nil
+	}
+	if err != nil {
+		payload, err := workoutPayloadFromError(err)
+		if err != nil {
+			log.Error().Str("gql_resolver", "failed to move workout").Str("workout_id", workoutID).Str("mutation", "move_workout").Err(err).Send()
+		}
+		return payload, err
+	}
+
+	return &model.WorkoutPayload{
+		Workout:    model.WorkoutFromModel(&workout),
+		UserErrors: []*model.UserError{},
+	}, nil
+}
+
 // ReorderWorkouts is the resolver for the reorder_workouts field.
-func (r *mutationResolver) ReorderWorkouts(ctx context.Context, workoutIDAtRow []string) ([]string, error) {
-	for row, workoutID := range workoutIDAtRow {
+func (r *mutationResolver) ReorderWorkouts(ctx context.Context, workoutIDAtRow []string, expectedListVersion *int) ([]string, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(workoutIDAtRow))
+	for _, workoutID := range workoutIDAtRow {
 		id, err := util.Uint64FromStringID(workoutID)
 		if err != nil {
 			return nil, err
 		}
-		workout := backend_model.Workout{
-			BaseModel: backend_model.BaseModel{ID: id},
-		}
-		err = r.DB.WithContext(ctx).Model(&workout).UpdateColumn("relative_order", row).Error
+		ids = append(ids, id)
+	}
+
+	_, err = r.WorkoutStore.ReorderWorkouts(ctx, session.UserID, ids, expectedListVersion)
+	if errors.Is(err, constants.ErrCodeVersionConflict) {
+		currentIDs, currentVersion, err := r.WorkoutStore.GetWorkoutListState(ctx, session.UserID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to update relative_order column for workout with id: %d: %w", id, err)
+			return nil, err
 		}
+		appErr := apperror.New(apperror.CodeConflict, "workout list was modified concurrently, expected version %d but it is %d", *expectedListVersion, currentVersion)
+		appErr.Details = map[string]interface{}{
+			"current_list_version": currentVersion,
+			"current_workout_ids":  model.StringIDs(currentIDs),
+		}
+		return nil, appErr
+	}
+	if err != nil {
+		return nil, err
 	}
 	return workoutIDAtRow[:], nil
 }
 
+// PushChanges is the resolver for the push_changes field.
+func (r *mutationResolver) PushChanges(ctx context.Context, changes []*model.WorkoutChangeInput) (*model.PushChangesPayload, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changes) > r.MaxPageSize {
+		return nil, apperror.InvalidInput("at most %d changes can be pushed at once, got %d", r.MaxPageSize, len(changes))
+	}
+
+	payload := &model.PushChangesPayload{
+		Results: make([]*model.ChangeResult, 0, len(changes)),
+	}
+	for _, change := range changes {
+		result, err := r.pushChange(ctx, session.UserID, change)
+		if err != nil {
+			log.Error().Str("gql_resolver", "failed to apply change").Str("op_id", change.OpID).Str("mutation", "push_changes").Err(err).Send()
+			return nil, err
+		}
+		payload.Results = append(payload.Results, result)
+	}
+	return payload, nil
+}
+
 // User is the resolver for the user field.
 func (r *queryResolver) User(ctx context.Context, id string) (*model.User, error) {
 	userUintID, err := util.Uint64FromStringID(id)
@@ -145,56 +469,43 @@ func (r *queryResolver) User(ctx context.Context, id string) (*model.User, error
 		return nil, err
 	}
 
-	var user backend_model.User
-	user.ID = userUintID
-	if err := r.DB.WithContext(ctx).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	loaders, err := loader.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := loaders.Users.Load(ctx, userUintID)
+	if err != nil {
+		if errors.Is(err, constants.ErrCodeNotFound) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &model.User{
-		ID:       id,
-		UserName: user.UserName,
-		Email:    user.Email,
-	}, nil
+	return model.UserFromModel(&user), nil
 }
 
 // Workouts is the resolver for the workouts field.
 func (r *queryResolver) Workouts(ctx context.Context, userID string) ([]*model.Workout, error) {
-	userUintID, err := util.Uint64FromStringID(userID)
+	userUintID, err := sessionUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
 	var workouts []backend_model.Workout
-	err = r.DB.WithContext(ctx).Where("user_id = ?", userUintID).Find(&workouts).Error
+	err = r.DB.WithContext(ctx).Where("user_id = ?", userUintID).Order("order_key, id").Limit(legacyWorkoutsLimit).Find(&workouts).Error
 	if err != nil {
 		return nil, err
 	}
 
 	respWorkouts := make([]*model.Workout, 0, len(workouts))
-	for _, w := range workouts {
-		respWorkouts = append(respWorkouts, &model.Workout{
-			ID:              strconv.FormatUint(w.ID, 10),
-			Reps:            w.Reps,
-			Rounds:          w.Rounds,
-			DurationSeconds: w.DurationSeconds,
-			UserID:          userID,
-			Kind:            model.WorkoutKindFromModel(w.Kind),
-			Order:           w.Order,
-		})
+	for i := range workouts {
+		respWorkouts = append(respWorkouts, model.WorkoutFromModel(&workouts[i]))
 	}
 
 	// log.Debug().Int("duration(sec)", 3).Str("gql_resolver", "sleeping before sending response").Str("query", "workouts").Msg("simulating delay")
 	// <-time.After(3 * time.Second)
 
 	log.Debug().Str("gql_resolver", "sending workouts result").Str("query", "workouts")
-
-	for _, w := range workouts {
-		fmt.Printf("Kind: %s, Reps: %d\n", w.Kind, w.Reps)
-	}
-	os.Stdout.Sync()
 	return respWorkouts, nil
 }
 
@@ -202,6 +513,9 @@ func (r *queryResolver) Workouts(ctx context.Context, userID string) ([]*model.W
 func (r *queryResolver) UserByEmail(ctx context.Context, email string) (*model.User, error) {
 	var user backend_model.User
 	if err := r.DB.Model(backend_model.User{}).Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperror.NotFound("no user with email %s", email)
+		}
 		return nil, err
 	}
 
@@ -212,11 +526,223 @@ func (r *queryResolver) UserByEmail(ctx context.Context, email string) (*model.U
 	}, nil
 }
 
+// WorkoutsConnection is the resolver for the workoutsConnection field.
+func (r *queryResolver) WorkoutsConnection(ctx context.Context, first *int, after *string, filter *model.WorkoutFilter, orderBy *model.WorkoutOrderBy) (*model.WorkoutConnection, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := r.pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	params := store.WorkoutPageParams{
+		Filter: filter.ToStoreFilter(),
+		Limit:  limit,
+	}
+	params.SortField, params.Descending = orderBy.ToStoreSort()
+	if after != nil {
+		params.After = *after
+	}
+
+	page, err := r.WorkoutStore.GetWorkoutsPage(ctx, session.UserID, params)
+	if err != nil {
+		log.Error().Str("gql_resolver", "failed to get workouts page").Str("query", "workoutsConnection").Err(err).Send()
+		return nil, err
+	}
+
+	conn := &model.WorkoutConnection{
+		Edges:      make([]*model.WorkoutEdge, 0, len(page.Workouts)),
+		PageInfo:   &model.PageInfo{HasNextPage: page.HasNextPage},
+		TotalCount: int(page.TotalCount),
+	}
+	for i := range page.Workouts {
+		conn.Edges = append(conn.Edges, &model.WorkoutEdge{
+			Cursor: page.Cursors[i],
+			Node:   model.WorkoutFromModel(&page.Workouts[i]),
+		})
+	}
+	if len(page.Cursors) != 0 {
+		conn.PageInfo.EndCursor = &page.Cursors[len(page.Cursors)-1]
+	}
+	return conn, nil
+}
+
+// WorkoutListVersion is the resolver for the workout_list_version field.
+func (r *queryResolver) WorkoutListVersion(ctx context.Context) (int, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	_, version, err := r.WorkoutStore.GetWorkoutListState(ctx, session.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Sync is the resolver for the sync field.
+func (r *queryResolver) Sync(ctx context.Context, sinceToken *string) (*model.SyncResult, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	since, err := parseSyncToken(sinceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := r.SyncStore.ChangesSince(ctx, session.UserID, since, syncBatchSize)
+	if err != nil {
+		log.Error().Str("gql_resolver", "failed to get changes").Str("query", "sync").Err(err).Send()
+		return nil, err
+	}
+
+	listVersion, err := r.WorkoutStore.GetWorkoutListVersion(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.SyncResult{
+		Token:              strconv.FormatUint(changes.Token, 10),
+		HasMore:            changes.HasMore,
+		Workouts:           []*model.Workout{},
+		DeletedWorkouts:    []*model.Tombstone{},
+		WorkoutListVersion: listVersion,
+	}
+	for i := range changes.Workouts {
+		w := &changes.Workouts[i]
+		if w.DeletedAt.Valid {
+			result.DeletedWorkouts = append(result.DeletedWorkouts, &model.Tombstone{
+				ID:        strconv.FormatUint(w.ID, 10),
+				ClientID:  w.ClientID,
+				DeletedAt: w.DeletedAt.Time,
+			})
+		} else {
+			result.Workouts = append(result.Workouts, model.WorkoutFromModel(w))
+		}
+	}
+	return result, nil
+}
+
+// Search is the resolver for the search field.
+func (r *queryResolver) Search(ctx context.Context, query string, first *int) ([]*model.SearchHit, error) {
+	session, err := sessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := r.pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	hits, err := r.SearchStore.Search(ctx, session.UserID, query, limit)
+	if err != nil {
+		log.Error().Str("gql_resolver", "failed to search").Str("query", "search").Err(err).Send()
+		return nil, err
+	}
+
+	loaders, err := loader.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var workoutIDs []uint64
+	for _, hit := range hits {
+		if hit.EntityType == store.SearchEntityWorkout {
+			workoutIDs = append(workoutIDs, hit.EntityID)
+		}
+	}
+	workouts := make(map[uint64]backend_model.Workout, len(workoutIDs))
+	loaded, errs := loaders.Workouts.LoadMany(ctx, workoutIDs)
+	for i, err := range errs {
+		// The index can briefly lag behind a delete, skip what's gone
+		if err == nil && loaded[i].UserID == session.UserID {
+			workouts[workoutIDs[i]] = loaded[i]
+		} else if err != nil && !errors.Is(err, constants.ErrCodeNotFound) {
+			return nil, err
+		}
+	}
+
+	results := make([]*model.SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		result := &model.SearchHit{
+			ID:      strconv.FormatUint(hit.EntityID, 10),
+			Snippet: hit.Snippet,
+			Rank:    hit.Rank,
+		}
+		switch hit.EntityType {
+		case store.SearchEntityWorkout:
+			w, ok := workouts[hit.EntityID]
+			if !ok {
+				continue
+			}
+			result.Kind = model.SearchHitKindWorkout
+			result.Workout = model.WorkoutFromModel(&w)
+		default:
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Order is the resolver for the order field.
+func (r *workoutResolver) Order(ctx context.Context, obj *model.Workout) (int, error) {
+	userID, err := util.Uint64FromStringID(obj.UserID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := util.Uint64FromStringID(obj.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	loaders, err := loader.FromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	position, err := loaders.WorkoutPosition(ctx, userID, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load position of workout %s: %w", obj.ID, err)
+	}
+	return position, nil
+}
+
+// User is the resolver for the user field.
+func (r *workoutResolver) User(ctx context.Context, obj *model.Workout) (*model.User, error) {
+	userID, err := util.Uint64FromStringID(obj.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	loaders, err := loader.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := loaders.Users.Load(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user %s of workout %s: %w", obj.UserID, obj.ID, err)
+	}
+	return model.UserFromModel(&user), nil
+}
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// Workout returns WorkoutResolver implementation.
+func (r *Resolver) Workout() WorkoutResolver { return &workoutResolver{r} }
+
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type workoutResolver struct{ *Resolver }
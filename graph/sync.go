@@ -0,0 +1,133 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/graph/model"
+	backend_model "github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/util"
+	"gorm.io/gorm"
+)
+
+// Number of change log entries looked at per sync call
+const syncBatchSize = 500
+
+// Finds the workout a change refers to, by server id or by client id
+func changeTarget(ctx context.Context, workouts *store.WorkoutStore, userID uint64, change *model.WorkoutChangeInput) (backend_model.Workout, error) {
+	switch {
+	case change.WorkoutID != nil:
+		id, err := util.Uint64FromStringID(*change.WorkoutID)
+		if err != nil {
+			return backend_model.Workout{}, err
+		}
+		return workouts.GetWorkoutOfUser(ctx, userID, id)
+	case change.ClientID != nil:
+		return workouts.GetWorkoutOfUserByClientID(ctx, userID, *change.ClientID)
+	}
+	return backend_model.Workout{}, apperror.InvalidInput("one of workout_id or client_id is required")
+}
+
+func (r *mutationResolver) applyChange(ctx context.Context, tx *gorm.DB, userID uint64, change *model.WorkoutChangeInput) (uint64, error) {
+	workouts := r.WorkoutStore.WithTx(tx)
+
+	switch change.Op {
+	case model.ChangeOpCreate:
+		if change.ClientID == nil || change.Create == nil {
+			return 0, apperror.InvalidInput("client_id and create are required to create a workout")
+		}
+		workout, err := r.workoutFromInput(userID, change.Create)
+		if err != nil {
+			return 0, err
+		}
+		workout.ClientID = change.ClientID
+		err = workouts.CreateWorkout(ctx, &workout, change.Create.Order)
+		return workout.ID, err
+
+	case model.ChangeOpUpdate:
+		if change.ExpectedVersion == nil || change.Patch == nil {
+			return 0, apperror.InvalidInput("expected_version and patch are required to update a workout")
+		}
+		workout, err := changeTarget(ctx, workouts, userID, change)
+		if err != nil {
+			return 0, err
+		}
+		if workout.Version != *change.ExpectedVersion {
+			return 0, constants.ErrCodeVersionConflict
+		}
+		columns, err := r.applyPatch(&workout, change.Patch)
+		if err != nil {
+			return 0, err
+		}
+		return workout.ID, workouts.UpdateWorkoutColumns(ctx, &workout, columns, change.Patch.Order, *change.ExpectedVersion)
+
+	case model.ChangeOpDelete:
+		workout, err := changeTarget(ctx, workouts, userID, change)
+		if err != nil {
+			return 0, err
+		}
+		return workout.ID, workouts.DeleteWorkout(ctx, userID, workout.ID, change.ExpectedVersion)
+	}
+	return 0, apperror.InvalidInput("unknown op %s", change.Op)
+}
+
+// Applies the change unless it was already applied, and reports the outcome
+// along with the current state of the workout.
+func (r *mutationResolver) pushChange(ctx context.Context, userID uint64, change *model.WorkoutChangeInput) (*model.ChangeResult, error) {
+	result := &model.ChangeResult{
+		OpID:       change.OpID,
+		Status:     model.ChangeStatusApplied,
+		UserErrors: []*model.UserError{},
+	}
+
+	workoutID, duplicate, err := r.SyncStore.ApplyOnce(ctx, userID, change.OpID, func(tx *gorm.DB) (uint64, error) {
+		return r.applyChange(ctx, tx, userID, change)
+	})
+	if duplicate {
+		result.Status = model.ChangeStatusDuplicate
+	}
+
+	if err != nil {
+		appErr := apperror.From(err)
+		if !isUserError(appErr) {
+			return nil, err
+		}
+		result.Status = model.ChangeStatusRejected
+		if appErr.Code == apperror.CodeConflict {
+			result.Status = model.ChangeStatusConflict
+		}
+		result.UserErrors = userErrorsFrom(appErr)
+
+		// The target may legitimately not exist, e.g. a rejected create
+		current, err := changeTarget(ctx, r.WorkoutStore, userID, change)
+		if err == nil {
+			result.Workout = model.WorkoutFromModel(&current)
+		} else if !isUserError(apperror.From(err)) {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	current, err := r.WorkoutStore.GetWorkoutOfUser(ctx, userID, workoutID)
+	if err == nil {
+		result.Workout = model.WorkoutFromModel(&current)
+	} else if !errors.Is(err, constants.ErrCodeNotFound) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// An empty token means from the beginning
+func parseSyncToken(token *string) (uint64, error) {
+	if token == nil || *token == "" {
+		return 0, nil
+	}
+	since, err := util.Uint64FromStringID(*token)
+	if err != nil {
+		return 0, apperror.InvalidInput("malformed since_token")
+	}
+	return since, nil
+}
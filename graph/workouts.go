@@ -0,0 +1,82 @@
+package graph
+
+import (
+	"fmt"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/graph/model"
+	backend_model "github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/validation"
+)
+
+// Builds the workout to insert for the user from input and validates it
+func (r *Resolver) workoutFromInput(userID uint64, input *model.CreateWorkoutInput) (backend_model.Workout, error) {
+	workout := backend_model.Workout{
+		Kind:            input.Kind.CastToModelKind(),
+		Reps:            input.Reps,
+		DurationSeconds: input.DurationSeconds,
+		UserID:          userID,
+	}
+	if input.Rounds != nil {
+		workout.Rounds = *input.Rounds
+	}
+
+	err := r.WorkoutValidator.Validate(validation.WorkoutFields{
+		Kind:            workout.Kind,
+		Reps:            &workout.Reps,
+		DurationSeconds: &workout.DurationSeconds,
+		Rounds:          &workout.Rounds,
+		Order:           input.Order,
+	})
+	return workout, err
+}
+
+// Like workoutFromInput for each input, collecting the invalid fields of all
+// of them. Field names are prefixed with the index of the input.
+func (r *Resolver) workoutsFromInputs(userID uint64, inputs []*model.CreateWorkoutInput) ([]*backend_model.Workout, error) {
+	workouts := make([]*backend_model.Workout, 0, len(inputs))
+	var fieldErrors []apperror.FieldError
+
+	for i, input := range inputs {
+		if input.Order != nil {
+			fieldErrors = append(fieldErrors, apperror.FieldError{
+				Field:   fmt.Sprintf("inputs.%d.order", i),
+				Message: "must not be set, workouts are appended in the given order",
+			})
+		}
+
+		workout, err := r.workoutFromInput(userID, input)
+		if err != nil {
+			appErr := apperror.From(err)
+			if appErr.Code != apperror.CodeInvalidInput {
+				return nil, err
+			}
+			if len(appErr.Fields) == 0 {
+				fieldErrors = append(fieldErrors, apperror.FieldError{Field: fmt.Sprintf("inputs.%d", i), Message: appErr.Message})
+			}
+			for _, f := range appErr.Fields {
+				f.Field = fmt.Sprintf("inputs.%d.%s", i, f.Field)
+				fieldErrors = append(fieldErrors, f)
+			}
+		}
+		workouts = append(workouts, &workout)
+	}
+
+	if len(fieldErrors) != 0 {
+		return nil, apperror.InvalidFields(fieldErrors)
+	}
+	return workouts, nil
+}
+
+// Applies patch to w and validates the result. Returns the columns to write.
+func (r *Resolver) applyPatch(w *backend_model.Workout, patch *model.UpdateWorkoutPatch) ([]string, error) {
+	fields, columns := patch.Apply(w)
+	if patch.Kind != nil {
+		// Limits depend on the kind, so everything has to fit the new one
+		fields.Reps, fields.DurationSeconds, fields.Rounds = &w.Reps, &w.DurationSeconds, &w.Rounds
+	}
+	if err := r.WorkoutValidator.Validate(fields); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
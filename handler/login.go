@@ -1,14 +1,15 @@
 package handler
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
+	"mime"
 	"net/http"
-	"strconv"
 	"time"
 
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/auth"
 	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/handler/respond"
 	"github.com/nrawrx3/workout-backend/model"
 	"github.com/nrawrx3/workout-backend/store"
 	"github.com/nrawrx3/workout-backend/util"
@@ -16,129 +17,284 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// Login request bodies larger than this are rejected
+const maxLoginBodyBytes = 64 << 10
+
 type LoginHandler struct {
-	userStore  *store.UserStore
-	cookieInfo model.SessionCookieInfo
-	cipher     *util.AESCipher
+	userStore     *store.UserStore
+	authenticator *auth.Authenticator
+	cookieInfo    model.SessionCookieInfo
+	keyring       *util.AESKeyring
+	// nil if magic link login is off
+	magicLinks *auth.MagicLinks
 }
 
-func NewLoginHandler(userStore *store.UserStore, cookieInfo model.SessionCookieInfo, cipher *util.AESCipher) *LoginHandler {
-	return &LoginHandler{userStore: userStore, cookieInfo: cookieInfo, cipher: cipher}
+func NewLoginHandler(userStore *store.UserStore, authenticator *auth.Authenticator, cookieInfo model.SessionCookieInfo, keyring *util.AESKeyring, magicLinks *auth.MagicLinks) *LoginHandler {
+	return &LoginHandler{userStore: userStore, authenticator: authenticator, cookieInfo: cookieInfo, keyring: keyring, magicLinks: magicLinks}
 }
 
-// Success response type: 200 - empty
-// Failure response type:
+// Body: model.UserLoginRequestBody as JSON, form-encoded or multipart
+//
+// Success response type: 200 - model.LoginResponseJSON, either with the user
+// and the session cookie set, or with a second_factor_challenge for users with
+// two factor authentication, to be answered at /login/second-factor.
+// Failure response type, all model.ResponseFormatJSON:
 //
-//	404 - reason-string
-//	422 - reson-string
-//	500 - model.DefaultInternalServerErrorResponse
+//	401 - wrong password
+//	404 - no user with the email
+//	405 - not a POST request
+//	415 - unsupported Content-Type
+//	422 - missing fields, malformed or too large body
+//	500 - unexpected server error
 func (h *LoginHandler) Login(w http.ResponseWriter, r *http.Request) {
-	<-time.After(2 * time.Second)
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("expected POST method to API"))
+	if r.Method != http.MethodPost {
+		respond.MethodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
-	formData := model.UserLoginRequestBody{
-		Email:    r.PostFormValue("email"),
-		Password: r.PostFormValue("password"),
+	var credentials model.UserLoginRequestBody
+	err := readLoginBody(w, r, &credentials, []loginBodyField{
+		{name: "email", value: &credentials.Email},
+		{name: "password", value: &credentials.Password},
+	})
+	if err != nil {
+		respond.Error(w, r, err)
+		return
 	}
 
-	user, err := h.userStore.GetUserWithEmail(r.Context(), formData.Email)
-	if errors.Is(err, constants.ErrCodeNotFound) {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("No user with given email"))
+	result, err := h.authenticator.Login(r.Context(), w, credentials, r.Header.Get("User-Agent"))
+	if err != nil {
+		respond.Error(w, r, err)
 		return
 	}
 
-	passwordMatches, err := util.PasswordMatchesHash(formData.Password, user.PasswordHash)
+	respond.Data(w, http.StatusOK, loginResponse(&result))
+}
 
-	if err != nil {
-		log.Debug().Str("/login", "password does not match, non-trivial error").Err(err).Send()
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		w.Write([]byte("failed to match password due to error"))
+func loginResponse(result *auth.LoginResult) model.LoginResponseJSON {
+	var resp model.LoginResponseJSON
+	if result.Challenge != nil {
+		resp.SecondFactorChallenge = &model.SecondFactorChallengeJSON{
+			Challenge: result.Challenge.Token,
+			ExpiresAt: result.Challenge.ExpiresAt,
+		}
+	} else {
+		resp.FromModel(&result.Session)
+	}
+	return resp
+}
+
+// Body: model.SecondFactorRequestBody as JSON, form-encoded or multipart
+//
+// Success response type: 200 - model.LoginResponseJSON, the session cookie is
+// set
+// Failure response type, all model.ResponseFormatJSON:
+//
+//	401 - wrong or already used code, or an invalid or expired challenge
+//	405 - not a POST request
+//	415 - unsupported Content-Type
+//	422 - missing fields, malformed or too large body
+//	429 - too many wrong codes for the user, or the login rate limit
+//	500 - unexpected server error
+func (h *LoginHandler) SecondFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.MethodNotAllowed(w, r, http.MethodPost)
 		return
 	}
-	if !passwordMatches {
-		log.Debug().Str("/login", "password does not match")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Wrong password"))
+
+	var body model.SecondFactorRequestBody
+	err := readLoginBody(w, r, &body, []loginBodyField{
+		{name: "challenge", value: &body.Challenge},
+		{name: "code", value: &body.Code},
+	})
+	if err != nil {
+		respond.Error(w, r, err)
 		return
 	}
 
-	// Create session
-	session, err := h.userStore.CreateSession(r.Context(), user.ID, time.Now(), h.cookieInfo.Expires, r.Header.Get("User-Agent"))
+	session, err := h.authenticator.CompleteSecondFactor(r.Context(), w, body.Challenge, body.Code, r.Header.Get("User-Agent"))
 	if err != nil {
-		http.Error(w, constants.ResponseErrCodeUnexpectedServerError, http.StatusInternalServerError)
+		respond.Error(w, r, err)
+		return
+	}
+
+	var resp model.LoginResponseJSON
+	resp.FromModel(&session)
+	respond.Data(w, http.StatusOK, resp)
+}
+
+// Body: model.MagicLinkRequestBody as JSON, form-encoded or multipart
+//
+// Success response type: 202 - model.MagicLinkRequestedResponseJSON, whether
+// or not the email belongs to a user
+// Failure response type, all model.ResponseFormatJSON:
+//
+//	404 - magic link login is off
+//	405 - not a POST request
+//	415 - unsupported Content-Type
+//	422 - missing email, malformed or too large body
+func (h *LoginHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.MethodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if h.magicLinks == nil {
+		respond.Error(w, r, apperror.NotFound("magic link login is not enabled"))
 		return
 	}
 
-	cookie := http.Cookie{
-		Name: h.cookieInfo.CookieName,
-		// Value:    strconv.FormatUint(user.ID, 10),
-		Domain:   h.cookieInfo.Domain,
-		Expires:  h.cookieInfo.Expires,
-		HttpOnly: h.cookieInfo.HttpOnly,
-		SameSite: h.cookieInfo.SameSite,
-		Secure:   h.cookieInfo.Secure,
+	var body model.MagicLinkRequestBody
+	if err := readLoginBody(w, r, &body, []loginBodyField{{name: "email", value: &body.Email}}); err != nil {
+		respond.Error(w, r, err)
+		return
 	}
 
-	cookieValue := model.SessionCookieValue{
-		SessionID: strconv.FormatUint(session.ID, 10),
+	expiresAt := h.magicLinks.Request(body.Email, r.Header.Get("User-Agent"))
+	respond.Data(w, http.StatusAccepted, model.MagicLinkRequestedResponseJSON{ExpiresAt: expiresAt})
+}
+
+// Body: model.MagicLinkVerifyRequestBody as JSON, form-encoded or multipart
+//
+// Success response type: 200 - model.LoginResponseJSON, like /login
+// Failure response type, all model.ResponseFormatJSON:
+//
+//	401 - invalid, expired or already used token, or another user agent
+//	404 - magic link login is off
+//	405 - not a POST request
+//	415 - unsupported Content-Type
+//	422 - missing token, malformed or too large body
+//	500 - unexpected server error
+func (h *LoginHandler) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.MethodNotAllowed(w, r, http.MethodPost)
+		return
 	}
-	cookieValueBuf := bytes.NewBuffer(nil)
-	err = json.NewEncoder(cookieValueBuf).Encode(&cookieValue)
-	if err != nil {
-		log.Error().Str("path", "/login").Err(err).Msg("failed to JSON encode cookie value")
-		http.Error(w, "failed to JSON encode cookie :|", http.StatusInternalServerError)
+	if h.magicLinks == nil {
+		respond.Error(w, r, apperror.NotFound("magic link login is not enabled"))
+		return
+	}
+
+	var body model.MagicLinkVerifyRequestBody
+	if err := readLoginBody(w, r, &body, []loginBodyField{{name: "token", value: &body.Token}}); err != nil {
+		respond.Error(w, r, err)
 		return
 	}
 
-	err = util.EncryptThenEncodeB64ThenWriteCookie(w, cookie, h.cipher, cookieValueBuf.Bytes())
+	result, err := h.magicLinks.Verify(r.Context(), w, body.Token, r.Header.Get("User-Agent"))
 	if err != nil {
-		log.Error().Str("path", "/login").Err(err).Msg("failed to write cookie")
-		http.Error(w, "failed to encrypt cookie :|", http.StatusInternalServerError)
+		respond.Error(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	log.Info().Str("/login", "logged in user").Uint64("userID", user.ID).Send()
+	respond.Data(w, http.StatusOK, loginResponse(&result))
 }
 
-// Success response type: 200 - model.AmILoggedInResponseJSON
+// A required field of a login body, by its form name
+type loginBodyField struct {
+	name  string
+	value *string
+}
+
+// Reads the body into v from any of the accepted content types. The fields
+// point into v, form values are copied into them and all must end up non
+// empty.
+func readLoginBody(w http.ResponseWriter, r *http.Request, v interface{}, fields []loginBodyField) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return apperror.New(apperror.CodeUnsupportedMediaType, "missing or malformed Content-Type")
+	}
+
+	switch mediaType {
+	case "application/json":
+		if err := decodeJSONBodyWithLimit(w, r, v, maxLoginBodyBytes); err != nil {
+			return err
+		}
+
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodyBytes)
+		if mediaType == "multipart/form-data" {
+			err = r.ParseMultipartForm(maxLoginBodyBytes)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return apperror.InvalidInput("request body exceeds %d bytes", maxLoginBodyBytes)
+			}
+			return apperror.InvalidInput("malformed request body: %s", err.Error())
+		}
+		for _, field := range fields {
+			*field.value = r.PostForm.Get(field.name)
+		}
+
+	default:
+		return apperror.New(apperror.CodeUnsupportedMediaType,
+			"Content-Type must be application/json, application/x-www-form-urlencoded or multipart/form-data, got %s", mediaType)
+	}
+
+	var fieldErrors []apperror.FieldError
+	for _, field := range fields {
+		if *field.value == "" {
+			fieldErrors = append(fieldErrors, apperror.FieldError{Field: field.name, Message: "is required"})
+		}
+	}
+	if len(fieldErrors) != 0 {
+		return apperror.InvalidFields(fieldErrors)
+	}
+	return nil
+}
+
+// Success response type: 200 - model.AmILoggedInResponseJSON. A missing or
+// expired session is not a failure of this endpoint, it responds with 200 and
+// logged_in false, still setting the user-not-logged-in error code.
+//
+// Failure response type: 405, 500 - model.ResponseFormatJSON
 func (h *LoginHandler) AmILoggedIn(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("expected a GET request, received different"))
+	if r.Method != http.MethodGet {
+		respond.MethodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-
-	response := model.ResponseFormatJSON{}
+	notLoggedIn := model.ResponseFormatJSON{
+		Data:         model.AmILoggedInResponseJSON{LoggedIn: false},
+		ErrorCode:    constants.ResponseErrCodeUserNotLoggedIn,
+		ErrorMessage: "user not logged in",
+	}
 
-	sessionId, err := util.ExtractSessionIDFromCookie(r, h.cookieInfo.CookieName, h.cipher)
+	sessionId, stale, err := util.ExtractSessionIDFromCookie(r, h.cookieInfo.CookieName, h.keyring)
 	if err != nil {
 		log.Info().Err(err).Str("path", "/am-i-logged-in").Msg("could not extract sessionID from cookie")
-		response.ErrorCode = constants.ResponseErrCodeUserNotLoggedIn
-	} else {
-		session, err := h.userStore.LoadSession(r.Context(), sessionId, time.Now())
-		if errors.Is(err, constants.ErrCodeNotFound) {
-			response.ErrorCode = constants.ResponseErrCodeUnexpectedServerError
-		} else if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response.ErrorCode = constants.ResponseErrCodeUserNotLoggedIn
-		} else {
-			log.Info().Str("/am-i-logged-in", "user is logged in").Dict("session", zerolog.Dict().Uint64("sessionID", sessionId).Uint64("userID", session.UserID)).Send()
-			response.Data = model.AmILoggedInResponseJSON{LoggedIn: true}
-		}
+		respond.JSON(w, http.StatusOK, &notLoggedIn)
+		return
 	}
 
-	util.AddJsonContentHeader(w, 0)
-	err = json.NewEncoder(w).Encode(&response)
+	session, err := h.userStore.LoadSession(r.Context(), sessionId, time.Now())
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		respond.JSON(w, http.StatusOK, &notLoggedIn)
+		return
+	}
 	if err != nil {
-		http.Error(w, "failed to encode response json", http.StatusInternalServerError)
-		log.Info().Str("/am-i-logged-in", "failed to encode response json").Err(err).Send()
+		respond.Error(w, r, err)
 		return
 	}
+
+	if stale {
+		if err := auth.WriteSessionCookie(w, h.cookieInfo, h.keyring, &session); err != nil {
+			respond.Error(w, r, err)
+			return
+		}
+	}
+
+	// Sessions started before CSRF tokens were issued don't have one
+	if h.authenticator.CSRFCookieName != "" {
+		if _, err := r.Cookie(h.authenticator.CSRFCookieName); err != nil {
+			if err := h.authenticator.IssueCSRFToken(w, session.ExpiresAt); err != nil {
+				respond.Error(w, r, err)
+				return
+			}
+		}
+	}
+
+	log.Info().Str("/am-i-logged-in", "user is logged in").Dict("session", zerolog.Dict().Uint64("sessionID", sessionId).Uint64("userID", session.UserID)).Send()
+	respond.Data(w, http.StatusOK, model.AmILoggedInResponseJSON{LoggedIn: true})
 }
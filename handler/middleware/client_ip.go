@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Finds the IP of the client behind the reverse proxies in front of the
+// server, for middlewares that tell clients without a session apart by IP
+type ClientIPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+// trustedProxies are IPs or CIDRs of the reverse proxies in front of the
+// server. X-Forwarded-For is ignored unless the request comes from one of them.
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	c := &ClientIPResolver{}
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		c.trustedProxies = append(c.trustedProxies, ipNet)
+	}
+	return c, nil
+}
+
+// Returns the IP of the client. If the request comes from a trusted proxy,
+// that's the rightmost address in X-Forwarded-For that isn't a trusted proxy
+// itself, since the ones to the left of it can be set by the client.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !c.isTrustedProxy(remote) {
+		return remote
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		if !c.isTrustedProxy(ip) {
+			return ip
+		}
+		remote = ip
+	}
+	return remote
+}
+
+func (c *ClientIPResolver) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range c.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
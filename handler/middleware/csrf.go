@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+)
+
+// CSRF checks the double-submit token of state changing requests. The token
+// is issued in a cookie at login (see auth.Authenticator.IssueCSRFToken) and
+// must be echoed in the header. A cross-site page can make the browser send the
+// cookies but can't read them to set the header.
+//
+// Requests without the session cookie carry no ambient credentials and are let
+// through, e.g. the graphql login mutation.
+type CSRF struct {
+	sessionCookieName string
+	cookieName        string
+	headerName        string
+}
+
+func NewCSRF(sessionCookieName, cookieName, headerName string) *CSRF {
+	return &CSRF{sessionCookieName: sessionCookieName, cookieName: cookieName, headerName: headerName}
+}
+
+func (m *CSRF) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, err := r.Cookie(m.sessionCookieName); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(m.cookieName)
+		if err != nil || cookie.Value == "" {
+			respond.Error(w, r, apperror.New(apperror.CodeCSRFTokenMismatch, "missing %s cookie, log in again", m.cookieName))
+			return
+		}
+		header := r.Header.Get(m.headerName)
+		if header == "" {
+			respond.Error(w, r, apperror.New(apperror.CodeCSRFTokenMismatch, "missing %s header", m.headerName))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+			respond.Error(w, r, apperror.New(apperror.CodeCSRFTokenMismatch, "%s header doesn't match the %s cookie", m.headerName, m.cookieName))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const (
+	testSessionCookie = "SESSION"
+	testCSRFCookie    = "csrf_token"
+	testCSRFHeader    = "X-CSRF-Token"
+)
+
+// Serves r through the middleware, reporting whether it reached the handler
+// and the response status
+func serveCSRF(r *http.Request) (bool, int) {
+	reached := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+	w := httptest.NewRecorder()
+	NewCSRF(testSessionCookie, testCSRFCookie, testCSRFHeader).Handler(next).ServeHTTP(w, r)
+	return reached, w.Code
+}
+
+func TestCSRF(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		sessionCookie bool
+		cookie        string
+		header        string
+		pass          bool
+	}{
+		{"matching header", http.MethodPost, true, "token", "token", true},
+		{"missing header", http.MethodPost, true, "token", "", false},
+		{"mismatched header", http.MethodPost, true, "token", "other", false},
+		{"header prefix", http.MethodPost, true, "token", "tok", false},
+		{"missing cookie", http.MethodPost, true, "", "token", false},
+		{"put", http.MethodPut, true, "token", "", false},
+		{"patch", http.MethodPatch, true, "token", "", false},
+		{"delete", http.MethodDelete, true, "token", "", false},
+		// Nothing ambient for a cross-site page to ride on
+		{"no session", http.MethodPost, false, "", "", true},
+		{"get", http.MethodGet, true, "token", "", true},
+		{"head", http.MethodHead, true, "token", "", true},
+		{"options", http.MethodOptions, true, "token", "", true},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(tc.method, "/api/v1/workouts", nil)
+		if tc.sessionCookie {
+			r.AddCookie(&http.Cookie{Name: testSessionCookie, Value: "session"})
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: testCSRFCookie, Value: tc.cookie})
+		}
+		if tc.header != "" {
+			r.Header.Set(testCSRFHeader, tc.header)
+		}
+
+		reached, status := serveCSRF(r)
+		if reached != tc.pass {
+			t.Errorf("%s: reached the handler %v, want %v", tc.name, reached, tc.pass)
+		}
+		if !tc.pass && status != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want %d", tc.name, status, http.StatusForbidden)
+		}
+	}
+}
+
+func TestGraphQLQueriesOnlyOverGET(t *testing.T) {
+	tests := []struct {
+		method string
+		query  string
+		pass   bool
+	}{
+		{http.MethodGet, "{ workout_list_version }", true},
+		{http.MethodGet, "query Version { workout_list_version }", true},
+		{http.MethodGet, "mutation { enroll_totp { secret } }", false},
+		{http.MethodGet, "query A { workout_list_version } mutation B { enroll_totp { secret } }", false},
+		{http.MethodGet, "subscription { changes }", false},
+		// Left for the graphql handler to report
+		{http.MethodGet, "{", true},
+		{http.MethodPost, "mutation { enroll_totp { secret } }", true},
+	}
+	for _, tc := range tests {
+		reached := false
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		})
+		r := httptest.NewRequest(tc.method, "/gql/query?query="+url.QueryEscape(tc.query), nil)
+		w := httptest.NewRecorder()
+		GraphQLQueriesOnlyOverGET(next).ServeHTTP(w, r)
+
+		if reached != tc.pass {
+			t.Errorf("%s %q: reached the handler %v, want %v", tc.method, tc.query, reached, tc.pass)
+		}
+		if !tc.pass && (w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost) {
+			t.Errorf("%s %q: got status %d and Allow %q", tc.method, tc.query, w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
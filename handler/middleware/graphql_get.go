@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Refuses graphql GET requests whose document has anything but queries. GET
+// requests skip the CSRF check, so they must never change state. gqlgen's GET
+// transport refuses them too, this doesn't depend on it doing so.
+//
+// Documents that fail to parse are passed on for the graphql handler to report.
+func GraphQLQueriesOnlyOverGET(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query().Get("query")
+		if query == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		doc, err := parser.ParseQuery(&ast.Source{Input: query})
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, op := range doc.Operations {
+			if op.Operation != ast.Query {
+				w.Header().Set("Allow", http.MethodPost)
+				respond.Error(w, r, apperror.New(apperror.CodeMethodNotAllowed, "%s operations must be sent with POST", op.Operation))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
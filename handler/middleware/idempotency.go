@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// Set on responses that are replays of a stored response
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// Bodies are read in full to fingerprint the request
+	maxIdempotentBodyBytes = 1 << 20
+)
+
+// Response headers stored and replayed along with the body. Set-Cookie is not
+// among them: responses setting cookies, such as logins, are not stored at
+// all, since a replay would hand the session to whoever has the key.
+var replayedHeaders = []string{"Location", "Allow"}
+
+// Makes POST requests carrying an Idempotency-Key header safe to retry. The
+// first request with a key is handled normally and its response stored; later
+// requests with the same key and payload get the stored response back, while
+// ones with a different payload are rejected. Responses marked Cache-Control:
+// no-store, such as ones carrying secrets, and ones setting cookies are not
+// stored and the key is released, so that a retry runs the request again.
+// Must be placed after the session checker so that keys are scoped per user.
+// Keys of requests without a session are scoped by client IP instead.
+type Idempotency struct {
+	store     *store.IdempotencyStore
+	ttl       time.Duration
+	clientIPs *ClientIPResolver
+}
+
+func NewIdempotency(idempotencyStore *store.IdempotencyStore, ttl time.Duration, clientIPs *ClientIPResolver) *Idempotency {
+	return &Idempotency{store: idempotencyStore, ttl: ttl, clientIPs: clientIPs}
+}
+
+func (m *Idempotency) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			respond.Error(w, r, apperror.InvalidInput("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodyBytes+1))
+		if err != nil {
+			respond.Error(w, r, apperror.InvalidInput("failed to read request body"))
+			return
+		}
+		if len(body) > maxIdempotentBodyBytes {
+			respond.Error(w, r, apperror.InvalidInput("request body exceeds %d bytes", maxIdempotentBodyBytes))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var userID uint64
+		var clientIP string
+		if session, ok := r.Context().Value(model.UserSessionContextKey{}).(model.UserSession); ok {
+			userID = session.UserID
+		} else {
+			clientIP = m.clientIPs.ClientIP(r)
+		}
+
+		now := time.Now()
+		record := model.IdempotencyKey{
+			UserID:      userID,
+			ClientIP:    clientIP,
+			Key:         key,
+			Fingerprint: requestFingerprint(r, body),
+			ExpiresAt:   now.Add(m.ttl),
+		}
+
+		existing, claimed, err := m.store.Claim(r.Context(), &record, now)
+		if errors.Is(err, constants.ErrCodeAlreadyExists) {
+			respond.Error(w, r, apperror.New(apperror.CodeConflict, "a request with this %s is still in progress", IdempotencyKeyHeader))
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("path", r.URL.Path).Msg("failed to claim idempotency key")
+			respond.Error(w, r, err)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				respond.Error(w, r, apperror.InvalidInput("%s was already used for a different request", IdempotencyKeyHeader))
+			case !existing.Completed():
+				respond.Error(w, r, apperror.New(apperror.CodeConflict, "a request with this %s is still in progress", IdempotencyKeyHeader))
+			default:
+				for name, values := range existing.ResponseHeaders {
+					w.Header()[name] = values
+				}
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.ResponseBody)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		// The outcome is stored even if the client went away in the
+		// meantime, since that is exactly when it will retry.
+		storeCtx := context.Background()
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				if err := m.store.Release(storeCtx, record.ID); err != nil {
+					log.Error().Err(err).Msg("failed to release idempotency key after panic")
+				}
+				panic(rec)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+
+		// Server errors are likely transient, let the client retry them
+		header := w.Header()
+		if recorder.statusCode() >= http.StatusInternalServerError || noStore(header) || len(header.Values("Set-Cookie")) != 0 {
+			err = m.store.Release(storeCtx, record.ID)
+		} else {
+			err = m.store.Complete(storeCtx, record.ID, recorder.statusCode(), header.Get("Content-Type"), storedHeaders(header), recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Error().Err(err).Str("path", r.URL.Path).Msg("failed to store idempotent response")
+		}
+	})
+}
+
+func storedHeaders(header http.Header) http.Header {
+	stored := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := header.Values(name); len(values) != 0 {
+			stored[name] = values
+		}
+	}
+	return stored
+}
+
+func noStore(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	h.Write([]byte{0})
+	io.WriteString(h, r.URL.RequestURI())
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Writes through to the wrapped writer while keeping a copy of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers of the IETF RateLimit header fields draft
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+)
+
+// RateLimiter throttles requests with a token bucket per client and route
+// group. Clients are identified by the user of their session, so it must wrap
+// handlers after SessionChecker, or by IP if they have none.
+type RateLimiter struct {
+	store     RateLimitStore
+	clientIPs *ClientIPResolver
+}
+
+func NewRateLimiter(store RateLimitStore, clientIPs *ClientIPResolver) *RateLimiter {
+	return &RateLimiter{store: store, clientIPs: clientIPs}
+}
+
+// Returns a middleware limiting the requests of each client to the group's
+// routes. Routes sharing a group share their buckets.
+func (l *RateLimiter) Handler(group string, limit RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := l.take(w, r, group, limit); err != nil {
+				respond.Error(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Takes a token of the group's bucket for the client of r and sets the
+// RateLimit headers. Returns an error with apperror.CodeRateLimited if the
+// bucket is empty.
+func (l *RateLimiter) take(w http.ResponseWriter, r *http.Request, group string, limit RateLimit) error {
+	key := group + ":" + l.clientKey(r)
+	result, err := l.store.Take(r.Context(), key, limit, time.Now())
+	if err != nil {
+		// Better to serve unthrottled than not at all
+		log.Error().Err(err).Str("rate-limit-key", key).Msg("rate limit store failed")
+		return nil
+	}
+
+	header := w.Header()
+	header.Set(RateLimitLimitHeader, strconv.Itoa(limit.Burst))
+	header.Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+	header.Set(RateLimitResetHeader, ceilSeconds(result.ResetAfter))
+
+	if !result.Allowed {
+		header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+		return apperror.New(apperror.CodeRateLimited, "too many requests, retry after %s seconds", ceilSeconds(result.RetryAfter))
+	}
+	return nil
+}
+
+type rateLimitTakerContextKey struct{}
+
+type rateLimitTaker func(group string) error
+
+// Returns a middleware that lets handlers further down throttle parts of a
+// request by a group of their own through TakeRateLimitToken, e.g. single
+// graphql fields. limits returns the limit of a group.
+func (l *RateLimiter) InContext(limits func(group string) RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var taker rateLimitTaker = func(group string) error {
+				return l.take(w, r, group, limits(group))
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitTakerContextKey{}, taker)))
+		})
+	}
+}
+
+// Takes a token of the group for the client of the request ctx belongs to.
+// Does nothing unless the request went through RateLimiter.InContext.
+func TakeRateLimitToken(ctx context.Context, group string) error {
+	taker, ok := ctx.Value(rateLimitTakerContextKey{}).(rateLimitTaker)
+	if !ok {
+		return nil
+	}
+	return taker(group)
+}
+
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if session, ok := r.Context().Value(model.UserSessionContextKey{}).(model.UserSession); ok {
+		return "user:" + strconv.FormatUint(session.UserID, 10)
+	}
+	return "ip:" + l.clientIPs.ClientIP(r)
+}
+
+// Header values are whole seconds, rounded up so that clients waiting that long
+// do get a token
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket parameters. A bucket holds up to Burst tokens and gains Rate
+// tokens per second, each request takes one.
+type RateLimit struct {
+	Burst int
+	Rate  float64
+}
+
+// Time for an empty bucket to fill up
+func (l RateLimit) fillTime() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+type RateLimitResult struct {
+	Allowed bool
+	// Tokens left after this request
+	Remaining int
+	// Until the next token is available, zero if Allowed
+	RetryAfter time.Duration
+	// Until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Keeps the token buckets. MemoryRateLimitStore only limits a single server
+// instance, running several needs a shared implementation.
+type RateLimitStore interface {
+	// Takes a token from the bucket of key, which starts out full
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// Buckets that have been full for a while are dropped by the sweep
+	fullAt time.Time
+}
+
+// Sweep idle buckets every this many takes
+const rateLimitSweepInterval = 1000
+
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+var _ RateLimitStore = (*MemoryRateLimitStore)(nil)
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%rateLimitSweepInterval == 0 {
+		s.sweep(now)
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.updated).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+		bucket.updated = now
+	}
+
+	var result RateLimitResult
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - bucket.tokens) / limit.Rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.ResetAfter = secondsToDuration((float64(limit.Burst) - bucket.tokens) / limit.Rate)
+	bucket.fullAt = now.Add(result.ResetAfter)
+	return result, nil
+}
+
+// Full buckets are the same as missing ones
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !bucket.fullAt.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/nrawrx3/workout-backend/model"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,9 +24,11 @@ func Recover(wrapped http.Handler) http.Handler {
 					err = constants.ErrCodeUnknown
 				}
 
-				http.Error(w, "internal-server-error", http.StatusInternalServerError)
-
 				log.Error().Err(err).Str("path", r.URL.Path).Str("remote-address", r.RemoteAddr).Str("forwarded-for", r.Header.Get("X-Forwarded-For")).Msg("recovered from panic in handler")
+
+				// Only reaches the client if the handler hadn't written
+				// the header yet
+				respond.JSON(w, http.StatusInternalServerError, &model.DefaultInternalServerErrorResponse)
 			}
 		}()
 		wrapped.ServeHTTP(w, r)
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+// Puts the http.ResponseWriter into the request context, so that code further
+// down which otherwise only sees the context, like graphql resolvers, can set
+// headers such as cookies. Headers must be set before the handler starts
+// writing the body.
+func ResponseWriterInContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), model.ResponseWriterContextKey{}, w)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -8,7 +8,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/auth"
 	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/handler/respond"
 	"github.com/nrawrx3/workout-backend/model"
 	"github.com/nrawrx3/workout-backend/store"
 	"github.com/nrawrx3/workout-backend/util"
@@ -18,71 +21,52 @@ import (
 
 type SessionChecker struct {
 	sessionInfo             model.SessionCookieInfo
-	cipher                  *util.AESCipher
+	keyring                 *util.AESKeyring
 	RedirectOnInvalidCookie bool
 	userStore               *store.UserStore
 }
 
-func NewSessionChecker(userStore *store.UserStore, sessionInfo model.SessionCookieInfo, cipher *util.AESCipher) *SessionChecker {
-	return &SessionChecker{sessionInfo: sessionInfo, cipher: cipher, userStore: userStore}
+func NewSessionChecker(userStore *store.UserStore, sessionInfo model.SessionCookieInfo, keyring *util.AESKeyring) *SessionChecker {
+	return &SessionChecker{sessionInfo: sessionInfo, keyring: keyring, userStore: userStore}
 }
 
 func (h *SessionChecker) Handler(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Fetch cookie value
-		cookieValueRaw, err := util.ReadCookieDecodeB64ThenDecrypt(r, h.sessionInfo.CookieName, h.cipher)
+		session, err := h.loadSession(w, r)
+		if err != nil {
+			if apperror.From(err).Code != apperror.CodeUnauthenticated {
+				respond.Error(w, r, err)
+				return
+			}
 
-		sendResponse := func(errorMessage string) {
 			if h.RedirectOnInvalidCookie {
 				log.Info().Dict("session-checker", zerolog.Dict().Str("remote-address", r.RemoteAddr)).Msg("redirecting to /login")
 				http.Redirect(w, r, constants.LoginPath, http.StatusSeeOther)
 			} else {
 				log.Info().Dict("session-checker", zerolog.Dict().Str("remote-address", r.RemoteAddr).Str("request-path", r.URL.Path)).Msg("sending 401 Unauthorized")
 
-				responseData := model.UserNotLoggedInErrorResponse
-				responseData.ErrorMessage = errorMessage
-
-				util.AddJsonContentHeader(w, http.StatusUnauthorized)
-				if err := json.NewEncoder(w).Encode(&model.UserNotLoggedInErrorResponse); err != nil {
-					log.Error().Err(err).Msg("unexpected json encoding error")
-				}
-			}
-		}
-
-		errorMessage := "cookie unset or expired"
-
-		if err != nil {
-			if !errors.Is(err, constants.ErrCodeNotFound) {
-				errorMessage = "failed to decode cookie"
+				respond.Error(w, r, err)
 			}
-			sendResponse(errorMessage)
 			return
 		}
 
-		var cookieValue model.SessionCookieValue
-		err = json.NewDecoder(strings.NewReader(cookieValueRaw)).Decode(&cookieValue)
-		if err != nil {
-			log.Info().Err(err).Msg("failed to parse JSON")
-			sendResponse("Invalid cookie data, failed to parse decrypted JSON")
-			return
-		}
-
-		uintSessionID, err := util.Uint64FromStringID(cookieValue.SessionID)
-		if err != nil {
-			log.Info().Err(err).Msg("failed to parse JSON, the SessionID could not be parsed as uint64")
-			sendResponse("Invalid cookie data, failed to parse decrypted JSON")
-			return
-		}
+		ctx := context.WithValue(r.Context(), model.UserSessionContextKey{}, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
 
-		session, err := h.userStore.LoadSession(r.Context(), uintSessionID, time.Now())
+// Like Handler, but lets requests without a valid session through, leaving it
+// to next to reject them. For endpoints that serve both, like the graphql one
+// with its login mutation.
+func (h *SessionChecker) OptionalHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := h.loadSession(w, r)
 		if err != nil {
-			if errors.Is(err, constants.ErrCodeNotFound) {
-				util.AddJsonContentHeader(w, http.StatusNotFound)
-				json.NewEncoder(w).Encode(model.UserNotLoggedInErrorResponse)
+			if apperror.From(err).Code != apperror.CodeUnauthenticated {
+				respond.Error(w, r, err)
 				return
 			}
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(model.DefaultInternalServerErrorResponse)
+			next.ServeHTTP(w, r)
 			return
 		}
 
@@ -90,3 +74,45 @@ func (h *SessionChecker) Handler(next http.Handler) http.HandlerFunc {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// Loads the session whose id is in the cookie. Returns an unauthenticated error
+// if there is none, or it has expired. Cookies encrypted with an old key are
+// written again with the primary one.
+func (h *SessionChecker) loadSession(w http.ResponseWriter, r *http.Request) (model.UserSession, error) {
+	cookieValueRaw, stale, err := util.ReadCookieDecodeB64ThenDecrypt(r, h.sessionInfo.CookieName, h.keyring)
+	if err != nil {
+		if !errors.Is(err, constants.ErrCodeNotFound) {
+			return model.UserSession{}, apperror.Unauthenticated("failed to decode cookie")
+		}
+		return model.UserSession{}, apperror.Unauthenticated("cookie unset or expired")
+	}
+
+	var cookieValue model.SessionCookieValue
+	err = json.NewDecoder(strings.NewReader(cookieValueRaw)).Decode(&cookieValue)
+	if err != nil {
+		log.Info().Err(err).Msg("failed to parse JSON")
+		return model.UserSession{}, apperror.Unauthenticated("Invalid cookie data, failed to parse decrypted JSON")
+	}
+
+	uintSessionID, err := util.Uint64FromStringID(cookieValue.SessionID)
+	if err != nil {
+		log.Info().Err(err).Msg("failed to parse JSON, the SessionID could not be parsed as uint64")
+		return model.UserSession{}, apperror.Unauthenticated("Invalid cookie data, failed to parse decrypted JSON")
+	}
+
+	session, err := h.userStore.LoadSession(r.Context(), uintSessionID, time.Now())
+	if errors.Is(err, constants.ErrCodeNotFound) {
+		return session, apperror.Unauthenticated("session expired or logged out")
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if stale {
+		if err := auth.WriteSessionCookie(w, h.sessionInfo, h.keyring, &session); err != nil {
+			// The old cookie keeps working until its key is dropped
+			log.Error().Err(err).Uint64("sessionID", session.ID).Msg("failed to re-issue session cookie")
+		}
+	}
+	return session, nil
+}
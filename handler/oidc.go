@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/auth"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/rs/zerolog/log"
+)
+
+type OIDCLoginHandler struct {
+	// nil if no identity providers are configured
+	logins *auth.OIDCLogins
+	// Where the browser ends up after the callback
+	frontendURL *url.URL
+}
+
+func NewOIDCLoginHandler(logins *auth.OIDCLogins, frontendURL *url.URL) *OIDCLoginHandler {
+	return &OIDCLoginHandler{logins: logins, frontendURL: frontendURL}
+}
+
+// Success response type: 302 to the provider's authorization endpoint, with
+// the flow cookie set
+// Failure response type, all model.ResponseFormatJSON:
+//
+//	404 - no provider with the name
+//	405 - not a GET request
+//	500 - unexpected server error, e.g. the provider can't be reached
+func (h *OIDCLoginHandler) Begin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respond.MethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if h.logins == nil {
+		respond.Error(w, r, apperror.NotFound("login with identity providers is not enabled"))
+		return
+	}
+
+	authURL, err := h.logins.Begin(r.Context(), w, mux.Vars(r)["provider"])
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Success response type: 302 to the frontend URL. Without query parameters the
+// session cookie is set. Users with two factor authentication get a
+// second_factor_challenge parameter to answer at /login/second-factor instead,
+// and failures an error parameter with the error code.
+// Failure response type, all model.ResponseFormatJSON:
+//
+//	404 - login with identity providers is off
+//	405 - not a GET request
+func (h *OIDCLoginHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respond.MethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if h.logins == nil {
+		respond.Error(w, r, apperror.NotFound("login with identity providers is not enabled"))
+		return
+	}
+
+	redirectURL := *h.frontendURL
+	query := redirectURL.Query()
+
+	result, err := h.logins.Complete(w, r, mux.Vars(r)["provider"])
+	if err != nil {
+		status, resp := apperror.Response(err)
+		if status >= http.StatusInternalServerError {
+			log.Error().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("request failed")
+		}
+		query.Set("error", resp.ErrorCode)
+	} else if result.Challenge != nil {
+		query.Set("second_factor_challenge", result.Challenge.Token)
+	}
+
+	redirectURL.RawQuery = query.Encode()
+	// The challenge is in the URL, keep it out of caches
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
@@ -0,0 +1,473 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/handler/middleware"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/openapi"
+	"github.com/rs/zerolog/log"
+)
+
+const openAPISessionScheme = "sessionCookie"
+
+// Builds the OpenAPI document of the REST endpoints. Every route registered on
+// the router except the GraphQL ones must be described here, App.Init refuses
+// to start otherwise. csrfHeader is empty if the CSRF check is disabled.
+func OpenAPIDocument(cookieName, csrfHeader string) *openapi.Document {
+	doc := openapi.New("workout-backend", "1.0.0")
+	doc.Info.Description = "REST API of the workout backend. Responses are wrapped in ResponseFormatJSON, errors set error_code and error_message."
+	doc.Components.SecuritySchemes[openAPISessionScheme] = &openapi.SecurityScheme{
+		Type: "apiKey",
+		In:   "cookie",
+		Name: cookieName,
+	}
+
+	s := openAPISpec{doc: doc}
+	s.addSessionOperations()
+	s.addWorkoutOperations()
+	s.addProfileOperations()
+	if csrfHeader != "" {
+		s.addCSRFHeader(csrfHeader)
+	}
+	s.addRateLimitResponses()
+
+	doc.Add(http.MethodGet, constants.OpenAPIPath, &openapi.Operation{
+		Summary:     "This document",
+		OperationID: "getOpenAPIDocument",
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "OpenAPI 3 document",
+				Content:     map[string]*openapi.MediaType{"application/json": {Schema: &openapi.Schema{Type: "object"}}},
+			},
+		},
+	})
+	return doc
+}
+
+type openAPISpec struct {
+	doc *openapi.Document
+}
+
+func (s *openAPISpec) jsonBody(v interface{}) *openapi.RequestBody {
+	return &openapi.RequestBody{
+		Required: true,
+		Content:  map[string]*openapi.MediaType{"application/json": {Schema: s.doc.SchemaOf(v)}},
+	}
+}
+
+func (s *openAPISpec) dataResponse(description string, data interface{}) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content: map[string]*openapi.MediaType{
+			"application/json": {Schema: s.doc.Envelope(model.ResponseFormatJSON{}, "data", data)},
+		},
+	}
+}
+
+func (s *openAPISpec) errorResponse(description string) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content: map[string]*openapi.MediaType{
+			"application/json": {Schema: s.doc.SchemaOf(model.ResponseFormatJSON{})},
+		},
+	}
+}
+
+// Adds the responses every authenticated operation can fail with
+func (s *openAPISpec) authenticated(op *openapi.Operation) *openapi.Operation {
+	op.Security = []map[string][]string{{openAPISessionScheme: {}}}
+	op.Responses["401"] = s.errorResponse("No valid session")
+	op.Responses["500"] = s.errorResponse("Unexpected server error")
+	return op
+}
+
+func (s *openAPISpec) idParameter(description string) openapi.Parameter {
+	return openapi.Parameter{Name: "id", In: "path", Required: true, Description: description, Schema: &openapi.Schema{Type: "string", Format: "uint64"}}
+}
+
+func queryParameter(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// State changing operations authenticated by the session cookie need the CSRF
+// token header
+func (s *openAPISpec) addCSRFHeader(header string) {
+	for _, item := range s.doc.Paths {
+		for method, op := range *item {
+			if method == "get" || len(op.Security) == 0 {
+				continue
+			}
+			op.Parameters = append(op.Parameters, openapi.Parameter{
+				Name:        header,
+				In:          "header",
+				Description: "Value of the CSRF token cookie issued at login",
+				Required:    true,
+				Schema:      &openapi.Schema{Type: "string"},
+			})
+			op.Responses["403"] = s.errorResponse("Missing or mismatched CSRF token")
+		}
+	}
+}
+
+// Every operation added so far is rate limited
+func (s *openAPISpec) addRateLimitResponses() {
+	headers := map[string]*openapi.Header{
+		"Retry-After":                       {Description: "Seconds until a request is allowed again", Schema: &openapi.Schema{Type: "integer"}},
+		middleware.RateLimitLimitHeader:     {Description: "Requests allowed in a burst", Schema: &openapi.Schema{Type: "integer"}},
+		middleware.RateLimitRemainingHeader: {Description: "Requests left in the burst", Schema: &openapi.Schema{Type: "integer"}},
+		middleware.RateLimitResetHeader:     {Description: "Seconds until the full burst is available again", Schema: &openapi.Schema{Type: "integer"}},
+	}
+	for _, item := range s.doc.Paths {
+		for _, op := range *item {
+			resp := s.errorResponse("Rate limited")
+			resp.Headers = headers
+			op.Responses["429"] = resp
+		}
+	}
+}
+
+func (s *openAPISpec) addSessionOperations() {
+	s.doc.Add(http.MethodPost, constants.LoginPath, &openapi.Operation{
+		Summary:     "Log in and receive the session cookie",
+		OperationID: "login",
+		Tags:        []string{"session"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]*openapi.MediaType{
+				"application/json":                  {Schema: s.doc.SchemaOf(model.UserLoginRequestBody{})},
+				"application/x-www-form-urlencoded": {Schema: s.doc.SchemaOf(model.UserLoginRequestBody{})},
+				"multipart/form-data":               {Schema: s.doc.SchemaOf(model.UserLoginRequestBody{})},
+			},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Logged in and the session cookie is set, or a second factor is required",
+				Headers:     map[string]*openapi.Header{"Set-Cookie": {Schema: &openapi.Schema{Type: "string"}}},
+				Content:     s.dataResponse("", model.LoginResponseJSON{}).Content,
+			},
+			"401": s.errorResponse("Wrong password"),
+			"404": s.errorResponse("No user with the given email"),
+			"415": s.errorResponse("Unsupported Content-Type"),
+			"422": s.errorResponse("Missing credentials, or a malformed or too large body"),
+			"500": s.errorResponse("Unexpected server error"),
+		},
+	})
+
+	s.doc.Add(http.MethodPost, constants.LoginSecondFactorPath, &openapi.Operation{
+		Summary:     "Answer the second factor challenge of a login",
+		Description: "For users with two factor authentication, whose login returned a second_factor_challenge instead of setting the session cookie",
+		OperationID: "loginSecondFactor",
+		Tags:        []string{"session"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]*openapi.MediaType{
+				"application/json":                  {Schema: s.doc.SchemaOf(model.SecondFactorRequestBody{})},
+				"application/x-www-form-urlencoded": {Schema: s.doc.SchemaOf(model.SecondFactorRequestBody{})},
+				"multipart/form-data":               {Schema: s.doc.SchemaOf(model.SecondFactorRequestBody{})},
+			},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Logged in, the session cookie is set",
+				Headers:     map[string]*openapi.Header{"Set-Cookie": {Schema: &openapi.Schema{Type: "string"}}},
+				Content:     s.dataResponse("", model.LoginResponseJSON{}).Content,
+			},
+			"401": s.errorResponse("Wrong or already used code, or an invalid or expired challenge"),
+			"415": s.errorResponse("Unsupported Content-Type"),
+			"422": s.errorResponse("Missing fields, or a malformed or too large body"),
+			"429": s.errorResponse("Too many wrong codes for the user, or the login rate limit"),
+			"500": s.errorResponse("Unexpected server error"),
+		},
+	})
+
+	s.doc.Add(http.MethodPost, constants.LoginMagicLinkPath, &openapi.Operation{
+		Summary:     "Send a login link to the email",
+		Description: "The response is the same whether or not the email belongs to a user. The link points to the frontend with the token query parameter, which it should POST to " + constants.LoginMagicLinkVerifyPath,
+		OperationID: "requestMagicLink",
+		Tags:        []string{"session"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]*openapi.MediaType{
+				"application/json":                  {Schema: s.doc.SchemaOf(model.MagicLinkRequestBody{})},
+				"application/x-www-form-urlencoded": {Schema: s.doc.SchemaOf(model.MagicLinkRequestBody{})},
+				"multipart/form-data":               {Schema: s.doc.SchemaOf(model.MagicLinkRequestBody{})},
+			},
+		},
+		Responses: map[string]*openapi.Response{
+			"202": s.dataResponse("The link is being sent, if the email belongs to a user", model.MagicLinkRequestedResponseJSON{}),
+			"404": s.errorResponse("Magic link login is not enabled"),
+			"415": s.errorResponse("Unsupported Content-Type"),
+			"422": s.errorResponse("Missing email, or a malformed or too large body"),
+			"500": s.errorResponse("Unexpected server error"),
+		},
+	})
+
+	s.doc.Add(http.MethodPost, constants.LoginMagicLinkVerifyPath, &openapi.Operation{
+		Summary:     "Log in with the token of a login link",
+		OperationID: "verifyMagicLink",
+		Tags:        []string{"session"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]*openapi.MediaType{
+				"application/json":                  {Schema: s.doc.SchemaOf(model.MagicLinkVerifyRequestBody{})},
+				"application/x-www-form-urlencoded": {Schema: s.doc.SchemaOf(model.MagicLinkVerifyRequestBody{})},
+				"multipart/form-data":               {Schema: s.doc.SchemaOf(model.MagicLinkVerifyRequestBody{})},
+			},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Logged in and the session cookie is set, or a second factor is required",
+				Headers:     map[string]*openapi.Header{"Set-Cookie": {Schema: &openapi.Schema{Type: "string"}}},
+				Content:     s.dataResponse("", model.LoginResponseJSON{}).Content,
+			},
+			"401": s.errorResponse("Invalid, expired or already used token, or requested from another user agent"),
+			"404": s.errorResponse("Magic link login is not enabled"),
+			"415": s.errorResponse("Unsupported Content-Type"),
+			"422": s.errorResponse("Missing token, or a malformed or too large body"),
+			"500": s.errorResponse("Unexpected server error"),
+		},
+	})
+
+	providerName := openapi.Parameter{Name: "provider", In: "path", Required: true, Description: "Name of the identity provider in the config", Schema: &openapi.Schema{Type: "string"}}
+	redirect := func(description string) *openapi.Response {
+		return &openapi.Response{
+			Description: description,
+			Headers: map[string]*openapi.Header{
+				"Location":   {Schema: &openapi.Schema{Type: "string"}},
+				"Set-Cookie": {Schema: &openapi.Schema{Type: "string"}},
+			},
+		}
+	}
+
+	s.doc.Add(http.MethodGet, openapi.PathFromMuxTemplate(constants.LoginOIDCPath), &openapi.Operation{
+		Summary:     "Start a login with an OpenID Connect provider",
+		Description: "Meant to be navigated to by the browser, not fetched",
+		OperationID: "beginOIDCLogin",
+		Tags:        []string{"session"},
+		Parameters:  []openapi.Parameter{providerName},
+		Responses: map[string]*openapi.Response{
+			"302": redirect("Redirect to the provider, the cookie ties the callback to this browser"),
+			"404": s.errorResponse("No provider with the name, or login with identity providers is not enabled"),
+			"500": s.errorResponse("Unexpected server error, e.g. the provider can't be reached"),
+		},
+	})
+
+	s.doc.Add(http.MethodGet, openapi.PathFromMuxTemplate(constants.LoginOIDCCallbackPath), &openapi.Operation{
+		Summary:     "Where the OpenID Connect provider sends the browser back to",
+		Description: "Links the provider account to the user with its verified email, or to a new user, on the first login",
+		OperationID: "completeOIDCLogin",
+		Tags:        []string{"session"},
+		Parameters: []openapi.Parameter{
+			providerName,
+			queryParameter("code", "Authorization code", &openapi.Schema{Type: "string"}),
+			queryParameter("state", "Must match the one in the flow cookie", &openapi.Schema{Type: "string"}),
+			queryParameter("error", "Set by the provider if the login failed there", &openapi.Schema{Type: "string"}),
+		},
+		Responses: map[string]*openapi.Response{
+			"302": redirect("Redirect to the frontend. Without query parameters the session cookie is set, otherwise it has " +
+				"second_factor_challenge for users with two factor authentication, or error with the error code."),
+			"404": s.errorResponse("Login with identity providers is not enabled"),
+		},
+	})
+
+	s.doc.Add(http.MethodGet, constants.AmILoggedInPath, &openapi.Operation{
+		Summary:     "Check whether the session cookie is valid",
+		OperationID: "amILoggedIn",
+		Tags:        []string{"session"},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("Whether the user is logged in", model.AmILoggedInResponseJSON{}),
+			"500": s.errorResponse("Unexpected server error"),
+		},
+	})
+}
+
+func (s *openAPISpec) addWorkoutOperations() {
+	workoutNotFound := s.errorResponse("No workout with the given id")
+	conflict := s.dataResponse("expected_version is stale, data holds the current workout", model.WorkoutResponseJSON{})
+	invalid := s.errorResponse("Invalid request, error_fields lists the offending fields")
+	workoutID := s.idParameter("Workout id")
+
+	s.doc.Add(http.MethodGet, constants.WorkoutsListPath, s.authenticated(&openapi.Operation{
+		Summary:     "List all workouts of the user",
+		Description: "Deprecated in favour of the paginated GET /api/v1/workouts",
+		OperationID: "listAllWorkouts",
+		Tags:        []string{"workouts"},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("Workouts in list order", model.WorkoutListResponseJSON{}),
+		},
+	}))
+
+	v1 := func(path string) string {
+		return openapi.PathFromMuxTemplate(constants.ApiV1Prefix + path)
+	}
+
+	s.doc.Add(http.MethodGet, v1(constants.ApiV1WorkoutsPath), s.authenticated(&openapi.Operation{
+		Summary:     "List a page of workouts",
+		OperationID: "listWorkouts",
+		Tags:        []string{"workouts"},
+		Parameters: []openapi.Parameter{
+			queryParameter("limit", "Page size", &openapi.Schema{Type: "integer"}),
+			queryParameter("after", "end_cursor of the previous page", &openapi.Schema{Type: "string"}),
+			queryParameter("sort", "Field to sort by", &openapi.Schema{Type: "string", Enum: []string{"order", "created_at"}}),
+			queryParameter("direction", "Sort direction", &openapi.Schema{Type: "string", Enum: []string{"asc", "desc"}}),
+			queryParameter("kind", "Only list workouts of this kind", &openapi.Schema{Type: "string"}),
+		},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("A page of workouts", model.WorkoutPageResponseJSON{}),
+			"400": invalid,
+		},
+	}))
+
+	s.doc.Add(http.MethodPost, v1(constants.ApiV1WorkoutsPath), s.authenticated(&openapi.Operation{
+		Summary:     "Create a workout",
+		OperationID: "createWorkout",
+		Tags:        []string{"workouts"},
+		Parameters: []openapi.Parameter{
+			{Name: "Idempotency-Key", In: "header", Description: "Replays the status, body and Location of an earlier request with the same key", Schema: &openapi.Schema{Type: "string"}},
+		},
+		RequestBody: s.jsonBody(model.CreateWorkoutRequestJSON{}),
+		Responses: map[string]*openapi.Response{
+			"201": {
+				Description: "The created workout",
+				Headers:     map[string]*openapi.Header{"Location": {Description: "URL of the created workout", Schema: &openapi.Schema{Type: "string"}}},
+				Content:     s.dataResponse("", model.WorkoutResponseJSON{}).Content,
+			},
+			"400": invalid,
+		},
+	}))
+
+	s.doc.Add(http.MethodGet, v1(constants.ApiV1WorkoutsOrderPath), s.authenticated(&openapi.Operation{
+		Summary:     "Get the order of the workout list",
+		OperationID: "getWorkoutOrder",
+		Tags:        []string{"workouts"},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("Workout ids in list order", model.WorkoutListStateResponseJSON{}),
+		},
+	}))
+
+	s.doc.Add(http.MethodPut, v1(constants.ApiV1WorkoutsOrderPath), s.authenticated(&openapi.Operation{
+		Summary:     "Reorder the workout list",
+		OperationID: "reorderWorkouts",
+		Tags:        []string{"workouts"},
+		RequestBody: s.jsonBody(model.ReorderWorkoutsRequestJSON{}),
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("The new order", model.WorkoutListStateResponseJSON{}),
+			"400": invalid,
+			"409": s.dataResponse("expected_list_version is stale, data holds the current order", model.WorkoutListStateResponseJSON{}),
+		},
+	}))
+
+	s.doc.Add(http.MethodGet, v1(constants.ApiV1WorkoutPath), s.authenticated(&openapi.Operation{
+		Summary:     "Get a workout",
+		OperationID: "getWorkout",
+		Tags:        []string{"workouts"},
+		Parameters:  []openapi.Parameter{workoutID},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("The workout", model.WorkoutResponseJSON{}),
+			"404": workoutNotFound,
+		},
+	}))
+
+	s.doc.Add(http.MethodPatch, v1(constants.ApiV1WorkoutPath), s.authenticated(&openapi.Operation{
+		Summary:     "Update fields of a workout",
+		OperationID: "patchWorkout",
+		Tags:        []string{"workouts"},
+		Parameters:  []openapi.Parameter{workoutID},
+		RequestBody: s.jsonBody(model.PatchWorkoutRequestJSON{}),
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("The updated workout", model.WorkoutResponseJSON{}),
+			"400": invalid,
+			"404": workoutNotFound,
+			"409": conflict,
+		},
+	}))
+
+	s.doc.Add(http.MethodDelete, v1(constants.ApiV1WorkoutPath), s.authenticated(&openapi.Operation{
+		Summary:     "Delete a workout",
+		OperationID: "deleteWorkout",
+		Tags:        []string{"workouts"},
+		Parameters: []openapi.Parameter{
+			workoutID,
+			queryParameter("expected_version", "Only delete if the workout is at this version", &openapi.Schema{Type: "integer"}),
+		},
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "Deleted"},
+			"400": invalid,
+			"404": workoutNotFound,
+			"409": conflict,
+		},
+	}))
+
+	s.doc.Add(http.MethodPost, v1(constants.ApiV1WorkoutMovePath), s.authenticated(&openapi.Operation{
+		Summary:     "Move a workout after another one",
+		OperationID: "moveWorkout",
+		Tags:        []string{"workouts"},
+		Parameters:  []openapi.Parameter{workoutID},
+		RequestBody: s.jsonBody(model.MoveWorkoutRequestJSON{}),
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("The moved workout", model.WorkoutResponseJSON{}),
+			"400": invalid,
+			"404": workoutNotFound,
+			"409": conflict,
+		},
+	}))
+}
+
+func (s *openAPISpec) addProfileOperations() {
+	v1 := func(path string) string {
+		return openapi.PathFromMuxTemplate(constants.ApiV1Prefix + path)
+	}
+
+	s.doc.Add(http.MethodGet, v1(constants.ApiV1ProfilePath), s.authenticated(&openapi.Operation{
+		Summary:     "Get the profile of the user",
+		OperationID: "getProfile",
+		Tags:        []string{"profile"},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("The user", model.UserResponseJSON{}),
+		},
+	}))
+
+	s.doc.Add(http.MethodGet, v1(constants.ApiV1SessionsPath), s.authenticated(&openapi.Operation{
+		Summary:     "List the active sessions of the user",
+		OperationID: "listSessions",
+		Tags:        []string{"profile"},
+		Responses: map[string]*openapi.Response{
+			"200": s.dataResponse("Sessions, current marks the requesting one", model.SessionListResponseJSON{}),
+		},
+	}))
+
+	s.doc.Add(http.MethodDelete, v1(constants.ApiV1SessionPath), s.authenticated(&openapi.Operation{
+		Summary:     "Log out a session",
+		OperationID: "deleteSession",
+		Tags:        []string{"profile"},
+		Parameters:  []openapi.Parameter{s.idParameter("Session id")},
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "Deleted"},
+			"404": s.errorResponse("No session with the given id"),
+		},
+	}))
+}
+
+// Serves a document, encoded once up front
+type OpenAPIHandler struct {
+	body []byte
+}
+
+func NewOpenAPIHandler(doc *openapi.Document) (*OpenAPIHandler, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAPIHandler{body: body}, nil
+}
+
+func (h *OpenAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(h.body); err != nil {
+		log.Error().Err(err).Msg("failed to write openapi document")
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/util"
+)
+
+// Handles the /api/v1 endpoints about the logged in user and their sessions
+type ProfileHandler struct {
+	userStore *store.UserStore
+}
+
+func NewProfileHandler(userStore *store.UserStore) *ProfileHandler {
+	return &ProfileHandler{userStore: userStore}
+}
+
+// GET /api/v1/profile
+//
+// Responds with model.UserResponseJSON
+func (h *ProfileHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	user, err := h.userStore.GetUser(r.Context(), session.UserID)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var resp model.UserResponseJSON
+	resp.FromModel(&user)
+	respond.Data(w, http.StatusOK, resp)
+}
+
+// GET /api/v1/sessions
+//
+// Responds with model.SessionListResponseJSON
+func (h *ProfileHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	sessions, err := h.userStore.GetSessionsOfUser(r.Context(), session.UserID, time.Now())
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	resp := model.SessionListResponseJSON{
+		Sessions: make([]model.SessionResponseJSON, len(sessions)),
+	}
+	for i := range sessions {
+		resp.Sessions[i].FromModel(&sessions[i], session.ID)
+	}
+	respond.Data(w, http.StatusOK, resp)
+}
+
+// DELETE /api/v1/sessions/{id}
+//
+// Logs the session out. Responds with 204 on success.
+func (h *ProfileHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	id, err := util.Uint64FromStringID(mux.Vars(r)["id"])
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	if err := h.userStore.DeleteSessionOfUser(r.Context(), session.UserID, id); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+// Package respond writes the JSON responses of the REST handlers and the
+// middleware. Every body is a model.ResponseFormatJSON, and errors are mapped
+// to their status code and error code by apperror.
+package respond
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/rs/zerolog/log"
+)
+
+// Writes resp with the given status. The content type is set before the
+// status, headers added after WriteHeader are ignored.
+func JSON(w http.ResponseWriter, status int, resp *model.ResponseFormatJSON) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error().Err(err).Msg("failed to encode response json")
+	}
+}
+
+// Writes data wrapped in model.ResponseFormatJSON
+func Data(w http.ResponseWriter, status int, data interface{}) {
+	JSON(w, status, &model.ResponseFormatJSON{Data: data})
+}
+
+// Writes err with the status matching its code. Internal errors are logged and
+// sent without their details.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	ErrorWithData(w, r, err, nil)
+}
+
+// Like Error, with data such as the current state of a conflicting entity
+func ErrorWithData(w http.ResponseWriter, r *http.Request, err error, data interface{}) {
+	status, resp := apperror.Response(err)
+	if status >= http.StatusInternalServerError {
+		log.Error().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("request failed")
+	}
+	resp.Data = data
+	JSON(w, status, &resp)
+}
+
+// Responds with 405 to requests on routes registered without a method matcher
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	Error(w, r, apperror.New(apperror.CodeMethodNotAllowed, "expected %s request, received %s", strings.Join(allowed, " or "), r.Method))
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+// Request bodies larger than this are rejected
+const maxRequestBodyBytes = 1 << 20
+
+// Decodes the JSON request body into dst, rejecting unknown fields
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return decodeJSONBodyWithLimit(w, r, dst, maxRequestBodyBytes)
+}
+
+func decodeJSONBodyWithLimit(w http.ResponseWriter, r *http.Request, dst interface{}, limit int64) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, io.EOF):
+			return apperror.InvalidInput("request body is empty")
+		case errors.As(err, &maxBytesErr):
+			return apperror.InvalidInput("request body exceeds %d bytes", limit)
+		}
+		return apperror.InvalidInput("malformed request body: %s", err.Error())
+	}
+	return nil
+}
+
+// The session is put in the context by middleware.SessionChecker
+func sessionFromRequest(r *http.Request) (model.UserSession, error) {
+	session, ok := r.Context().Value(model.UserSessionContextKey{}).(model.UserSession)
+	if !ok {
+		return session, apperror.Unauthenticated("not logged in")
+	}
+	return session, nil
+}
@@ -0,0 +1,492 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/handler/respond"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+	"github.com/nrawrx3/workout-backend/util"
+	"github.com/nrawrx3/workout-backend/validation"
+)
+
+const defaultWorkoutsPageSize = 20
+
+// Handles the /api/v1/workouts endpoints, the REST counterpart of the workout
+// queries and mutations of the GraphQL API.
+type WorkoutsHandler struct {
+	workoutStore *store.WorkoutStore
+	validator    *validation.WorkoutValidator
+	maxPageSize  int
+}
+
+func NewWorkoutsHandler(workoutStore *store.WorkoutStore, validator *validation.WorkoutValidator, maxPageSize int) *WorkoutsHandler {
+	return &WorkoutsHandler{workoutStore: workoutStore, validator: validator, maxPageSize: maxPageSize}
+}
+
+func WorkoutLocation(id uint64) string {
+	return fmt.Sprintf("%s%s/%d", constants.ApiV1Prefix, constants.ApiV1WorkoutsPath, id)
+}
+
+// Converts the workouts, filling in their positions in the user's list
+func (h *WorkoutsHandler) workoutsJSON(ctx context.Context, userID uint64, workouts []model.Workout) ([]model.WorkoutResponseJSON, error) {
+	positions, err := h.workoutStore.GetWorkoutPositions(ctx, []uint64{userID})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]model.WorkoutResponseJSON, len(workouts))
+	for i := range workouts {
+		resp[i].FromModel(&workouts[i])
+		resp[i].Order = positions[userID][workouts[i].ID]
+	}
+	return resp, nil
+}
+
+func (h *WorkoutsHandler) writeWorkout(w http.ResponseWriter, r *http.Request, status int, workout *model.Workout) {
+	resp, err := h.workoutsJSON(r.Context(), workout.UserID, []model.Workout{*workout})
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	respond.Data(w, status, resp[0])
+}
+
+// Responds with the current state of the workout along with the conflict
+func (h *WorkoutsHandler) writeConflict(w http.ResponseWriter, r *http.Request, userID, id uint64) {
+	current, err := h.workoutStore.GetWorkoutOfUser(r.Context(), userID, id)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	resp, err := h.workoutsJSON(r.Context(), userID, []model.Workout{current})
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	conflict := apperror.New(apperror.CodeConflict, "workout was modified concurrently, it is now at version %d", current.Version)
+	respond.ErrorWithData(w, r, conflict, resp[0])
+}
+
+func workoutIDFromPath(r *http.Request) (uint64, error) {
+	return util.Uint64FromStringID(mux.Vars(r)["id"])
+}
+
+func parseWorkoutKind(kind string) (model.WorkoutKind, error) {
+	k, err := model.CastWorkoutKind(kind)
+	if err != nil {
+		return k, apperror.InvalidFields([]apperror.FieldError{{Field: "kind", Message: fmt.Sprintf("unknown workout kind %q", kind)}})
+	}
+	return k, nil
+}
+
+// GET /api/v1/workouts
+//
+// Query parameters:
+//
+//	limit     - page size
+//	after     - end_cursor of the previous page
+//	sort      - order (default) or created_at
+//	direction - asc (default) or desc
+//	kind      - repeat to match any of several kinds
+func (h *WorkoutsHandler) List(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	params := store.WorkoutPageParams{
+		After: query.Get("after"),
+		Limit: defaultWorkoutsPageSize,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		params.Limit, err = strconv.Atoi(limit)
+		if err != nil || params.Limit < 1 || params.Limit > h.maxPageSize {
+			respond.Error(w, r, apperror.InvalidInput("limit must be between 1 and %d", h.maxPageSize))
+			return
+		}
+	}
+
+	switch query.Get("sort") {
+	case "", "order":
+		params.SortField = store.WorkoutSortByOrder
+	case "created_at":
+		params.SortField = store.WorkoutSortByCreatedAt
+	default:
+		respond.Error(w, r, apperror.InvalidInput("sort must be order or created_at"))
+		return
+	}
+
+	switch strings.ToLower(query.Get("direction")) {
+	case "", "asc":
+	case "desc":
+		params.Descending = true
+	default:
+		respond.Error(w, r, apperror.InvalidInput("direction must be asc or desc"))
+		return
+	}
+
+	for _, kind := range query["kind"] {
+		k, err := parseWorkoutKind(kind)
+		if err != nil {
+			respond.Error(w, r, err)
+			return
+		}
+		params.Filter.Kinds = append(params.Filter.Kinds, k)
+	}
+
+	page, err := h.workoutStore.GetWorkoutsPage(r.Context(), session.UserID, params)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	workouts, err := h.workoutsJSON(r.Context(), session.UserID, page.Workouts)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	resp := model.WorkoutPageResponseJSON{
+		Workouts:    workouts,
+		HasNextPage: page.HasNextPage,
+		TotalCount:  page.TotalCount,
+	}
+	if len(page.Cursors) != 0 {
+		resp.EndCursor = page.Cursors[len(page.Cursors)-1]
+	}
+	respond.Data(w, http.StatusOK, resp)
+}
+
+// GET /api/v1/workouts/{id}
+func (h *WorkoutsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	id, err := workoutIDFromPath(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	workout, err := h.workoutStore.GetWorkoutOfUser(r.Context(), session.UserID, id)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	h.writeWorkout(w, r, http.StatusOK, &workout)
+}
+
+// POST /api/v1/workouts
+//
+// Body: model.CreateWorkoutRequestJSON. Responds with 201 and the Location of
+// the new workout.
+func (h *WorkoutsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var body model.CreateWorkoutRequestJSON
+	if err := decodeJSONBody(w, r, &body); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	kind, err := parseWorkoutKind(body.Kind)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	workout := model.Workout{
+		Kind:            kind,
+		Reps:            body.Reps,
+		DurationSeconds: body.DurationSeconds,
+		UserID:          session.UserID,
+	}
+	if body.Rounds != nil {
+		workout.Rounds = *body.Rounds
+	}
+
+	err = h.validator.Validate(validation.WorkoutFields{
+		Kind:            workout.Kind,
+		Reps:            &workout.Reps,
+		DurationSeconds: &workout.DurationSeconds,
+		Rounds:          &workout.Rounds,
+		Order:           body.Order,
+	})
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	if err := h.workoutStore.CreateWorkout(r.Context(), &workout, body.Order); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", WorkoutLocation(workout.ID))
+	h.writeWorkout(w, r, http.StatusCreated, &workout)
+}
+
+// PATCH /api/v1/workouts/{id}
+//
+// Body: model.PatchWorkoutRequestJSON. Responds with 409 and the current
+// workout if expected_version is stale.
+func (h *WorkoutsHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	id, err := workoutIDFromPath(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var body model.PatchWorkoutRequestJSON
+	if err := decodeJSONBody(w, r, &body); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	if body.ExpectedVersion == nil {
+		respond.Error(w, r, apperror.InvalidFields([]apperror.FieldError{{Field: "expected_version", Message: "is required"}}))
+		return
+	}
+
+	workout, err := h.workoutStore.GetWorkoutOfUser(r.Context(), session.UserID, id)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	if workout.Version != *body.ExpectedVersion {
+		h.writeConflict(w, r, session.UserID, id)
+		return
+	}
+
+	var columns []string
+	if body.Kind != nil {
+		workout.Kind, err = parseWorkoutKind(*body.Kind)
+		if err != nil {
+			respond.Error(w, r, err)
+			return
+		}
+		columns = append(columns, "kind")
+	}
+	fields := validation.WorkoutFields{Kind: workout.Kind, Order: body.Order}
+	if body.Reps != nil {
+		workout.Reps = *body.Reps
+		fields.Reps = &workout.Reps
+		columns = append(columns, "reps")
+	}
+	if body.DurationSeconds != nil {
+		workout.DurationSeconds = *body.DurationSeconds
+		fields.DurationSeconds = &workout.DurationSeconds
+		columns = append(columns, "duration_seconds")
+	}
+	if body.Rounds != nil {
+		workout.Rounds = *body.Rounds
+		fields.Rounds = &workout.Rounds
+		columns = append(columns, "rounds")
+	}
+	if body.Kind != nil {
+		// Limits depend on the kind, so everything has to fit the new one
+		fields.Reps, fields.DurationSeconds, fields.Rounds = &workout.Reps, &workout.DurationSeconds, &workout.Rounds
+	}
+	if err := h.validator.Validate(fields); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	err = h.workoutStore.UpdateWorkoutColumns(r.Context(), &workout, columns, body.Order, *body.ExpectedVersion)
+	if errors.Is(err, constants.ErrCodeVersionConflict) {
+		h.writeConflict(w, r, session.UserID, id)
+		return
+	}
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	h.writeWorkout(w, r, http.StatusOK, &workout)
+}
+
+// DELETE /api/v1/workouts/{id}?expected_version=N
+//
+// expected_version is optional. Responds with 204 on success.
+func (h *WorkoutsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	id, err := workoutIDFromPath(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var expectedVersion *int
+	if v := r.URL.Query().Get("expected_version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			respond.Error(w, r, apperror.InvalidInput("expected_version must be an integer"))
+			return
+		}
+		expectedVersion = &version
+	}
+
+	err = h.workoutStore.DeleteWorkout(r.Context(), session.UserID, id, expectedVersion)
+	if errors.Is(err, constants.ErrCodeVersionConflict) {
+		h.writeConflict(w, r, session.UserID, id)
+		return
+	}
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/v1/workouts/{id}/move
+//
+// Body: model.MoveWorkoutRequestJSON
+func (h *WorkoutsHandler) Move(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	id, err := workoutIDFromPath(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var body model.MoveWorkoutRequestJSON
+	if err := decodeJSONBody(w, r, &body); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var afterID *uint64
+	if body.AfterWorkoutID != nil {
+		anchor, err := util.Uint64FromStringID(*body.AfterWorkoutID)
+		if err != nil {
+			respond.Error(w, r, err)
+			return
+		}
+		afterID = &anchor
+	}
+
+	workout, err := h.workoutStore.MoveWorkout(r.Context(), session.UserID, id, afterID, body.ExpectedVersion)
+	if errors.Is(err, constants.ErrCodeVersionConflict) {
+		h.writeConflict(w, r, session.UserID, id)
+		return
+	}
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	h.writeWorkout(w, r, http.StatusOK, &workout)
+}
+
+// GET /api/v1/workouts/order
+//
+// Responds with model.WorkoutListStateResponseJSON
+func (h *WorkoutsHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	resp, err := h.listState(r.Context(), session.UserID)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+	respond.Data(w, http.StatusOK, resp)
+}
+
+// PUT /api/v1/workouts/order
+//
+// Body: model.ReorderWorkoutsRequestJSON, listing every workout of the user.
+// Responds with the new model.WorkoutListStateResponseJSON, or 409 and the
+// current one if expected_list_version is stale.
+func (h *WorkoutsHandler) PutOrder(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	var body model.ReorderWorkoutsRequestJSON
+	if err := decodeJSONBody(w, r, &body); err != nil {
+		respond.Error(w, r, err)
+		return
+	}
+
+	ids := make([]uint64, 0, len(body.WorkoutIDs))
+	for _, workoutID := range body.WorkoutIDs {
+		id, err := util.Uint64FromStringID(workoutID)
+		if err != nil {
+			respond.Error(w, r, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	_, err = h.workoutStore.ReorderWorkouts(r.Context(), session.UserID, ids, body.ExpectedListVersion)
+	if err != nil && !errors.Is(err, constants.ErrCodeVersionConflict) {
+		respond.Error(w, r, err)
+		return
+	}
+
+	resp, stateErr := h.listState(r.Context(), session.UserID)
+	if stateErr != nil {
+		respond.Error(w, r, stateErr)
+		return
+	}
+	if err != nil {
+		conflict := apperror.New(apperror.CodeConflict, "workout list was modified concurrently, it is now at version %d", resp.ListVersion)
+		respond.ErrorWithData(w, r, conflict, resp)
+		return
+	}
+	respond.Data(w, http.StatusOK, resp)
+}
+
+func (h *WorkoutsHandler) listState(ctx context.Context, userID uint64) (model.WorkoutListStateResponseJSON, error) {
+	ids, version, err := h.workoutStore.GetWorkoutListState(ctx, userID)
+	if err != nil {
+		return model.WorkoutListStateResponseJSON{}, err
+	}
+
+	resp := model.WorkoutListStateResponseJSON{
+		WorkoutIDs:  make([]string, len(ids)),
+		ListVersion: version,
+	}
+	for i, id := range ids {
+		resp.WorkoutIDs[i] = strconv.FormatUint(id, 10)
+	}
+	return resp, nil
+}
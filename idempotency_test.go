@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+func TestIdempotentReplayKeepsLocation(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+
+	c.header = http.Header{"Idempotency-Key": {"create-1"}}
+	body := map[string]interface{}{"kind": "pushups", "reps": 10, "duration_seconds": 60}
+	first, firstBody := c.postJSON("/api/v1/workouts", body)
+	if first.StatusCode != http.StatusCreated || first.Header.Get("Location") == "" {
+		t.Fatalf("first request: %d, Location %q, %s", first.StatusCode, first.Header.Get("Location"), firstBody)
+	}
+	replay, replayBody := c.postJSON("/api/v1/workouts", body)
+	if replay.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("second request wasn't replayed: %d %s", replay.StatusCode, replayBody)
+	}
+	if replay.StatusCode != first.StatusCode || replay.Header.Get("Location") != first.Header.Get("Location") || string(replayBody) != string(firstBody) {
+		t.Fatalf("replay: got %d, Location %q, want %d, Location %q", replay.StatusCode, replay.Header.Get("Location"), first.StatusCode, first.Header.Get("Location"))
+	}
+}
+
+// A replay would hand the session cookie to anyone with the key, so the login
+// runs again instead
+func TestIdempotentLoginIsNotReplayed(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	c.header = http.Header{"Idempotency-Key": {"login"}}
+
+	for i := 0; i < 2; i++ {
+		resp, body := c.postJSON("/gql/query", map[string]interface{}{
+			"query": `mutation { login(email: "jane@example.com", password: "sigmamale") { user { id } } }`,
+		})
+		if resp.Header.Get("Idempotent-Replayed") != "" || len(resp.Header.Values("Set-Cookie")) == 0 {
+			t.Fatalf("attempt %d: replayed %q, cookies %v, %s", i+1, resp.Header.Get("Idempotent-Replayed"), resp.Header.Values("Set-Cookie"), body)
+		}
+	}
+
+	var stored int64
+	if err := app.DB.Model(&model.IdempotencyKey{}).Count(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatalf("%d login responses were stored", stored)
+	}
+}
+
+// Without a session every client would share the keys of user 0
+func TestAnonymousIdempotencyKeysAreScopedByClientIP(t *testing.T) {
+	app := newTestApp(t, func(cfg *config.Config) {
+		cfg.RateLimit.TrustedProxies = []string{"127.0.0.1", "::1"}
+	})
+	c := newTestClient(t, app)
+	// A wrong password gets a response that is stored
+	login := func(ip, email string) *http.Response {
+		c.header = http.Header{"Idempotency-Key": {"shared"}, "X-Forwarded-For": {ip}}
+		resp, body := c.postJSON("/gql/query", map[string]interface{}{
+			"query":     `mutation($email: String!) { login(email: $email, password: "wrong") { user_errors { code } } }`,
+			"variables": map[string]string{"email": email},
+		})
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("login from %s: %d %s", ip, resp.StatusCode, body)
+		}
+		return resp
+	}
+
+	login("203.0.113.1", testUserEmail)
+	if resp := login("203.0.113.1", testUserEmail); resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatal("retry from the same client wasn't replayed")
+	}
+	if resp := login("203.0.113.1", "someone@example.com"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key from the same client: got %d, want 422", resp.StatusCode)
+	}
+
+	resp := login("198.51.100.7", "someone@example.com")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("same key from another client: got %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+}
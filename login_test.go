@@ -0,0 +1,201 @@
+package backend
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Every way of logging in has to leave a session cookie that the rest of the
+// API sees, not one scoped to the path of the login endpoint
+func TestLoginEntryPointsSetSiteWideSession(t *testing.T) {
+	magicLinkDir := t.TempDir()
+	app := newTestApp(t, func(cfg *config.Config) {
+		cfg.MagicLink = config.MagicLinkConfig{
+			LinkURL:       "https://frontend.test/login/magic",
+			Sender:        config.MagicLinkSenderFile,
+			FileSenderDir: magicLinkDir,
+		}
+	})
+
+	t.Run("rest", func(t *testing.T) {
+		c := newTestClient(t, app)
+		c.assertLoggedIn(false)
+		resp, body := c.postJSON("/login", map[string]string{"email": testUserEmail, "password": testUserPassword})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /login: %d %s", resp.StatusCode, body)
+		}
+		c.assertLoggedIn(true)
+	})
+
+	t.Run("graphql", func(t *testing.T) {
+		c := newTestClient(t, app)
+		graphqlLogin(t, c, testUserEmail, testUserPassword)
+		c.assertLoggedIn(true)
+	})
+
+	t.Run("magic link", func(t *testing.T) {
+		c := newTestClient(t, app)
+		resp, body := c.postJSON("/login/magic", map[string]string{"email": testUserEmail})
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("POST /login/magic: %d %s", resp.StatusCode, body)
+		}
+		token := readMagicLinkToken(t, magicLinkDir)
+		resp, body = c.postJSON("/login/magic/verify", map[string]string{"token": token})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /login/magic/verify: %d %s", resp.StatusCode, body)
+		}
+		c.assertLoggedIn(true)
+	})
+
+	// Last, it turns on two factor authentication for the user
+	t.Run("second factor", func(t *testing.T) {
+		c := newTestClient(t, app)
+		graphqlLogin(t, c, testUserEmail, testUserPassword)
+		secret, step := enrollTOTP(t, c)
+
+		other := newTestClient(t, app)
+		var login struct {
+			Login struct {
+				SecondFactorChallenge *struct {
+					Challenge string `json:"challenge"`
+				} `json:"second_factor_challenge"`
+			} `json:"login"`
+		}
+		other.graphql(`mutation($email: String!, $password: String!) {
+			login(email: $email, password: $password) { second_factor_challenge { challenge } }
+		}`, map[string]interface{}{"email": testUserEmail, "password": testUserPassword}, &login)
+		if login.Login.SecondFactorChallenge == nil {
+			t.Fatal("no second factor challenge")
+		}
+		other.assertLoggedIn(false)
+
+		resp, body := other.postJSON("/login/second-factor", map[string]string{
+			"challenge": login.Login.SecondFactorChallenge.Challenge,
+			// The confirmation used up the current step
+			"code": totp.Code(secret, step+1),
+		})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /login/second-factor: %d %s", resp.StatusCode, body)
+		}
+		other.assertLoggedIn(true)
+	})
+}
+
+func graphqlLogin(t *testing.T, c *testClient, email, password string) {
+	t.Helper()
+	var login struct {
+		Login struct {
+			User *struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"login"`
+	}
+	c.graphql(`mutation($email: String!, $password: String!) {
+		login(email: $email, password: $password) { user { id } }
+	}`, map[string]interface{}{"email": email, "password": password}, &login)
+	if login.Login.User == nil {
+		t.Fatal("graphql login returned no user")
+	}
+}
+
+// Turns on two factor authentication for the logged in user, returning the
+// secret and the step of the code that confirmed it
+func enrollTOTP(t *testing.T, c *testClient) ([]byte, uint64) {
+	t.Helper()
+	var enrollment struct {
+		EnrollTOTP struct {
+			Secret string `json:"secret"`
+		} `json:"enroll_totp"`
+	}
+	c.graphql(`mutation { enroll_totp { secret } }`, nil, &enrollment)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.EnrollTOTP.Secret)
+	if err != nil {
+		t.Fatalf("malformed secret %q: %v", enrollment.EnrollTOTP.Secret, err)
+	}
+
+	step := totp.Step(time.Now())
+	var confirmation struct {
+		ConfirmTOTP struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"confirm_totp"`
+	}
+	c.graphql(`mutation($code: String!) { confirm_totp(code: $code) { recovery_codes } }`,
+		map[string]interface{}{"code": totp.Code(secret, step)}, &confirmation)
+	if len(confirmation.ConfirmTOTP.RecoveryCodes) == 0 {
+		t.Fatal("totp wasn't confirmed")
+	}
+	return secret, step
+}
+
+// The file sender writes the link in the background
+func readMagicLinkToken(t *testing.T, dir string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+		if len(files) != 0 {
+			message, err := os.ReadFile(files[len(files)-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(string(message), "\n") {
+				if link, err := url.Parse(line); err == nil && link.Query().Get("token") != "" {
+					os.Remove(files[len(files)-1])
+					return link.Query().Get("token")
+				}
+			}
+			t.Fatalf("no link in %s", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("magic link wasn't sent")
+	return ""
+}
+
+// A hash from before the PHC format still logs in, and is replaced by one made
+// with the configured algorithm
+func TestLoginUpgradesLegacyPasswordHash(t *testing.T) {
+	app := newTestApp(t, nil)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("legacypass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.User{UserName: "legacy", Email: "legacy@example.com", PasswordHash: base64.StdEncoding.EncodeToString(bcryptHash)}
+	if err := app.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(t, app)
+	resp, body := c.postJSON("/login", map[string]string{"email": user.Email, "password": "legacypass"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /login: %d %s", resp.StatusCode, body)
+	}
+	if err := app.DB.First(&user, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$argon2id$") {
+		t.Fatalf("password hash wasn't upgraded: %q", user.PasswordHash)
+	}
+
+	// The new hash works and a wrong password still doesn't
+	resp, body = newTestClient(t, app).postJSON("/login", map[string]string{"email": user.Email, "password": "legacypass"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /login with the upgraded hash: %d %s", resp.StatusCode, body)
+	}
+	resp, _ = newTestClient(t, app).postJSON("/login", map[string]string{"email": user.Email, "password": "wrongpass"})
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("logged in with a wrong password")
+	}
+}
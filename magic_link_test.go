@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/auth"
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+)
+
+// A test app sending magic links to files in dir
+func newMagicLinkTestApp(t *testing.T, dir string, bindUserAgent bool) *App {
+	t.Helper()
+	return newTestApp(t, func(cfg *config.Config) {
+		cfg.MagicLink = config.MagicLinkConfig{
+			LinkURL:       "https://frontend.test/login/magic",
+			Sender:        config.MagicLinkSenderFile,
+			FileSenderDir: dir,
+			BindUserAgent: bindUserAgent,
+		}
+	})
+}
+
+func requestMagicLink(t *testing.T, c *testClient, email string) {
+	t.Helper()
+	resp, body := c.postJSON("/login/magic", map[string]string{"email": email})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /login/magic: %d %s", resp.StatusCode, body)
+	}
+}
+
+func verifyMagicLink(t *testing.T, c *testClient, token string) (int, model.LoginResponseJSON) {
+	t.Helper()
+	resp, body := c.postJSON("/login/magic/verify", map[string]string{"token": token})
+	var login struct {
+		Data model.LoginResponseJSON `json:"data"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, &login); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, login.Data
+}
+
+func TestMagicLinkWorksOnce(t *testing.T) {
+	dir := t.TempDir()
+	app := newMagicLinkTestApp(t, dir, false)
+	c := newTestClient(t, app)
+	requestMagicLink(t, c, testUserEmail)
+	token := readMagicLinkToken(t, dir)
+
+	if status, _ := verifyMagicLink(t, c, token); status != http.StatusOK {
+		t.Fatalf("first use: got status %d", status)
+	}
+	c.assertLoggedIn(true)
+	other := newTestClient(t, app)
+	if status, _ := verifyMagicLink(t, other, token); status != http.StatusUnauthorized {
+		t.Fatalf("second use: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	other.assertLoggedIn(false)
+
+	// Two requests that both found the token usable can't both use it up
+	var record model.MagicLinkToken
+	if err := app.DB.First(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := app.DB.Model(&record).Update("used_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	magicLinkStore := store.NewMagicLinkStore(app.DB)
+	for i, want := range []bool{true, false} {
+		used, err := magicLinkStore.MarkTokenUsed(context.Background(), record.ID, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != want {
+			t.Fatalf("MarkTokenUsed call %d returned %v, want %v", i+1, used, want)
+		}
+	}
+}
+
+func TestMagicLinkExpires(t *testing.T) {
+	dir := t.TempDir()
+	app := newMagicLinkTestApp(t, dir, false)
+	now := time.Now()
+	app.Authenticator.Now = func() time.Time { return now }
+	c := newTestClient(t, app)
+	requestMagicLink(t, c, testUserEmail)
+	token := readMagicLinkToken(t, dir)
+
+	now = now.Add(auth.DefaultMagicLinkLifetime)
+	if status, _ := verifyMagicLink(t, c, token); status != http.StatusUnauthorized {
+		t.Fatalf("expired link: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	c.assertLoggedIn(false)
+}
+
+func TestMagicLinkBoundToUserAgent(t *testing.T) {
+	dir := t.TempDir()
+	app := newMagicLinkTestApp(t, dir, true)
+	c := newTestClient(t, app)
+	c.header = http.Header{"User-Agent": {"browser-a"}}
+	requestMagicLink(t, c, testUserEmail)
+	token := readMagicLinkToken(t, dir)
+
+	other := newTestClient(t, app)
+	other.header = http.Header{"User-Agent": {"browser-b"}}
+	if status, _ := verifyMagicLink(t, other, token); status != http.StatusUnauthorized {
+		t.Fatalf("other user agent: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	// Which didn't use the token up for its owner
+	if status, _ := verifyMagicLink(t, c, token); status != http.StatusOK {
+		t.Fatalf("requesting user agent: got status %d", status)
+	}
+	c.assertLoggedIn(true)
+}
+
+func TestMagicLinkAsksForSecondFactor(t *testing.T) {
+	dir := t.TempDir()
+	app := newMagicLinkTestApp(t, dir, false)
+	jane := newTestClient(t, app)
+	graphqlLogin(t, jane, testUserEmail, testUserPassword)
+	enrollTOTP(t, jane)
+
+	c := newTestClient(t, app)
+	requestMagicLink(t, c, testUserEmail)
+	status, login := verifyMagicLink(t, c, readMagicLinkToken(t, dir))
+	if status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if login.SecondFactorChallenge == nil || login.SecondFactorChallenge.Challenge == "" || login.User != nil {
+		t.Fatalf("got %+v, want a second factor challenge", login)
+	}
+	c.assertLoggedIn(false)
+}
+
+// The response doesn't tell whether the email belongs to a user, and nothing
+// is stored or sent for an unknown one
+func TestMagicLinkRequestForUnknownEmail(t *testing.T) {
+	dir := t.TempDir()
+	app := newMagicLinkTestApp(t, dir, false)
+	c := newTestClient(t, app)
+
+	bodies := make(map[string]map[string]interface{})
+	for _, email := range []string{"nobody@example.com", testUserEmail} {
+		resp, body := c.postJSON("/login/magic", map[string]string{"email": email})
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("POST /login/magic for %s: %d %s", email, resp.StatusCode, body)
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		bodies[email] = response
+	}
+	known, unknown := bodies[testUserEmail], bodies["nobody@example.com"]
+	if len(known) != len(unknown) || known["data"] == nil || unknown["data"] == nil {
+		t.Fatalf("responses differ: %v and %v", unknown, known)
+	}
+
+	readMagicLinkToken(t, dir)
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.txt")); len(files) != 0 {
+		t.Fatalf("links were sent for an unknown email: %v", files)
+	}
+	var count int64
+	if err := app.DB.Model(&model.MagicLinkToken{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d tokens were stored, want 1", count)
+	}
+}
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/orderkey"
+	"github.com/nrawrx3/workout-backend/store"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// Migration 011 must keep every list in its relative_order, ties broken by id
+func TestOrderKeyMigrationKeepsTheListOrder(t *testing.T) {
+	cfg := newTestConfig(t, nil)
+	db, err := store.OpenSqliteDatabase(cfg.Sqlite.SqliteDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil || !fts5 {
+		t.Skip("run the tests with -tags sqlite_fts5")
+	}
+
+	migrations := &migrate.FileMigrationSource{Dir: cfg.MigrationsPath}
+	if _, err := migrate.ExecMax(db, "sqlite3", migrations, migrate.Up, 10); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO users (id, user_name, email) VALUES (1, 'a', 'a@example.com'), (2, 'b', 'b@example.com');
+INSERT INTO workouts (id, kind, reps, rounds, duration_seconds, relative_order, user_id, deleted_at) VALUES
+  (1, 'pushups', 1, 0, 1, 5, 1, NULL),
+  (2, 'pushups', 1, 0, 1, 0, 1, NULL),
+  (3, 'pushups', 1, 0, 1, 12, 1, NULL),
+  (4, 'pushups', 1, 0, 1, 3, 1, NULL),
+  (5, 'pushups', 1, 0, 1, 3, 1, NULL),
+  (6, 'pushups', 1, 0, 1, 1, 1, '2024-01-01 00:00:00'),
+  (7, 'pushups', 1, 0, 1, 1, 2, NULL),
+  (8, 'pushups', 1, 0, 1, 0, 2, NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough for ranks of more digits, listed in reverse id order
+	for id := 11; id <= 1000; id++ {
+		_, err := db.Exec(`INSERT INTO workouts (id, kind, reps, rounds, duration_seconds, relative_order, user_id) VALUES (?, 'pushups', 1, 0, 1, ?, 2)`, id, 2000-id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := migrate.Exec(db, "sqlite3", migrations, migrate.Up); err != nil {
+		t.Fatal(err)
+	}
+
+	listOrder := func(userID int) ([]int, []string) {
+		rows, err := db.Query(`SELECT id, order_key FROM workouts WHERE user_id = ? AND deleted_at IS NULL ORDER BY order_key`, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var ids []int
+		var keys []string
+		for rows.Next() {
+			var id int
+			var key string
+			if err := rows.Scan(&id, &key); err != nil {
+				t.Fatal(err)
+			}
+			if err := orderkey.Validate(key); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+			keys = append(keys, key)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return ids, keys
+	}
+
+	if ids, keys := listOrder(1); !reflect.DeepEqual(ids, []int{2, 4, 5, 1, 3}) {
+		t.Fatalf("got order %v with keys %v", ids, keys)
+	}
+	ids, _ := listOrder(2)
+	want := []int{8, 7}
+	for id := 1000; id >= 11; id-- {
+		want = append(want, id)
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got order %v, want %v", ids, want)
+	}
+}
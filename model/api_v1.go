@@ -0,0 +1,87 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// Request and response bodies of the /api/v1 REST endpoints. Responses are
+// wrapped in ResponseFormatJSON.
+
+type CreateWorkoutRequestJSON struct {
+	Kind            string `json:"kind"`
+	Reps            int    `json:"reps"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Rounds          *int   `json:"rounds"`
+	// Position to insert at, appended to the end of the list when not given
+	Order *int `json:"order"`
+}
+
+// Fields that are not given are left unchanged
+type PatchWorkoutRequestJSON struct {
+	ExpectedVersion *int    `json:"expected_version"`
+	Kind            *string `json:"kind"`
+	Reps            *int    `json:"reps"`
+	DurationSeconds *int    `json:"duration_seconds"`
+	Rounds          *int    `json:"rounds"`
+	// Position to move to
+	Order *int `json:"order"`
+}
+
+type MoveWorkoutRequestJSON struct {
+	// Placed first when not given
+	AfterWorkoutID  *string `json:"after_workout_id"`
+	ExpectedVersion *int    `json:"expected_version"`
+}
+
+type ReorderWorkoutsRequestJSON struct {
+	// All of the user's workouts, in the new order
+	WorkoutIDs          []string `json:"workout_ids"`
+	ExpectedListVersion *int     `json:"expected_list_version"`
+}
+
+type WorkoutListStateResponseJSON struct {
+	WorkoutIDs  []string `json:"workout_ids"`
+	ListVersion int      `json:"list_version"`
+}
+
+type WorkoutPageResponseJSON struct {
+	Workouts []WorkoutResponseJSON `json:"workouts"`
+	// Pass as the after query parameter to get the next page
+	EndCursor   string `json:"end_cursor"`
+	HasNextPage bool   `json:"has_next_page"`
+	TotalCount  int64  `json:"total_count"`
+}
+
+type UserResponseJSON struct {
+	ID       string `json:"id"`
+	UserName string `json:"user_name"`
+	Email    string `json:"email"`
+}
+
+func (resp *UserResponseJSON) FromModel(u *User) {
+	resp.ID = strconv.FormatUint(u.ID, 10)
+	resp.UserName = u.UserName
+	resp.Email = u.Email
+}
+
+type SessionResponseJSON struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	// Whether this is the session making the request
+	Current bool `json:"current"`
+}
+
+func (resp *SessionResponseJSON) FromModel(s *UserSession, currentSessionID uint64) {
+	resp.ID = strconv.FormatUint(s.ID, 10)
+	resp.CreatedAt = s.CreatedAt
+	resp.ExpiresAt = s.ExpiresAt
+	resp.UserAgent = s.UserAgent
+	resp.Current = s.ID == currentSessionID
+}
+
+type SessionListResponseJSON struct {
+	Sessions []SessionResponseJSON `json:"sessions"`
+}
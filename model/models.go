@@ -45,6 +45,16 @@ type User struct {
 	UserName     string
 	Email        string
 	PasswordHash string
+	// Incremented whenever the user's workouts are added, removed or
+	// reordered. Used to detect concurrent edits of the list.
+	WorkoutListVersion int
+}
+
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	if u.WorkoutListVersion == 0 {
+		u.WorkoutListVersion = 1
+	}
+	return nil
 }
 
 type UserSession struct {
@@ -62,9 +72,143 @@ type Workout struct {
 	Reps            int
 	Rounds          int
 	DurationSeconds int
-	Order           int `gorm:"column:relative_order"`
+	OrderKey        string // Fractional index key, see package orderkey
 	UserID          uint64
 	User            User
+	// Incremented on every update, for optimistic concurrency control
+	Version int
+	// Set when the workout was created offline by a client with an id of its
+	// own choosing. Unique per user.
+	ClientID *string
+}
+
+func (w *Workout) BeforeCreate(tx *gorm.DB) error {
+	if w.Version == 0 {
+		w.Version = 1
+	}
+	return nil
+}
+
+const EntityTypeWorkout = "workout"
+
+type ChangeOp string
+
+const (
+	ChangeOpUpsert ChangeOp = "upsert"
+	ChangeOpDelete ChangeOp = "delete"
+)
+
+// Object model corresponding to change_log table. The id of an entry is the
+// change token handed out to syncing clients, so it must only ever grow.
+type ChangeLogEntry struct {
+	ID         uint64 `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UserID     uint64
+	EntityType string
+	EntityID   uint64
+	Op         ChangeOp
+}
+
+func (ChangeLogEntry) TableName() string {
+	return "change_log"
+}
+
+// Object model corresponding to sync_applied_ops table. Records which client
+// operations have been applied so that retried pushes are not applied twice.
+type SyncAppliedOp struct {
+	ID        uint64 `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint64
+	OpID      string
+	EntityID  uint64
+}
+
+// Object model corresponding to idempotency_keys table. Holds the response to
+// the first request made with a key, so that retries can be answered with it.
+type IdempotencyKey struct {
+	ID        uint64 `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uint64
+	// Only set without a user
+	ClientIP string
+	Key      string
+	// Hash of the method, path and body of the first request
+	Fingerprint string
+	// Zero until the first request completes
+	StatusCode      int
+	ContentType     string
+	ResponseHeaders http.Header `gorm:"serializer:json"`
+	ResponseBody    []byte
+}
+
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
+
+// Object model corresponding to user_totps table. A user has two factor
+// authentication once ConfirmedAt is set.
+type UserTOTP struct {
+	ID              uint64 `gorm:"primarykey"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	UserID          uint64
+	EncryptedSecret []byte
+	ConfirmedAt     *time.Time
+	LastUsedStep    uint64
+	FailedAttempts  int
+	LockedUntil     *time.Time
+}
+
+func (UserTOTP) TableName() string {
+	return "user_totps"
+}
+
+func (t *UserTOTP) Confirmed() bool {
+	return t.ConfirmedAt != nil
+}
+
+func (t *UserTOTP) Locked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+// Object model corresponding to user_recovery_codes table. Each code can
+// stand in for a TOTP code once.
+type UserRecoveryCode struct {
+	ID        uint64 `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint64
+	CodeHash  string
+	UsedAt    *time.Time
+}
+
+// Object model corresponding to magic_link_tokens table. A token logs the
+// user in once, until it expires.
+type MagicLinkToken struct {
+	ID            uint64 `gorm:"primarykey"`
+	CreatedAt     time.Time
+	UserID        uint64
+	TokenHash     string
+	UserAgentHash string
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+// Object model corresponding to user_identities table. Links an account at
+// an OpenID Connect provider to the user it logs in as.
+type UserIdentity struct {
+	ID        uint64 `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint64
+	Provider  string
+	Subject   string
+	Email     string
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
 }
 
 type UserLoginRequestBody struct {
@@ -77,21 +221,28 @@ type AmILoggedInRequestBody struct {
 }
 
 type WorkoutResponseJSON struct {
-	ID              string `json:"id"`
-	Kind            string `json:"kind"`
-	Reps            int    `json:"reps"`
-	DurationSeconds int    `json:"duration_seconds"`
-	Order           int    `json:"order"`
-	UserID          string `json:"user_id"`
+	ID              string  `json:"id"`
+	Kind            string  `json:"kind"`
+	Reps            int     `json:"reps"`
+	Rounds          int     `json:"rounds"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Order           int     `json:"order"` // Position in the user's list
+	OrderKey        string  `json:"order_key"`
+	UserID          string  `json:"user_id"`
+	Version         int     `json:"version"`
+	ClientID        *string `json:"client_id"`
 }
 
 func (resp *WorkoutResponseJSON) FromModel(w *Workout) {
 	resp.ID = strconv.FormatUint(w.ID, 10)
 	resp.Kind = string(w.Kind)
 	resp.Reps = w.Reps
+	resp.Rounds = w.Rounds
 	resp.DurationSeconds = w.DurationSeconds
-	resp.Order = w.Order
+	resp.OrderKey = w.OrderKey
 	resp.UserID = strconv.FormatUint(w.UserID, 10)
+	resp.Version = w.Version
+	resp.ClientID = w.ClientID
 }
 
 type WorkoutListResponseJSON struct {
@@ -102,6 +253,8 @@ type ResponseFormatJSON struct {
 	Data         interface{} `json:"data"`
 	ErrorCode    string      `json:"error_code"`
 	ErrorMessage string      `json:"error_message"`
+	// Per field details of a validation error, if any
+	ErrorFields interface{} `json:"error_fields,omitempty"`
 }
 
 // Doesn't make sense to send a JSON response in cases of internal server error.
@@ -112,12 +265,6 @@ var DefaultInternalServerErrorResponse = ResponseFormatJSON{
 	ErrorMessage: "unexpected server side error",
 }
 
-var UserNotLoggedInErrorResponse = ResponseFormatJSON{
-	Data:         nil,
-	ErrorCode:    constants.ResponseErrCodeUserNotLoggedIn,
-	ErrorMessage: "user not logged in",
-}
-
 // key type for the request context value containing the user id extracted from
 // cookie
 type UserIDContextKey struct{}
@@ -125,6 +272,52 @@ type UserIDContextKey struct{}
 // key type for the request context value containing the UserSession object
 type UserSessionContextKey struct{}
 
+// key type for the request context value containing the http.ResponseWriter
+// of the request
+type ResponseWriterContextKey struct{}
+
+// Answers a second factor challenge of a login
+type SecondFactorRequestBody struct {
+	Challenge string `json:"challenge"`
+	// TOTP code or one of the recovery codes
+	Code string `json:"code"`
+}
+
+type MagicLinkRequestBody struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkVerifyRequestBody struct {
+	// From the token query parameter of the sent link
+	Token string `json:"token"`
+}
+
+type MagicLinkRequestedResponseJSON struct {
+	// When the link expires, if the email belongs to an account
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type LoginResponseJSON struct {
+	// null if second_factor_challenge is set
+	User *UserResponseJSON `json:"user"`
+	// When the session cookie expires, null if second_factor_challenge is set
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Set instead of logging in users with two factor authentication
+	SecondFactorChallenge *SecondFactorChallengeJSON `json:"second_factor_challenge"`
+}
+
+type SecondFactorChallengeJSON struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// The session's User must be loaded
+func (resp *LoginResponseJSON) FromModel(s *UserSession) {
+	resp.User = &UserResponseJSON{}
+	resp.User.FromModel(&s.User)
+	resp.ExpiresAt = &s.ExpiresAt
+}
+
 type AmILoggedInResponseJSON struct {
 	LoggedIn bool `json:"logged_in"`
 }
@@ -132,11 +325,11 @@ type AmILoggedInResponseJSON struct {
 type SessionCookieInfo struct {
 	CookieName string
 	Secure     bool
-	SecretKey  string
 	Domain     string
 	SameSite   http.SameSite
-	Expires    time.Time
-	HttpOnly   bool
+	// Sessions and their cookies expire this long after login
+	Lifetime time.Duration
+	HttpOnly bool
 }
 
 type SessionCookieValue struct {
@@ -0,0 +1,173 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Tolerated clock difference with the provider
+const clockSkew = 1 * time.Minute
+
+// Claims of a verified ID token
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type idTokenClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          audience        `json:"aud"`
+	AuthorizedParty   string          `json:"azp"`
+	Expiry            int64           `json:"exp"`
+	IssuedAt          int64           `json:"iat"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	EmailVerified     json.RawMessage `json:"email_verified"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+// aud is either a string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the signature and the claims of OpenID Connect Core 1.0 section
+// 3.1.3.7, including that the nonce is the one sent with the authorization
+// request
+func (p *Provider) VerifyIDToken(ctx context.Context, rawToken, nonce string, now time.Time) (Claims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: not a JWS compact serialization", ErrInvalidToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	key, err := p.publicKey(ctx, metadata, header.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifySignature(header.Algorithm, key, parts[0]+"."+parts[1], signature); err != nil {
+		return Claims{}, err
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return Claims{}, fmt.Errorf("%w: issued by %q", ErrInvalidToken, claims.Issuer)
+	case !claims.Audience.contains(p.cfg.ClientID):
+		return Claims{}, fmt.Errorf("%w: not issued to this client", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID:
+		return Claims{}, fmt.Errorf("%w: azp is not this client", ErrInvalidToken)
+	case !now.Before(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+
+	return Claims{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		// Some providers send it as a string
+		EmailVerified:     string(claims.EmailVerified) == "true" || string(claims.EmailVerified) == `"true"`,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Only asymmetric algorithms, a token signed with "none" or an HMAC must not
+// pass
+func verifySignature(algorithm string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RS256 token for a non RSA key", ErrInvalidToken)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return fmt.Errorf("%w: ES256 token for a non P-256 key", ErrInvalidToken)
+		}
+		if len(signature) != 64 {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, algorithm)
+}
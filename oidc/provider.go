@@ -0,0 +1,190 @@
+// Package oidc is the relying party side of OpenID Connect: the authorization
+// code flow with PKCE, and verification of the returned ID token against the
+// provider's published keys. Provider metadata is discovered from the issuer
+// on first use.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDiscovery    = errors.New("oidc discovery failed")
+	ErrExchange     = errors.New("oidc code exchange failed")
+	ErrInvalidToken = errors.New("invalid id token")
+)
+
+// Providers are asked for the openid scope plus these, unless configured
+// otherwise
+var DefaultScopes = []string{"email", "profile"}
+
+const (
+	httpTimeout = 10 * time.Second
+	// Upper bound on the size of provider responses
+	maxResponseBytes = 1 << 20
+	// Keys are fetched again after this, or when a token names an unknown
+	// key
+	keysMaxAge = 1 * time.Hour
+)
+
+type ProviderConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// Where the provider sends the browser back to with the code
+	RedirectURL string
+	Scopes      []string
+}
+
+type Provider struct {
+	cfg        ProviderConfig
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	metadata      *providerMetadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// The parts of the discovery document we use
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewProvider(cfg ProviderConfig) (*Provider, error) {
+	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc provider needs an issuer url, client id and redirect url")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	return &Provider{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	var metadata providerMetadata
+	if err := p.getJSON(ctx, discoveryURL, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// OpenID Connect Discovery 1.0 section 4.3
+	if metadata.Issuer != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("%w: issuer %q of the discovery document doesn't match %q", ErrDiscovery, metadata.Issuer, p.cfg.IssuerURL)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing endpoints", ErrDiscovery)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// The URL to send the browser to. state and nonce must be random and kept by
+// the client, as must the verifier codeChallenge is derived from.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrDiscovery, err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Trades the code from the callback for the raw ID token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: status %d, malformed response: %v", ErrExchange, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("%w: status %d, %s %s", ErrExchange, resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in the response", ErrExchange)
+	}
+	return body.IDToken, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v)
+}
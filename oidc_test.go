@@ -0,0 +1,392 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/oidc"
+)
+
+const (
+	mockOIDCClientID     = "workout-backend"
+	mockOIDCClientSecret = "client-secret"
+	mockOIDCFrontendURL  = "https://frontend.test/after-login"
+	mockOIDCKeyID        = "key-1"
+)
+
+// An identity provider serving discovery, the key set and the token endpoint.
+// The test plays the authorization endpoint: it reads the authorization
+// request off the redirect and issues a code for whatever ID token it likes.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockOIDCCode
+}
+
+type mockOIDCCode struct {
+	codeChallenge string
+	redirectURI   string
+	idToken       string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, codes: map[string]mockOIDCCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": mockOIDCKeyID,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockOIDCProvider) config(name string) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.OIDC = config.OIDCConfig{
+			RedirectBaseURL: "https://backend.test",
+			FrontendURL:     mockOIDCFrontendURL,
+			Providers: []config.OIDCProviderConfig{{
+				Name:         name,
+				IssuerURL:    p.server.URL,
+				ClientID:     mockOIDCClientID,
+				ClientSecret: mockOIDCClientSecret,
+			}},
+		}
+	}
+}
+
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != mockOIDCClientID || clientSecret != mockOIDCClientSecret {
+		tokenError("invalid_client")
+		return
+	}
+
+	p.mu.Lock()
+	issued, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != issued.redirectURI {
+		tokenError("invalid_grant")
+		return
+	}
+	if oidc.CodeChallengeS256(r.PostFormValue("code_verifier")) != issued.codeChallenge {
+		tokenError("invalid_grant")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     issued.idToken,
+	})
+}
+
+// Starts the login in the browser, returning the query of the authorization
+// request it was redirected to
+func (p *mockOIDCProvider) begin(t *testing.T, c *testClient, name string) url.Values {
+	t.Helper()
+	resp, body := c.get("/login/oidc/" + name)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("GET /login/oidc/%s: %d %s", name, resp.StatusCode, body)
+	}
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), p.server.URL+"/authorize?") {
+		t.Fatalf("redirected to %q", resp.Header.Get("Location"))
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+	return query
+}
+
+// The claims a well-behaved provider would put in the ID token for the
+// authorization request
+func (p *mockOIDCProvider) claims(authQuery url.Values, subject string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   p.server.URL,
+		"sub":   subject,
+		"aud":   mockOIDCClientID,
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"iat":   now.Unix(),
+		"nonce": authQuery.Get("nonce"),
+	}
+}
+
+// Panics on failure, since it's called to build the test cases
+func (p *mockOIDCProvider) sign(alg string, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := encode(map[string]string{"alg": alg, "kid": mockOIDCKeyID, "typ": "JWT"}) + "." + encode(claims)
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		digest := sha256.Sum256([]byte(signingInput))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:]); err != nil {
+			panic(err)
+		}
+	case "HS256":
+		// With the public key as the secret, as anyone could
+		mac := hmac.New(sha256.New, p.key.N.Bytes())
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "none":
+	default:
+		panic("unknown alg " + alg)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Has the provider issue a code for the ID token to the authorization request
+func (p *mockOIDCProvider) issueCode(t *testing.T, authQuery url.Values, idToken string) string {
+	t.Helper()
+	code, err := oidc.RandomValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = mockOIDCCode{
+		codeChallenge: authQuery.Get("code_challenge"),
+		redirectURI:   authQuery.Get("redirect_uri"),
+		idToken:       idToken,
+	}
+	return code
+}
+
+// Sends the browser back to the callback, returning the error code the
+// frontend is redirected with, empty if there's none
+func (p *mockOIDCProvider) callback(t *testing.T, c *testClient, name string, query url.Values) string {
+	t.Helper()
+	resp, body := c.get("/login/oidc/" + name + "/callback?" + query.Encode())
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("GET /login/oidc/%s/callback: %d %s", name, resp.StatusCode, body)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, mockOIDCFrontendURL) {
+		t.Fatalf("redirected to %q", location)
+	}
+	frontendURL, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frontendURL.Query().Get("error")
+}
+
+// A whole login, with the ID token made from the claims the provider would
+// send
+func (p *mockOIDCProvider) login(t *testing.T, c *testClient, subject string, idToken func(claims map[string]interface{}) string) string {
+	t.Helper()
+	authQuery := p.begin(t, c, "mock")
+	code := p.issueCode(t, authQuery, idToken(p.claims(authQuery, subject)))
+	return p.callback(t, c, "mock", url.Values{"code": {code}, "state": {authQuery.Get("state")}})
+}
+
+// Signs the claims after modify has changed them
+func (p *mockOIDCProvider) signed(modify func(claims map[string]interface{})) func(claims map[string]interface{}) string {
+	return func(claims map[string]interface{}) string {
+		modify(claims)
+		return p.sign("RS256", claims)
+	}
+}
+
+func TestOIDCLoginRejectsForgedCallbacks(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	app := newTestApp(t, provider.config("mock"))
+	unauthenticated := string(apperror.CodeUnauthenticated)
+
+	t.Run("state mismatch", func(t *testing.T) {
+		c := newTestClient(t, app)
+		authQuery := provider.begin(t, c, "mock")
+		code := provider.issueCode(t, authQuery, provider.sign("RS256", provider.claims(authQuery, "alice")))
+		if got := provider.callback(t, c, "mock", url.Values{"code": {code}, "state": {"forged"}}); got != unauthenticated {
+			t.Fatalf("got error %q, want %q", got, unauthenticated)
+		}
+		c.assertLoggedIn(false)
+	})
+
+	t.Run("no flow cookie", func(t *testing.T) {
+		attacker := newTestClient(t, app)
+		authQuery := provider.begin(t, attacker, "mock")
+		code := provider.issueCode(t, authQuery, provider.sign("RS256", provider.claims(authQuery, "alice")))
+
+		victim := newTestClient(t, app)
+		if got := provider.callback(t, victim, "mock", url.Values{"code": {code}, "state": {authQuery.Get("state")}}); got != unauthenticated {
+			t.Fatalf("got error %q, want %q", got, unauthenticated)
+		}
+		victim.assertLoggedIn(false)
+	})
+
+	cases := []struct {
+		name    string
+		idToken func(claims map[string]interface{}) string
+	}{
+		{"nonce mismatch", provider.signed(func(claims map[string]interface{}) { claims["nonce"] = "replayed" })},
+		{"wrong aud", provider.signed(func(claims map[string]interface{}) { claims["aud"] = "another-client" })},
+		{"wrong azp", provider.signed(func(claims map[string]interface{}) {
+			claims["aud"] = []string{mockOIDCClientID, "another-client"}
+			claims["azp"] = "another-client"
+		})},
+		{"expired", provider.signed(func(claims map[string]interface{}) {
+			claims["iat"] = time.Now().Add(-time.Hour).Unix()
+			claims["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+		})},
+		{"wrong issuer", provider.signed(func(claims map[string]interface{}) { claims["iss"] = "https://evil.test" })},
+		{"alg none", func(claims map[string]interface{}) string { return provider.sign("none", claims) }},
+		{"alg HS256", func(claims map[string]interface{}) string { return provider.sign("HS256", claims) }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, app)
+			if got := provider.login(t, c, "alice", tc.idToken); got != unauthenticated {
+				t.Fatalf("got error %q, want %q", got, unauthenticated)
+			}
+			c.assertLoggedIn(false)
+		})
+	}
+
+	var identities int64
+	if err := app.DB.Model(&model.UserIdentity{}).Count(&identities).Error; err != nil {
+		t.Fatal(err)
+	}
+	if identities != 0 {
+		t.Fatalf("%d identities were linked", identities)
+	}
+}
+
+func TestOIDCLoginLinksAndCreatesUsers(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	app := newTestApp(t, provider.config("mock"))
+
+	var jane model.User
+	if err := app.DB.Where("email = ?", testUserEmail).First(&jane).Error; err != nil {
+		t.Fatal(err)
+	}
+	identityUserID := func(subject string) uint64 {
+		t.Helper()
+		var identity model.UserIdentity
+		if err := app.DB.Where("provider = ? AND subject = ?", "mock", subject).First(&identity).Error; err != nil {
+			t.Fatalf("identity %s: %v", subject, err)
+		}
+		return identity.UserID
+	}
+	withEmail := func(email string, verified bool) func(claims map[string]interface{}) string {
+		return provider.signed(func(claims map[string]interface{}) {
+			claims["email"] = email
+			claims["email_verified"] = verified
+			claims["preferred_username"] = strings.Split(email, "@")[0]
+		})
+	}
+
+	t.Run("unverified email is refused", func(t *testing.T) {
+		c := newTestClient(t, app)
+		if got, want := provider.login(t, c, "mallory", withEmail(testUserEmail, false)), string(apperror.CodeForbidden); got != want {
+			t.Fatalf("got error %q, want %q", got, want)
+		}
+		c.assertLoggedIn(false)
+		var count int64
+		app.DB.Model(&model.UserIdentity{}).Where("subject = ?", "mallory").Count(&count)
+		if count != 0 {
+			t.Fatal("linked an identity with an unverified email")
+		}
+	})
+
+	t.Run("verified email links the existing user", func(t *testing.T) {
+		c := newTestClient(t, app)
+		if got := provider.login(t, c, "jane-at-mock", withEmail(testUserEmail, true)); got != "" {
+			t.Fatalf("got error %q", got)
+		}
+		// Set for the whole site, not just the callback path
+		if cookie := c.cookie("WORKOUT"); cookie == nil {
+			t.Fatal("no session cookie at /")
+		}
+		c.assertLoggedIn(true)
+		if userID := identityUserID("jane-at-mock"); userID != jane.ID {
+			t.Fatalf("linked to user %d, want %d", userID, jane.ID)
+		}
+	})
+
+	t.Run("new email creates a user", func(t *testing.T) {
+		c := newTestClient(t, app)
+		if got := provider.login(t, c, "bob-at-mock", withEmail("bob@example.com", true)); got != "" {
+			t.Fatalf("got error %q", got)
+		}
+		c.assertLoggedIn(true)
+
+		var bob model.User
+		if err := app.DB.Where("email = ?", "bob@example.com").First(&bob).Error; err != nil {
+			t.Fatal(err)
+		}
+		if bob.UserName != "bob" || bob.PasswordHash != "" {
+			t.Fatalf("created user %+v", bob)
+		}
+		if userID := identityUserID("bob-at-mock"); userID != bob.ID {
+			t.Fatalf("linked to user %d, want %d", userID, bob.ID)
+		}
+
+		// The next login finds the identity, even with another email
+		other := newTestClient(t, app)
+		if got := provider.login(t, other, "bob-at-mock", withEmail("bob@elsewhere.test", false)); got != "" {
+			t.Fatalf("second login: got error %q", got)
+		}
+		other.assertLoggedIn(true)
+		var users int64
+		app.DB.Model(&model.User{}).Where("email LIKE ?", "bob@%").Count(&users)
+		if users != 1 {
+			t.Fatalf("got %d users for bob", users)
+		}
+	})
+}
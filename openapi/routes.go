@@ -0,0 +1,61 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var muxVariable = regexp.MustCompile(`\{([^}:]+):[^}]*\}`)
+
+// Converts a mux path template to the OpenAPI syntax by dropping the patterns
+// of path variables, e.g. /workouts/{id:[0-9]+} becomes /workouts/{id}
+func PathFromMuxTemplate(template string) string {
+	return muxVariable.ReplaceAllString(template, "{$1}")
+}
+
+// Returns the routes of the router that the document doesn't describe, as
+// "METHOD /path" strings. Routes without methods need at least one operation.
+// OPTIONS is ignored since it only serves CORS preflight requests. Routes whose
+// path starts with one of the skipped prefixes are not checked.
+func Undocumented(router *mux.Router, doc *Document, skipPrefixes ...string) ([]string, error) {
+	var missing []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			// A subrouter's own route
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		for _, prefix := range skipPrefixes {
+			if strings.HasPrefix(template, prefix) {
+				return nil
+			}
+		}
+
+		path := PathFromMuxTemplate(template)
+		methods, err := route.GetMethods()
+		if err != nil {
+			if item, ok := doc.Paths[path]; !ok || len(*item) == 0 {
+				missing = append(missing, fmt.Sprintf("* %s", path))
+			}
+			return nil
+		}
+
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			if _, ok := doc.Operation(method, path); !ok {
+				missing = append(missing, fmt.Sprintf("%s %s", method, path))
+			}
+		}
+		return nil
+	})
+	return missing, err
+}
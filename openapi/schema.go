@@ -0,0 +1,131 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	emptyInterfaceTyp = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Returns the schema of the JSON encoding of v's type. Named struct types are
+// added to the document's components and referenced.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schemaOfType(reflect.TypeOf(v))
+}
+
+func (d *Document) schemaOfType(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{Nullable: true}
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType, t == emptyInterfaceTyp:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := d.schemaOfType(t.Elem())
+		if s.Ref != "" {
+			// Siblings of $ref are ignored in 3.0
+			return &Schema{AllOf: []*Schema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaOfType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOfType(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name := t.Name()
+		if _, ok := d.Components.Schemas[name]; !ok {
+			// Placeholder first, in case the type refers to itself
+			d.Components.Schemas[name] = &Schema{}
+			*d.Components.Schemas[name] = *d.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// Non pointer fields without omitempty are always present in the encoding, so
+// they are marked required.
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	d.addFields(s, t)
+	return s
+}
+
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			d.addFields(s, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s.Properties[name] = d.schemaOfType(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// Returns the schema of an envelope type whose field named field holds data.
+// E.g. the model.ResponseFormatJSON with its data set to a specific type.
+func (d *Document) Envelope(envelope interface{}, field string, data interface{}) *Schema {
+	return &Schema{
+		AllOf: []*Schema{
+			d.SchemaOf(envelope),
+			{
+				Type:       "object",
+				Properties: map[string]*Schema{field: d.SchemaOf(data)},
+			},
+		},
+	}
+}
+
+func lowerMethod(method string) string {
+	return strings.ToLower(method)
+}
@@ -0,0 +1,29 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/openapi"
+)
+
+// Keeps the document in sync with the router. The routes don't touch the
+// database, so it's left unmigrated.
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	cfg := newTestConfig(t, nil)
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	undocumented, err := openapi.Undocumented(app.Router, app.OpenAPIDoc, constants.GqlRootApiPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undocumented) != 0 {
+		t.Fatalf("routes missing from the openapi document: %v", undocumented)
+	}
+}
@@ -0,0 +1,216 @@
+// Package orderkey generates fractional index keys. Keys are strings that sort
+// bytewise in list order, and a new key can always be generated between any
+// two existing ones. Moving an item within a list then only changes the key of
+// that item instead of renumbering the whole list.
+//
+// A key is read as the fraction 0.k1k2k3... in base 62. Keys never end with
+// the zero digit, since no key could be generated between "A" and "A0".
+package orderkey
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nrawrx3/workout-backend/constants"
+)
+
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const base = len(digits)
+
+// Keys grow by a digit every so often as items are inserted into the same gap.
+// Lists whose keys get longer than this should be rebalanced with Spread.
+const MaxLength = 32
+
+// A key greater than every valid key. Useful as a placeholder while rewriting
+// keys under a unique constraint.
+const Sentinel = "~"
+
+func Validate(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty order key", constants.ErrCodeInvalidValue)
+	}
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return fmt.Errorf("%w: invalid character %q in order key %q", constants.ErrCodeInvalidValue, key[i], key)
+		}
+	}
+	if key[len(key)-1] == digits[0] {
+		return fmt.Errorf("%w: order key %q ends with %q", constants.ErrCodeInvalidValue, key, digits[0])
+	}
+	return nil
+}
+
+// Returns a key that sorts strictly between a and b. An empty a means the start
+// of the list and an empty b the end of it.
+func Between(a, b string) (string, error) {
+	if a != "" {
+		if err := Validate(a); err != nil {
+			return "", err
+		}
+	}
+	if b != "" {
+		if err := Validate(b); err != nil {
+			return "", err
+		}
+	}
+	if a != "" && b != "" && a >= b {
+		return "", fmt.Errorf("%w: order key %q is not less than %q", constants.ErrCodeInvalidValue, a, b)
+	}
+
+	switch {
+	case a != "" && b == "":
+		return after(a), nil
+	case a == "" && b != "":
+		return before(b), nil
+	}
+	return midpoint(a, b), nil
+}
+
+// Returns n keys in increasing order, all strictly between a and b
+func NBetween(a, b string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, n)
+	switch {
+	case b == "":
+		// Appending is the common case, keep those keys short rather than
+		// splitting the space evenly
+		for i := 0; i < n; i++ {
+			key, err := Between(a, "")
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			a = key
+		}
+		return keys, nil
+
+	case a == "":
+		for i := 0; i < n; i++ {
+			key, err := Between("", b)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			b = key
+		}
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		return keys, nil
+	}
+
+	mid, err := Between(a, b)
+	if err != nil {
+		return nil, err
+	}
+	left, err := NBetween(a, mid, n/2)
+	if err != nil {
+		return nil, err
+	}
+	right, err := NBetween(mid, b, n-n/2-1)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, left...)
+	keys = append(keys, mid)
+	return append(keys, right...), nil
+}
+
+// Returns n evenly spaced keys of the same length, as short as possible while
+// leaving room between neighbours
+func Spread(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	width := 1
+	for width < 10 && math.Pow(float64(base), float64(width)) < float64(2*(n+1)) {
+		width++
+	}
+	space := uint64(math.Pow(float64(base), float64(width)))
+	step := space / uint64(n+1)
+
+	keys := make([]string, n)
+	buf := make([]byte, width)
+	for i := range keys {
+		v := uint64(i+1) * step
+		for j := width - 1; j >= 0; j-- {
+			buf[j] = digits[v%uint64(base)]
+			v /= uint64(base)
+		}
+		keys[i] = strings.TrimRight(string(buf), digits[:1])
+	}
+	return keys
+}
+
+func digitValue(key string, i int) int {
+	if i >= len(key) {
+		return 0
+	}
+	return strings.IndexByte(digits, key[i])
+}
+
+// Increments the first digit that can be incremented, so keys only grow once
+// every digit is maxed out
+func after(a string) string {
+	for i := 0; i < len(a); i++ {
+		if d := digitValue(a, i); d < base-1 {
+			return a[:i] + string(digits[d+1])
+		}
+	}
+	return a + string(digits[base/2])
+}
+
+// Mirror of after. The result must not end with a zero, so digits of 1 can't
+// be decremented.
+func before(b string) string {
+	for i := 0; i < len(b); i++ {
+		if d := digitValue(b, i); d > 1 {
+			return b[:i] + string(digits[d-1])
+		}
+	}
+	return midpoint("", b)
+}
+
+// Port of the midpoint function of David Greenspan's fractional indexing. An
+// empty b stands for 1, the end of the key space.
+func midpoint(a, b string) string {
+	if b != "" {
+		// Strip the common prefix, padding a with zeros
+		n := 0
+		for n < len(b) && digitValue(a, n) == digitValue(b, n) {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:])
+		}
+	}
+
+	digitA := digitValue(a, 0)
+	digitB := base
+	if b != "" {
+		digitB = digitValue(b, 0)
+	}
+	if digitB-digitA > 1 {
+		return string(digits[(digitA+digitB+1)/2])
+	}
+
+	// The first digits are consecutive
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(digits[digitA]) + midpoint(rest, "")
+}
@@ -0,0 +1,206 @@
+package orderkey
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/constants"
+)
+
+// Fails unless key is valid and strictly between a and b, an empty bound being
+// open
+func assertBetween(t *testing.T, a, key, b string) {
+	t.Helper()
+	if err := Validate(key); err != nil {
+		t.Fatalf("between %q and %q: %v", a, b, err)
+	}
+	if (a != "" && key <= a) || (b != "" && key >= b) {
+		t.Fatalf("key %q is not between %q and %q", key, a, b)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", "V"},
+		{"", "V", "U"},
+		{"V", "", "W"},
+		{"A", "B", "AV"},
+		{"A", "C", "B"},
+		{"1", "11", "10V"},
+		{"1", "2", "1V"},
+		// The top digit can't be incremented, the key grows instead
+		{"z", "", "zV"},
+		{"zz", "", "zzV"},
+		{"y", "z", "yV"},
+		// Nor can 1 be decremented without leaving a trailing zero
+		{"", "1", "0V"},
+		{"", "01", "00V"},
+		{"0V", "1", "0l"},
+		{"000001V", "000002V", "000002"},
+	}
+	for _, tc := range tests {
+		got, err := Between(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("Between(%q, %q): %v", tc.a, tc.b, err)
+		}
+		assertBetween(t, tc.a, got, tc.b)
+		if got != tc.want {
+			t.Errorf("Between(%q, %q) = %q, want %q", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestBetweenRejectsInvalidBounds(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"B", "A"},
+		{"A", "A"},
+		// No key fits between "A" and "A0", so a trailing zero is invalid
+		{"A", "A0"},
+		{"A0", ""},
+		{"", "-"},
+		{"A~", ""},
+	}
+	for _, tc := range tests {
+		if key, err := Between(tc.a, tc.b); !errors.Is(err, constants.ErrCodeInvalidValue) {
+			t.Errorf("Between(%q, %q) = %q, %v, want an invalid value error", tc.a, tc.b, key, err)
+		}
+	}
+}
+
+func TestNBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		n    int
+	}{
+		{"", "", 1},
+		{"", "", 100},
+		{"V", "", 70},
+		{"", "V", 70},
+		{"A", "B", 100},
+		{"1", "11", 10},
+		{"z", "", 5},
+		{"y", "z", 200},
+	}
+	for _, tc := range tests {
+		keys, err := NBetween(tc.a, tc.b, tc.n)
+		if err != nil {
+			t.Fatalf("NBetween(%q, %q, %d): %v", tc.a, tc.b, tc.n, err)
+		}
+		if len(keys) != tc.n {
+			t.Fatalf("NBetween(%q, %q, %d) returned %d keys", tc.a, tc.b, tc.n, len(keys))
+		}
+		for i, key := range keys {
+			lower := tc.a
+			if i > 0 {
+				lower = keys[i-1]
+			}
+			assertBetween(t, lower, key, tc.b)
+		}
+	}
+
+	if keys, err := NBetween("A", "B", 0); err != nil || keys != nil {
+		t.Fatalf("NBetween with n 0 = %v, %v", keys, err)
+	}
+	if _, err := NBetween("B", "A", 3); !errors.Is(err, constants.ErrCodeInvalidValue) {
+		t.Fatalf("NBetween with reversed bounds: got %v", err)
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{1, 2, 30, 61, 62, 1000, 5000} {
+		keys := Spread(n)
+		if len(keys) != n {
+			t.Fatalf("Spread(%d) returned %d keys", n, len(keys))
+		}
+		for i, key := range keys {
+			lower := ""
+			if i > 0 {
+				lower = keys[i-1]
+			}
+			assertBetween(t, lower, key, "")
+			// Leaves room for an insert between neighbours
+			if _, err := Between(lower, key); err != nil {
+				t.Fatalf("Spread(%d): no room before key %d: %v", n, i, err)
+			}
+		}
+		if n < 30 && len(keys[n-1]) > 1 || len(keys[n-1]) > 3 {
+			t.Errorf("Spread(%d) keys are longer than needed: %q", n, keys[n-1])
+		}
+	}
+	if keys := Spread(0); keys != nil {
+		t.Fatalf("Spread(0) = %v", keys)
+	}
+}
+
+// Inserting into the same gap over and over makes keys grow past MaxLength,
+// at which point the store spreads the list out again
+func TestSpreadRebalancesGrownKeys(t *testing.T) {
+	keys := []string{"A", "B"}
+	for len(keys[1]) <= MaxLength {
+		key, err := Between(keys[0], keys[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append([]string{keys[0], key}, keys[1:]...)
+	}
+
+	spread := Spread(len(keys))
+	for i, key := range spread {
+		if len(key) > MaxLength {
+			t.Fatalf("rebalanced key %q is longer than %d", key, MaxLength)
+		}
+		if i > 0 && key <= spread[i-1] {
+			t.Fatalf("rebalanced keys %q and %q are out of order", spread[i-1], key)
+		}
+	}
+	next, err := Between(spread[0], spread[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBetween(t, spread[0], next, spread[1])
+}
+
+// Migration 011 turned the integer positions into printf('%06dV', rank) keys,
+// which must be valid and sort in rank order next to generated keys
+func TestMigratedKeysSortByRank(t *testing.T) {
+	var keys []string
+	for _, rank := range []int{1, 2, 9, 10, 11, 99, 100, 101, 999, 1000, 12345, 999999} {
+		key := fmt.Sprintf("%06dV", rank)
+		if err := Validate(key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatalf("migrated keys aren't in rank order: %v", keys)
+	}
+
+	for i := 1; i < len(keys); i++ {
+		key, err := Between(keys[i-1], keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBetween(t, keys[i-1], key, keys[i])
+	}
+	first, err := Between("", keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBetween(t, "", first, keys[0])
+	last, err := Between(keys[len(keys)-1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBetween(t, keys[len(keys)-1], last, "")
+	if strings.HasPrefix(last, "0") {
+		t.Fatalf("appended key %q sorts among the migrated ones", last)
+	}
+}
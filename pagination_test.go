@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/orderkey"
+	"github.com/nrawrx3/workout-backend/store"
+)
+
+// Creates a user whose workouts were created at the given times, in list
+// order, and returns them
+func createPaginationWorkouts(t *testing.T, app *App, createdAt []time.Time) []model.Workout {
+	t.Helper()
+	user := createTestUser(t, app, "pager", "pager@example.com", "pagerpass")
+	keys := orderkey.Spread(len(createdAt))
+	workouts := make([]model.Workout, len(createdAt))
+	for i := range createdAt {
+		workouts[i] = model.Workout{
+			BaseModel:       model.BaseModel{CreatedAt: createdAt[i]},
+			Kind:            model.WorkoutPushups,
+			Reps:            i + 1,
+			DurationSeconds: 60,
+			OrderKey:        keys[i],
+			UserID:          user.ID,
+		}
+		if err := app.DB.Create(&workouts[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workouts
+}
+
+// Pages through all of the user's workouts, limit at a time
+func pageThrough(t *testing.T, workoutStore *store.WorkoutStore, userID uint64, params store.WorkoutPageParams) []uint64 {
+	t.Helper()
+	var ids []uint64
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("pagination doesn't end")
+		}
+		page, err := workoutStore.GetWorkoutsPage(context.Background(), userID, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range page.Workouts {
+			ids = append(ids, w.ID)
+		}
+		if !page.HasNextPage {
+			return ids
+		}
+		params.After = page.Cursors[len(page.Cursors)-1]
+	}
+}
+
+func TestWorkoutPagination(t *testing.T) {
+	app := newTestApp(t, nil)
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Reversed against the list order, with ties
+	createdAt := []time.Time{
+		base.Add(5 * time.Hour),
+		base.Add(4 * time.Hour),
+		base.Add(4 * time.Hour),
+		base.Add(4 * time.Hour),
+		base.Add(2 * time.Hour),
+		base,
+		base,
+	}
+	workouts := createPaginationWorkouts(t, app, createdAt)
+	userID := workouts[0].UserID
+	workoutStore := store.NewWorkoutStore(app.DB)
+
+	byOrder := make([]uint64, len(workouts))
+	for i, w := range workouts {
+		byOrder[i] = w.ID
+	}
+	// Ties broken by id
+	byCreatedAt := append([]uint64(nil), byOrder...)
+	sort.SliceStable(byCreatedAt, func(i, j int) bool {
+		a, b := workouts[byCreatedAt[i]-workouts[0].ID], workouts[byCreatedAt[j]-workouts[0].ID]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	reversed := func(ids []uint64) []uint64 {
+		r := make([]uint64, len(ids))
+		for i, id := range ids {
+			r[len(ids)-1-i] = id
+		}
+		return r
+	}
+
+	tests := []struct {
+		name       string
+		field      store.WorkoutSortField
+		descending bool
+		want       []uint64
+	}{
+		{"order ascending", store.WorkoutSortByOrder, false, byOrder},
+		{"order descending", store.WorkoutSortByOrder, true, reversed(byOrder)},
+		{"created_at ascending", store.WorkoutSortByCreatedAt, false, byCreatedAt},
+		{"created_at descending", store.WorkoutSortByCreatedAt, true, reversed(byCreatedAt)},
+	}
+	for _, tc := range tests {
+		// Page sizes that end pages inside and at the edges of the ties
+		for limit := 1; limit <= len(workouts)+1; limit++ {
+			ids := pageThrough(t, workoutStore, userID, store.WorkoutPageParams{SortField: tc.field, Descending: tc.descending, Limit: limit})
+			if !reflect.DeepEqual(ids, tc.want) {
+				t.Fatalf("%s, limit %d: got %v, want %v", tc.name, limit, ids, tc.want)
+			}
+		}
+	}
+
+	// A cursor only continues the order it was issued for
+	page, err := workoutStore.GetWorkoutsPage(context.Background(), userID, store.WorkoutPageParams{SortField: store.WorkoutSortByCreatedAt, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = workoutStore.GetWorkoutsPage(context.Background(), userID, store.WorkoutPageParams{After: page.Cursors[0], Limit: 1})
+	if !errors.Is(err, constants.ErrCodeInvalidValue) {
+		t.Fatalf("got error %v, want %v", err, constants.ErrCodeInvalidValue)
+	}
+}
+
+// Bounds in another time zone mean the same instants
+func TestWorkoutPaginationAcrossTimeZones(t *testing.T) {
+	app := newTestApp(t, nil)
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	workouts := createPaginationWorkouts(t, app, []time.Time{
+		base,
+		base.Add(time.Hour),
+		base.Add(2 * time.Hour),
+		base.Add(3 * time.Hour),
+	})
+	userID := workouts[0].UserID
+	workoutStore := store.NewWorkoutStore(app.DB)
+
+	india := time.FixedZone("IST", 5*60*60+30*60)
+	after := base.Add(time.Hour).In(india)
+	before := base.Add(3 * time.Hour).In(india)
+	ids := pageThrough(t, workoutStore, userID, store.WorkoutPageParams{
+		SortField: store.WorkoutSortByCreatedAt,
+		Filter:    store.WorkoutFilter{Created: store.TimeRange{After: &after, Before: &before}},
+		Limit:     1,
+	})
+	if want := []uint64{workouts[1].ID, workouts[2].ID}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got %v, want %v", ids, want)
+	}
+}
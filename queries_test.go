@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Counts the statements run through the app's gorm handle
+func countQueries(t *testing.T, app *App) *int64 {
+	t.Helper()
+	var count int64
+	increment := func(*gorm.DB) { atomic.AddInt64(&count, 1) }
+	callbacks := app.DB.Callback()
+	for name, err := range map[string]error{
+		"query":  callbacks.Query().After("gorm:query").Register("test:count_queries", increment),
+		"row":    callbacks.Row().After("gorm:row").Register("test:count_queries", increment),
+		"raw":    callbacks.Raw().After("gorm:raw").Register("test:count_queries", increment),
+		"create": callbacks.Create().After("gorm:create").Register("test:count_queries", increment),
+		"update": callbacks.Update().After("gorm:update").Register("test:count_queries", increment),
+		"delete": callbacks.Delete().After("gorm:delete").Register("test:count_queries", increment),
+	} {
+		if err != nil {
+			t.Fatalf("registering %s callback: %v", name, err)
+		}
+	}
+	return &count
+}
+
+// Resolving the user of every workout must be one batched lookup, not one
+// query per workout
+func TestNestedWorkoutUserQueriesDontGrowWithTheList(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	count := countQueries(t, app)
+
+	var jane struct {
+		UserByEmail struct {
+			ID string `json:"id"`
+		} `json:"user_by_email"`
+	}
+	c.graphql(`query($email: String!) { user_by_email(email: $email) { id } }`, map[string]interface{}{"email": testUserEmail}, &jane)
+
+	queries := map[string]string{
+		"workouts":           fmt.Sprintf(`{ workouts(user_id: %q) { id order user { id user_name } } }`, jane.UserByEmail.ID),
+		"workoutsConnection": `{ workoutsConnection(first: 100) { edges { node { id order user { id user_name } } } } }`,
+	}
+	addWorkouts := func(n int) {
+		inputs := make([]map[string]interface{}, n)
+		for i := range inputs {
+			inputs[i] = map[string]interface{}{"kind": "PUSH_UPS", "reps": 10, "duration_seconds": 60}
+		}
+		c.graphql(`mutation($inputs: [CreateWorkoutInput!]!) { create_workouts(inputs: $inputs) { workouts { id } } }`,
+			map[string]interface{}{"inputs": inputs}, nil)
+	}
+	run := func(query string) int64 {
+		before := atomic.LoadInt64(count)
+		c.graphql(query, nil, nil)
+		return atomic.LoadInt64(count) - before
+	}
+
+	addWorkouts(2)
+	few := map[string]int64{}
+	for name, query := range queries {
+		few[name] = run(query)
+	}
+	addWorkouts(30)
+	for name, query := range queries {
+		if many := run(query); many != few[name] {
+			t.Errorf("%s: %d queries with 32 more workouts than with 2 more, want %d", name, many, few[name])
+		}
+	}
+}
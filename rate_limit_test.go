@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/config"
+)
+
+func enableLoginRateLimit(burst int) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.RateLimit.Disabled = false
+		cfg.RateLimit.Groups = map[string]config.RateLimitGroupConfig{
+			config.RateLimitGroupLogin: {Burst: burst, RequestsPerMinute: 1},
+		}
+	}
+}
+
+// Guessing through graphql must not be cheaper than through POST /login
+func TestGraphQLLoginSharesTheLoginRateLimit(t *testing.T) {
+	app := newTestApp(t, enableLoginRateLimit(2))
+	c := newTestClient(t, app)
+
+	resp, body := c.postJSON("/login", map[string]string{"email": testUserEmail, "password": "wrong"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST /login: %d %s", resp.StatusCode, body)
+	}
+	login := `mutation { login(email: "jane@example.com", password: "wrong") { user_errors { code } } }`
+	if codes := c.graphqlErrors(login, nil, nil); len(codes) != 0 {
+		t.Fatalf("second attempt: got errors %v", codes)
+	}
+	if codes, want := c.graphqlErrors(login, nil, nil), []string{string(apperror.CodeRateLimited)}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("third attempt: got errors %v, want %v", codes, want)
+	}
+	resp, body = c.postJSON("/login", map[string]string{"email": testUserEmail, "password": "wrong"})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("POST /login after graphql attempts: %d %s", resp.StatusCode, body)
+	}
+
+	second := `mutation { login_second_factor(challenge: "x", code: "123456") { user_errors { code } } }`
+	if codes, want := c.graphqlErrors(second, nil, nil), []string{string(apperror.CodeRateLimited)}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("login_second_factor: got errors %v, want %v", codes, want)
+	}
+}
+
+func TestGraphQLLoginAliasesTakeATokenEach(t *testing.T) {
+	app := newTestApp(t, enableLoginRateLimit(3))
+	c := newTestClient(t, app)
+
+	var fields []string
+	for i := 0; i < 5; i++ {
+		fields = append(fields, fmt.Sprintf(`a%d: login(email: "jane@example.com", password: "guess%d") { user_errors { code } }`, i, i))
+	}
+	codes := c.graphqlErrors("mutation { "+strings.Join(fields, "\n")+" }", nil, nil)
+	want := []string{string(apperror.CodeRateLimited), string(apperror.CodeRateLimited)}
+	if !reflect.DeepEqual(codes, want) {
+		t.Fatalf("got errors %v, want %v", codes, want)
+	}
+}
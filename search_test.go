@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/store"
+)
+
+const searchQuery = `query($query: String!) {
+	search(query: $query) { kind id snippet workout { id user_id } }
+}`
+
+type searchHits struct {
+	Search []struct {
+		Kind    string `json:"kind"`
+		ID      string `json:"id"`
+		Snippet string `json:"snippet"`
+		Workout *struct {
+			ID     string `json:"id"`
+			UserID string `json:"user_id"`
+		} `json:"workout"`
+	} `json:"search"`
+}
+
+func search(t *testing.T, c *testClient, query string) searchHits {
+	t.Helper()
+	var hits searchHits
+	c.graphql(searchQuery, map[string]interface{}{"query": query}, &hits)
+	return hits
+}
+
+// Users only find their own workouts, even when others have the same kind
+func TestSearchIsScopedToTheUser(t *testing.T) {
+	app := newTestApp(t, nil)
+	jane := firstWorkoutOf(t, app, testUserEmail)
+	mallory := createTestUser(t, app, "mallory", "mallory@example.com", "mallorypass")
+	m := newTestClient(t, app)
+	graphqlLogin(t, m, "mallory@example.com", "mallorypass")
+	for i := 0; i < 2; i++ {
+		m.graphql(`mutation { add_workout(input: {kind: PUSH_UPS, reps: 10, duration_seconds: 60}) { workout { id } } }`, nil, nil)
+	}
+
+	hits := search(t, m, "pushups")
+	if len(hits.Search) != 2 {
+		t.Fatalf("mallory found %d workouts, want her 2", len(hits.Search))
+	}
+	for _, hit := range hits.Search {
+		if hit.Workout == nil || hit.Workout.UserID != strconv.FormatUint(mallory.ID, 10) {
+			t.Fatalf("mallory found %+v", hit)
+		}
+		if !strings.Contains(hit.Snippet, "<mark>pushups</mark>") {
+			t.Fatalf("snippet %q doesn't highlight the match", hit.Snippet)
+		}
+	}
+	// The index itself is scoped, not only the workouts loaded for the hits
+	indexHits, err := store.NewSearchStore(app.DB).Search(context.Background(), mallory.ID, "pushups", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexHits) != 2 {
+		t.Fatalf("the index has %d hits for mallory, want 2", len(indexHits))
+	}
+	// Jane's burpees aren't hers
+	if hits := search(t, m, "burpees"); len(hits.Search) != 0 {
+		t.Fatalf("mallory found %+v", hits.Search)
+	}
+
+	j := newTestClient(t, app)
+	graphqlLogin(t, j, testUserEmail, testUserPassword)
+	// As a prefix, which is how search-as-you-type queries
+	hits = search(t, j, "push")
+	if len(hits.Search) != 1 || hits.Search[0].ID != strconv.FormatUint(jane.ID, 10) || hits.Search[0].Workout == nil {
+		t.Fatalf("jane found %+v, want only her workout %d", hits.Search, jane.ID)
+	}
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/orderkey"
 	"github.com/nrawrx3/workout-backend/util"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -9,7 +10,7 @@ import (
 
 func SeedDatabase(db *gorm.DB) error {
 	const password = "sigmamale"
-	passwordHash, err := util.HashPasswordBase64(password)
+	passwordHash, err := util.HashPassword(password)
 
 	if err != nil {
 		return err
@@ -33,7 +34,6 @@ func SeedDatabase(db *gorm.DB) error {
 			Rounds:          2,
 			DurationSeconds: 20,
 			UserID:          user.ID,
-			Order:           0,
 		},
 		{
 			Kind:            model.WorkoutOneTwos,
@@ -41,7 +41,6 @@ func SeedDatabase(db *gorm.DB) error {
 			Rounds:          1,
 			DurationSeconds: 60,
 			UserID:          user.ID,
-			Order:           1,
 		},
 		{
 			Kind:            model.WorkoutKneesOverToes,
@@ -49,7 +48,6 @@ func SeedDatabase(db *gorm.DB) error {
 			Rounds:          1,
 			DurationSeconds: 20,
 			UserID:          user.ID,
-			Order:           2,
 		},
 		{
 			Kind:            model.WorkoutBurpees,
@@ -57,12 +55,13 @@ func SeedDatabase(db *gorm.DB) error {
 			Rounds:          1,
 			DurationSeconds: 60,
 			UserID:          user.ID,
-			Order:           3,
 		},
 	}
 
+	orderKeys := orderkey.Spread(len(workouts))
 	for i := range workouts {
 		wk := &workouts[i]
+		wk.OrderKey = orderKeys[i]
 		err := db.Create(wk).Error
 		if err != nil {
 			return errors.Wrapf(err, "failed to create workout %+v", wk)
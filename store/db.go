@@ -2,10 +2,11 @@ package store
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/nrawrx3/workout-backend/config"
 	"github.com/pkg/errors"
 	migrate "github.com/rubenv/sql-migrate"
@@ -15,6 +16,15 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// The search_index migration needs FTS5, which the sqlite3 driver only
+// compiles in with the sqlite_fts5 build tag
+var ErrFTS5Unavailable = errors.New("sqlite was built without FTS5, build with -tags sqlite_fts5")
+
+func IsUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
 func OpenSqliteDatabase(sqliteDSN string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", sqliteDSN)
 	if err != nil {
@@ -27,6 +37,9 @@ func OpenSqliteDatabase(sqliteDSN string) (*sql.DB, error) {
 func OpenGorm(sqliteDSN string) (*gorm.DB, error) {
 	gormDB, err := gorm.Open(sqlite.Open(sqliteDSN), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
+		// Timestamps are stored as text, in UTC so that they compare in
+		// time order whatever the server's time zone
+		NowFunc: func() time.Time { return time.Now().UTC() },
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create gorm db object")
@@ -44,6 +57,11 @@ func RunDatabaseMigrations(cfg *config.Config) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to open database at %s", cfg.Sqlite.SqliteDSN())
 	}
+	defer db.Close()
+
+	if err := checkFTS5(db); err != nil {
+		return err
+	}
 
 	n, err := migrate.Exec(db, "sqlite3", migrations, migrate.Up)
 	if err != nil {
@@ -54,6 +72,19 @@ func RunDatabaseMigrations(cfg *config.Config) error {
 	return nil
 }
 
+// Fails early with ErrFTS5Unavailable instead of halfway through the
+// migrations
+func checkFTS5(db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return errors.Wrap(err, "failed to check for FTS5")
+	}
+	if !enabled {
+		return ErrFTS5Unavailable
+	}
+	return nil
+}
+
 func RunDatabaseRollback(cfg *config.Config) error {
 	migrations := &migrate.FileMigrationSource{
 		Dir: cfg.MigrationsPath,
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"gorm.io/gorm"
+)
+
+type IdempotencyStore struct {
+	DB *gorm.DB
+}
+
+func NewIdempotencyStore(db *gorm.DB) *IdempotencyStore {
+	return &IdempotencyStore{DB: db}
+}
+
+// Claims the key for a new request. If the key is already claimed and has not
+// expired, the existing record is returned with claimed set to false and key is
+// left untouched. Returns constants.ErrCodeAlreadyExists if a concurrent
+// request claimed the key first.
+func (s *IdempotencyStore) Claim(ctx context.Context, key *model.IdempotencyKey, now time.Time) (existing model.IdempotencyKey, claimed bool, err error) {
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND client_ip = ? AND key = ?", key.UserID, key.ClientIP, key.Key).First(&existing).Error
+		if err == nil && existing.ExpiresAt.After(now) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to look up idempotency key of user %d: %w", key.UserID, err)
+		}
+
+		if err == nil {
+			if err := tx.Delete(&existing).Error; err != nil {
+				return fmt.Errorf("failed to delete expired idempotency key %d: %w", existing.ID, err)
+			}
+		}
+
+		key.StatusCode = 0
+		if err := tx.Create(key).Error; err != nil {
+			// Claimed by a concurrent request
+			if IsUniqueConstraintError(err) {
+				return constants.ErrCodeAlreadyExists
+			}
+			return fmt.Errorf("failed to create idempotency key of user %d: %w", key.UserID, err)
+		}
+		claimed = true
+		return nil
+	})
+	return existing, claimed, err
+}
+
+// Stores the response of the request that claimed the key
+func (s *IdempotencyStore) Complete(ctx context.Context, id uint64, statusCode int, contentType string, headers http.Header, body []byte) error {
+	err := s.DB.WithContext(ctx).Model(&model.IdempotencyKey{ID: id}).Select("updated_at", "status_code", "content_type", "response_headers", "response_body").Updates(&model.IdempotencyKey{
+		StatusCode:      statusCode,
+		ContentType:     contentType,
+		ResponseHeaders: headers,
+		ResponseBody:    body,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to store response for idempotency key %d: %w", id, err)
+	}
+	return nil
+}
+
+// Frees the key so that the request can be retried, used when the request
+// failed without a response worth replaying.
+func (s *IdempotencyStore) Release(ctx context.Context, id uint64) error {
+	if err := s.DB.WithContext(ctx).Delete(&model.IdempotencyKey{}, id).Error; err != nil {
+		return fmt.Errorf("failed to release idempotency key %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := s.DB.WithContext(ctx).Where("expires_at <= ?", now).Delete(&model.IdempotencyKey{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"gorm.io/gorm"
+)
+
+type IdentityStore struct {
+	DB *gorm.DB
+}
+
+func NewIdentityStore(db *gorm.DB) *IdentityStore {
+	return &IdentityStore{DB: db}
+}
+
+// Returns constants.ErrCodeNotFound if the provider account isn't linked to a
+// user
+func (s *IdentityStore) GetIdentity(ctx context.Context, provider, subject string) (model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := s.DB.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return identity, constants.ErrCodeNotFound
+		}
+		return identity, fmt.Errorf("failed to fetch identity of provider %s: %w", provider, err)
+	}
+	return identity, nil
+}
+
+// Links the provider account to an existing user. Returns
+// constants.ErrCodeAlreadyExists if it's already linked.
+func (s *IdentityStore) CreateIdentity(ctx context.Context, identity *model.UserIdentity) error {
+	if err := s.DB.WithContext(ctx).Create(identity).Error; err != nil {
+		if IsUniqueConstraintError(err) {
+			return constants.ErrCodeAlreadyExists
+		}
+		return fmt.Errorf("failed to create identity of user %d: %w", identity.UserID, err)
+	}
+	return nil
+}
+
+// Creates the user and links the provider account to it. Returns
+// constants.ErrCodeAlreadyExists if the email is taken or the account is
+// already linked.
+func (s *IdentityStore) CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			if IsUniqueConstraintError(err) {
+				return constants.ErrCodeAlreadyExists
+			}
+			return fmt.Errorf("failed to create user with email %s: %w", user.Email, err)
+		}
+		identity.UserID = user.ID
+		if err := tx.Create(identity).Error; err != nil {
+			if IsUniqueConstraintError(err) {
+				return constants.ErrCodeAlreadyExists
+			}
+			return fmt.Errorf("failed to create identity of user %d: %w", user.ID, err)
+		}
+		return nil
+	})
+}
+
+// Keeps the informational email of the identity current
+func (s *IdentityStore) UpdateIdentityEmail(ctx context.Context, identityID uint64, email string) error {
+	err := s.DB.WithContext(ctx).Model(&model.UserIdentity{}).Where("id = ?", identityID).Update("email", email).Error
+	if err != nil {
+		return fmt.Errorf("failed to update email of identity %d: %w", identityID, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"gorm.io/gorm"
+)
+
+type MagicLinkStore struct {
+	DB *gorm.DB
+}
+
+func NewMagicLinkStore(db *gorm.DB) *MagicLinkStore {
+	return &MagicLinkStore{DB: db}
+}
+
+// Stores the token, dropping the unused tokens the user was sent before so
+// that only the latest link works
+func (s *MagicLinkStore) CreateToken(ctx context.Context, token *model.MagicLinkToken) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", token.UserID).Delete(&model.MagicLinkToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete earlier magic link tokens of user %d: %w", token.UserID, err)
+		}
+		if err := tx.Create(token).Error; err != nil {
+			return fmt.Errorf("failed to create magic link token of user %d: %w", token.UserID, err)
+		}
+		return nil
+	})
+}
+
+// Returns constants.ErrCodeNotFound if there's no token with the hash that is
+// unused and unexpired
+func (s *MagicLinkStore) GetUsableToken(ctx context.Context, tokenHash string, now time.Time) (model.MagicLinkToken, error) {
+	var token model.MagicLinkToken
+	err := s.DB.WithContext(ctx).Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return token, constants.ErrCodeNotFound
+		}
+		return token, fmt.Errorf("failed to fetch magic link token: %w", err)
+	}
+	return token, nil
+}
+
+// Returns false if the token was used in the meantime
+func (s *MagicLinkStore) MarkTokenUsed(ctx context.Context, tokenID uint64, now time.Time) (bool, error) {
+	res := s.DB.WithContext(ctx).Model(&model.MagicLinkToken{}).
+		Where("id = ? AND used_at IS NULL", tokenID).
+		Update("used_at", now)
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to mark magic link token %d used: %w", tokenID, res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (s *MagicLinkStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := s.DB.WithContext(ctx).Where("expires_at <= ?", now).Delete(&model.MagicLinkToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired magic link tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"gorm.io/gorm"
+)
+
+// Full-text search is backed by the search_index FTS5 virtual table which is
+// kept in sync with the source tables by triggers (see the migrations). Of a
+// workout only the kind is indexed, it has no other text. The sqlite3 driver
+// only compiles FTS5 in with the sqlite_fts5 build tag, RunDatabaseMigrations
+// checks for it.
+
+type SearchEntityType string
+
+const (
+	SearchEntityWorkout SearchEntityType = "workout"
+)
+
+const (
+	SearchHighlightStart = "<mark>"
+	SearchHighlightEnd   = "</mark>"
+)
+
+type SearchHit struct {
+	EntityType SearchEntityType
+	EntityID   uint64
+	Snippet    string
+	// bm25 score as returned by sqlite. Lower is a better match.
+	Rank float64
+}
+
+type SearchStore struct {
+	DB *gorm.DB
+}
+
+func NewSearchStore(db *gorm.DB) *SearchStore {
+	return &SearchStore{DB: db}
+}
+
+// Turns free form user input into an FTS5 query. Each whitespace separated
+// term is quoted so that FTS5 operators in the input are matched literally, and
+// the last term is treated as a prefix so that search-as-you-type works.
+func buildMatchQuery(input string) string {
+	terms := strings.Fields(input)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	if len(terms) != 0 {
+		terms[len(terms)-1] += "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// Searches the entities owned by the given user. Results are ordered by rank,
+// best match first.
+func (s *SearchStore) Search(ctx context.Context, userID uint64, input string, limit int) ([]SearchHit, error) {
+	match := buildMatchQuery(input)
+	if match == "" {
+		return nil, fmt.Errorf("%w: empty search query", constants.ErrCodeInvalidValue)
+	}
+
+	const query = `select entity_type, entity_id,
+	snippet(search_index, 0, ?, ?, '...', 16) as snippet,
+	bm25(search_index) as rank
+from search_index
+where search_index match ? and user_id = ?
+order by rank
+limit ?`
+
+	var hits []SearchHit
+	err := s.DB.WithContext(ctx).Raw(query, SearchHighlightStart, SearchHighlightEnd, match, userID, limit).Scan(&hits).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for user %d: %w", userID, err)
+	}
+	return hits, nil
+}
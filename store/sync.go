@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nrawrx3/workout-backend/model"
+	"gorm.io/gorm"
+)
+
+// Every store method that creates, updates or deletes a synced entity calls
+// recordChange in the same transaction, so the change log never misses a
+// committed change.
+func recordChange(tx *gorm.DB, userID uint64, entityType string, entityID uint64, op model.ChangeOp) error {
+	entry := model.ChangeLogEntry{
+		UserID:     userID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Op:         op,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record %s of %s %d in change log: %w", op, entityType, entityID, err)
+	}
+	return nil
+}
+
+type SyncStore struct {
+	DB *gorm.DB
+}
+
+func NewSyncStore(db *gorm.DB) *SyncStore {
+	return &SyncStore{DB: db}
+}
+
+type ChangeSet struct {
+	// Current state of the workouts that changed, deleted ones included.
+	// Check DeletedAt to tell tombstones apart.
+	Workouts []model.Workout
+	// Pass this as since in the next call
+	Token   uint64
+	HasMore bool
+}
+
+// Returns the entities of the user that changed after the since token, looking
+// at no more than limit change log entries. Entities are returned in their
+// current state rather than as of the change.
+func (s *SyncStore) ChangesSince(ctx context.Context, userID, since uint64, limit int) (ChangeSet, error) {
+	changes := ChangeSet{Token: since}
+
+	var entries []model.ChangeLogEntry
+	err := s.DB.WithContext(ctx).Where("user_id = ? AND id > ?", userID, since).Order("id").Limit(limit + 1).Find(&entries).Error
+	if err != nil {
+		return changes, fmt.Errorf("failed to read change log of user %d: %w", userID, err)
+	}
+
+	if len(entries) > limit {
+		changes.HasMore = true
+		entries = entries[:limit]
+	}
+	if len(entries) == 0 {
+		return changes, nil
+	}
+	changes.Token = entries[len(entries)-1].ID
+
+	seen := make(map[uint64]bool)
+	var workoutIDs []uint64
+	for _, e := range entries {
+		if e.EntityType == model.EntityTypeWorkout && !seen[e.EntityID] {
+			seen[e.EntityID] = true
+			workoutIDs = append(workoutIDs, e.EntityID)
+		}
+	}
+
+	err = s.DB.WithContext(ctx).Unscoped().Where("user_id = ? AND id IN ?", userID, workoutIDs).Order("order_key, id").Find(&changes.Workouts).Error
+	if err != nil {
+		return changes, fmt.Errorf("failed to fetch changed workouts of user %d: %w", userID, err)
+	}
+	return changes, nil
+}
+
+// Runs apply in a transaction unless an operation with the same opID has
+// already been applied for the user, in which case it returns the entity id
+// recorded for it with duplicate set. If apply fails nothing is recorded, so
+// the client may retry the operation.
+func (s *SyncStore) ApplyOnce(ctx context.Context, userID uint64, opID string, apply func(tx *gorm.DB) (uint64, error)) (entityID uint64, duplicate bool, err error) {
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var applied model.SyncAppliedOp
+		err := tx.Where("user_id = ? AND op_id = ?", userID, opID).First(&applied).Error
+		if err == nil {
+			entityID, duplicate = applied.EntityID, true
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to look up applied op %s of user %d: %w", opID, userID, err)
+		}
+
+		entityID, err = apply(tx)
+		if err != nil {
+			return err
+		}
+
+		applied = model.SyncAppliedOp{UserID: userID, OpID: opID, EntityID: entityID}
+		if err := tx.Create(&applied).Error; err != nil {
+			return fmt.Errorf("failed to record applied op %s of user %d: %w", opID, userID, err)
+		}
+		return nil
+	})
+	return entityID, duplicate, err
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"gorm.io/gorm"
+)
+
+type TwoFactorStore struct {
+	DB *gorm.DB
+}
+
+func NewTwoFactorStore(db *gorm.DB) *TwoFactorStore {
+	return &TwoFactorStore{DB: db}
+}
+
+func (s *TwoFactorStore) GetTOTP(ctx context.Context, userID uint64) (model.UserTOTP, error) {
+	var userTOTP model.UserTOTP
+	err := s.DB.WithContext(ctx).Where("user_id = ?", userID).First(&userTOTP).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return userTOTP, constants.ErrCodeNotFound
+		}
+		return userTOTP, fmt.Errorf("failed to fetch totp of user %d: %w", userID, err)
+	}
+	return userTOTP, nil
+}
+
+// Whether the user has to give a second factor to log in
+func (s *TwoFactorStore) HasConfirmedTOTP(ctx context.Context, userID uint64) (bool, error) {
+	var count int64
+	err := s.DB.WithContext(ctx).Model(&model.UserTOTP{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check totp of user %d: %w", userID, err)
+	}
+	return count != 0, nil
+}
+
+// Stores the secret of a new enrollment, replacing an unconfirmed one. Returns
+// constants.ErrCodeAlreadyExists if the user already has a confirmed one.
+func (s *TwoFactorStore) SaveUnconfirmedTOTP(ctx context.Context, userID uint64, encryptedSecret []byte) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing model.UserTOTP
+		err := tx.Where("user_id = ?", userID).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to fetch totp of user %d: %w", userID, err)
+		}
+		if err == nil {
+			if existing.Confirmed() {
+				return constants.ErrCodeAlreadyExists
+			}
+			if err := tx.Delete(&existing).Error; err != nil {
+				return fmt.Errorf("failed to delete unconfirmed totp of user %d: %w", userID, err)
+			}
+		}
+
+		userTOTP := model.UserTOTP{UserID: userID, EncryptedSecret: encryptedSecret}
+		if err := tx.Create(&userTOTP).Error; err != nil {
+			if IsUniqueConstraintError(err) {
+				return constants.ErrCodeAlreadyExists
+			}
+			return fmt.Errorf("failed to create totp of user %d: %w", userID, err)
+		}
+		return nil
+	})
+}
+
+// Confirms the enrollment, consuming the code of step, and replaces the user's
+// recovery codes. Returns constants.ErrCodeNotFound if there's no unconfirmed
+// enrollment.
+func (s *TwoFactorStore) ConfirmTOTP(ctx context.Context, userID, step uint64, recoveryCodeHashes []string, now time.Time) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.UserTOTP{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_at": now, "last_used_step": step})
+		if res.Error != nil {
+			return fmt.Errorf("failed to confirm totp of user %d: %w", userID, res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return constants.ErrCodeNotFound
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&model.UserRecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes of user %d: %w", userID, err)
+		}
+		codes := make([]model.UserRecoveryCode, 0, len(recoveryCodeHashes))
+		for _, hash := range recoveryCodeHashes {
+			codes = append(codes, model.UserRecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		if err := tx.Create(&codes).Error; err != nil {
+			return fmt.Errorf("failed to create recovery codes of user %d: %w", userID, err)
+		}
+		return nil
+	})
+}
+
+// Records that the code of step was used. Returns false if a code of this or a
+// later step was used already, i.e. the code is being replayed.
+func (s *TwoFactorStore) UseTOTPStep(ctx context.Context, userID, step uint64) (bool, error) {
+	res := s.DB.WithContext(ctx).Model(&model.UserTOTP{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to update totp step of user %d: %w", userID, res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Marks the recovery code used. Returns false if the user has no unused code
+// with the hash.
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID uint64, codeHash string, now time.Time) (bool, error) {
+	res := s.DB.WithContext(ctx).Model(&model.UserRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", now)
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to use recovery code of user %d: %w", userID, res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Counts a wrong second factor code. Once maxFailures are counted the user is
+// locked out until lockedUntil and the count starts over. Returns whether this
+// failure locked the user out.
+func (s *TwoFactorStore) RecordSecondFactorFailure(ctx context.Context, userID uint64, maxFailures int, lockedUntil time.Time) (bool, error) {
+	locked := false
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.UserTOTP{}).Where("user_id = ?", userID).
+			Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error
+		if err != nil {
+			return fmt.Errorf("failed to count second factor failure of user %d: %w", userID, err)
+		}
+		res := tx.Model(&model.UserTOTP{}).
+			Where("user_id = ? AND failed_attempts >= ?", userID, maxFailures).
+			Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": lockedUntil})
+		if res.Error != nil {
+			return fmt.Errorf("failed to lock second factor of user %d: %w", userID, res.Error)
+		}
+		locked = res.RowsAffected == 1
+		return nil
+	})
+	return locked, err
+}
+
+// Starts the count of wrong codes over after a right one
+func (s *TwoFactorStore) ResetSecondFactorFailures(ctx context.Context, userID uint64) error {
+	err := s.DB.WithContext(ctx).Model(&model.UserTOTP{}).
+		Where("user_id = ? AND failed_attempts != 0", userID).
+		Update("failed_attempts", 0).Error
+	if err != nil {
+		return fmt.Errorf("failed to reset second factor failures of user %d: %w", userID, err)
+	}
+	return nil
+}
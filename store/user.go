@@ -33,9 +33,23 @@ func (s *UserStore) GetUserWithEmail(ctx context.Context, email string) (model.U
 	return user, nil
 }
 
+func (s *UserStore) GetUsersByIDs(ctx context.Context, ids []uint64) (map[uint64]model.User, error) {
+	var users []model.User
+	err := s.DB.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users by ids: %w", err)
+	}
+
+	byID := make(map[uint64]model.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	return byID, nil
+}
+
 func (s *UserStore) GetWorkoutsOfUser(ctx context.Context, userId uint64) ([]model.Workout, error) {
 	var workouts []model.Workout
-	err := s.DB.Where("user_id = ?", userId).Find(&workouts).Error
+	err := s.DB.Where("user_id = ?", userId).Order("order_key, id").Find(&workouts).Error
 	if err != nil {
 		return nil, err
 	}
@@ -109,3 +123,48 @@ func (s *UserStore) CreateSession(ctx context.Context, userId uint64, timeNow, e
 	}
 	return session, nil
 }
+
+func (s *UserStore) GetUser(ctx context.Context, id uint64) (model.User, error) {
+	var user model.User
+	err := s.DB.WithContext(ctx).Where("id = ?", id).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, constants.ErrCodeNotFound
+		}
+		return user, fmt.Errorf("failed to fetch user %d: %w", id, err)
+	}
+	return user, nil
+}
+
+func (s *UserStore) UpdatePasswordHash(ctx context.Context, userID uint64, passwordHash string) error {
+	res := s.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Update("password_hash", passwordHash)
+	if res.Error != nil {
+		return fmt.Errorf("failed to update password hash of user %d: %w", userID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return constants.ErrCodeNotFound
+	}
+	return nil
+}
+
+// Returns the sessions of the user that have not expired, newest first
+func (s *UserStore) GetSessionsOfUser(ctx context.Context, userID uint64, timeNow time.Time) ([]model.UserSession, error) {
+	var sessions []model.UserSession
+	err := s.DB.WithContext(ctx).Where("user_id = ? AND expires_at > ?", userID, timeNow).Order("created_at DESC, id DESC").Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions of user %d: %w", userID, err)
+	}
+	return sessions, nil
+}
+
+// Ends the session, after which its cookie is no longer accepted
+func (s *UserStore) DeleteSessionOfUser(ctx context.Context, userID, sessionID uint64) error {
+	res := s.DB.WithContext(ctx).Where("id = ? AND user_id = ?", sessionID, userID).Delete(&model.UserSession{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to delete session %d of user %d: %w", sessionID, userID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return constants.ErrCodeNotFound
+	}
+	return nil
+}
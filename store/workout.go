@@ -0,0 +1,388 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"gorm.io/gorm"
+)
+
+type WorkoutStore struct {
+	DB *gorm.DB
+}
+
+func NewWorkoutStore(db *gorm.DB) *WorkoutStore {
+	return &WorkoutStore{DB: db}
+}
+
+// Returns a store whose methods run inside the given transaction. Their own
+// transactions become savepoints.
+func (s *WorkoutStore) WithTx(tx *gorm.DB) *WorkoutStore {
+	return &WorkoutStore{DB: tx}
+}
+
+type WorkoutSortField string
+
+const (
+	WorkoutSortByOrder     WorkoutSortField = "order_key"
+	WorkoutSortByCreatedAt WorkoutSortField = "created_at"
+)
+
+// Range filter over an integer column. Nil bounds are ignored. Both bounds are
+// inclusive.
+type IntRange struct {
+	Min *int
+	Max *int
+}
+
+// Range filter over a datetime column. Nil bounds are ignored. After is
+// inclusive, Before is exclusive.
+//
+// sqlite compares datetimes as the text they are stored as, offset included,
+// so times are bound in UTC, which is what OpenGorm stores them in.
+type TimeRange struct {
+	After  *time.Time
+	Before *time.Time
+}
+
+type WorkoutFilter struct {
+	Kinds   []model.WorkoutKind
+	Created TimeRange
+	Rounds  IntRange
+	Reps    IntRange
+}
+
+type WorkoutPageParams struct {
+	Filter     WorkoutFilter
+	SortField  WorkoutSortField
+	Descending bool
+	// Opaque cursor as returned by a previous page's edges. Empty means start
+	// from the beginning.
+	After string
+	Limit int
+}
+
+type WorkoutPage struct {
+	Workouts    []model.Workout
+	Cursors     []string
+	HasNextPage bool
+	TotalCount  int64
+}
+
+// The cursor is the sort key of the last seen row plus its id as the tie
+// breaker. It's base64 encoded so clients treat it as opaque.
+type workoutCursor struct {
+	SortField WorkoutSortField `json:"f"`
+	OrderKey  string           `json:"o,omitempty"`
+	CreatedAt time.Time        `json:"c,omitempty"`
+	ID        uint64           `json:"i"`
+}
+
+func encodeWorkoutCursor(field WorkoutSortField, w *model.Workout) string {
+	c := workoutCursor{SortField: field, ID: w.ID}
+	switch field {
+	case WorkoutSortByCreatedAt:
+		c.CreatedAt = w.CreatedAt.UTC()
+	default:
+		c.OrderKey = w.OrderKey
+	}
+	b, _ := json.Marshal(&c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeWorkoutCursor(cursor string, field WorkoutSortField) (workoutCursor, error) {
+	var c workoutCursor
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", constants.ErrCodeInvalidValue)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", constants.ErrCodeInvalidValue)
+	}
+	if c.SortField != field {
+		return c, fmt.Errorf("%w: cursor was issued for a different sort order", constants.ErrCodeInvalidValue)
+	}
+	return c, nil
+}
+
+func (f *WorkoutFilter) apply(q *gorm.DB) *gorm.DB {
+	if len(f.Kinds) != 0 {
+		q = q.Where("kind IN ?", f.Kinds)
+	}
+	if f.Created.After != nil {
+		q = q.Where("created_at >= ?", f.Created.After.UTC())
+	}
+	if f.Created.Before != nil {
+		q = q.Where("created_at < ?", f.Created.Before.UTC())
+	}
+	if f.Rounds.Min != nil {
+		q = q.Where("rounds >= ?", *f.Rounds.Min)
+	}
+	if f.Rounds.Max != nil {
+		q = q.Where("rounds <= ?", *f.Rounds.Max)
+	}
+	if f.Reps.Min != nil {
+		q = q.Where("reps >= ?", *f.Reps.Min)
+	}
+	if f.Reps.Max != nil {
+		q = q.Where("reps <= ?", *f.Reps.Max)
+	}
+	return q
+}
+
+// Returns one page of the user's workouts using keyset pagination over
+// (sort column, id). Fetches Limit+1 rows to find out if there's a next page.
+func (s *WorkoutStore) GetWorkoutsPage(ctx context.Context, userID uint64, params WorkoutPageParams) (WorkoutPage, error) {
+	var page WorkoutPage
+
+	if params.SortField == "" {
+		params.SortField = WorkoutSortByOrder
+	}
+
+	base := s.DB.WithContext(ctx).Model(&model.Workout{}).Where("user_id = ?", userID)
+	base = params.Filter.apply(base)
+
+	if err := base.Session(&gorm.Session{}).Count(&page.TotalCount).Error; err != nil {
+		return page, fmt.Errorf("failed to count workouts of user %d: %w", userID, err)
+	}
+
+	col := string(params.SortField)
+	cmp, dir := ">", "ASC"
+	if params.Descending {
+		cmp, dir = "<", "DESC"
+	}
+
+	q := base.Session(&gorm.Session{})
+	if params.After != "" {
+		c, err := decodeWorkoutCursor(params.After, params.SortField)
+		if err != nil {
+			return page, err
+		}
+		var key interface{} = c.OrderKey
+		if params.SortField == WorkoutSortByCreatedAt {
+			key = c.CreatedAt.UTC()
+		}
+		q = q.Where(fmt.Sprintf("((%[1]s %[2]s ?) OR (%[1]s = ? AND id %[2]s ?))", col, cmp), key, key, c.ID)
+	}
+
+	var workouts []model.Workout
+	err := q.Order(fmt.Sprintf("%s %s, id %s", col, dir, dir)).Limit(params.Limit + 1).Find(&workouts).Error
+	if err != nil {
+		return page, fmt.Errorf("failed to fetch workouts page of user %d: %w", userID, err)
+	}
+
+	if len(workouts) > params.Limit {
+		page.HasNextPage = true
+		workouts = workouts[:params.Limit]
+	}
+
+	page.Workouts = workouts
+	page.Cursors = make([]string, len(workouts))
+	for i := range workouts {
+		page.Cursors[i] = encodeWorkoutCursor(params.SortField, &workouts[i])
+	}
+	return page, nil
+}
+
+// Returns the workouts with given ids keyed by id, irrespective of owner.
+// Callers are responsible for checking the UserID.
+func (s *WorkoutStore) GetWorkoutsByIDs(ctx context.Context, ids []uint64) (map[uint64]model.Workout, error) {
+	var workouts []model.Workout
+	err := s.DB.WithContext(ctx).Where("id IN ?", ids).Find(&workouts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch workouts by ids: %w", err)
+	}
+
+	byID := make(map[uint64]model.Workout, len(workouts))
+	for _, w := range workouts {
+		byID[w.ID] = w
+	}
+	return byID, nil
+}
+
+func (s *WorkoutStore) GetWorkoutOfUser(ctx context.Context, userID, id uint64) (model.Workout, error) {
+	var w model.Workout
+	err := s.DB.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&w).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return w, constants.ErrCodeNotFound
+		}
+		return w, fmt.Errorf("failed to fetch workout %d of user %d: %w", id, userID, err)
+	}
+	return w, nil
+}
+
+// Inserts the workout at the given position of the user's list, or at the end
+// if position is nil or past the end.
+func (s *WorkoutStore) CreateWorkout(ctx context.Context, w *model.Workout, position *int) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Bumping first takes the write lock, so concurrent creates can't
+		// compute their keys from the same neighbours
+		if err := bumpWorkoutListVersion(tx, w.UserID); err != nil {
+			return err
+		}
+
+		key, err := orderKeyAtPosition(tx, w.UserID, 0, position)
+		if err != nil {
+			return err
+		}
+		w.OrderKey = key
+		return insertWorkout(tx, w)
+	})
+}
+
+// Inserts all the workouts of the user in one transaction, placed after the
+// user's last workout in the given order. Their OrderKey fields are
+// overwritten. If any insert fails, none of the workouts are created.
+func (s *WorkoutStore) CreateWorkouts(ctx context.Context, userID uint64, workouts []*model.Workout) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := bumpWorkoutListVersion(tx, userID); err != nil {
+			return err
+		}
+
+		keys, err := orderKeysAtEnd(tx, userID, len(workouts))
+		if err != nil {
+			return err
+		}
+		for i, w := range workouts {
+			w.UserID = userID
+			w.OrderKey = keys[i]
+			if err := insertWorkout(tx, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func insertWorkout(tx *gorm.DB, w *model.Workout) error {
+	if err := tx.Create(w).Error; err != nil {
+		if IsUniqueConstraintError(err) {
+			return fmt.Errorf("%w: workout with the same client id or order key exists", constants.ErrCodeAlreadyExists)
+		}
+		return fmt.Errorf("failed to create workout for user %d: %w", w.UserID, err)
+	}
+	return recordChange(tx, w.UserID, model.EntityTypeWorkout, w.ID, model.ChangeOpUpsert)
+}
+
+// Writes the given columns of w if its version in the database is still
+// expectedVersion, and increments the version. If moveTo is set, the workout
+// is also moved to that position of the list. Returns
+// constants.ErrCodeVersionConflict if someone else updated it first.
+func (s *WorkoutStore) UpdateWorkoutColumns(ctx context.Context, w *model.Workout, columns []string, moveTo *int, expectedVersion int) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if moveTo != nil {
+			if err := bumpWorkoutListVersion(tx, w.UserID); err != nil {
+				return err
+			}
+			key, err := orderKeyAtPosition(tx, w.UserID, w.ID, moveTo)
+			if err != nil {
+				return err
+			}
+			w.OrderKey = key
+			columns = append(columns, "order_key")
+		}
+
+		w.Version = expectedVersion + 1
+		res := tx.Model(w).Where("version = ?", expectedVersion).Select(append(columns, "version")).Updates(w)
+		if res.Error != nil {
+			return fmt.Errorf("failed to update workout %d: %w", w.ID, res.Error)
+		}
+		if res.RowsAffected == 0 {
+			var count int64
+			if err := tx.Model(&model.Workout{}).Where("id = ?", w.ID).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to check existence of workout %d: %w", w.ID, err)
+			}
+			if count == 0 {
+				return constants.ErrCodeNotFound
+			}
+			return constants.ErrCodeVersionConflict
+		}
+
+		return recordChange(tx, w.UserID, model.EntityTypeWorkout, w.ID, model.ChangeOpUpsert)
+	})
+}
+
+// Soft deletes the workout. If expectedVersion is given and the workout has
+// been modified since, returns constants.ErrCodeVersionConflict.
+func (s *WorkoutStore) DeleteWorkout(ctx context.Context, userID, id uint64, expectedVersion *int) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		w, err := s.WithTx(tx).GetWorkoutOfUser(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != nil && *expectedVersion != w.Version {
+			return constants.ErrCodeVersionConflict
+		}
+
+		res := tx.Model(&model.Workout{}).Where("id = ? AND version = ?", id, w.Version).UpdateColumns(map[string]interface{}{
+			"deleted_at": time.Now(),
+			"version":    w.Version + 1,
+		})
+		if res.Error != nil {
+			return fmt.Errorf("failed to delete workout %d: %w", id, res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return constants.ErrCodeVersionConflict
+		}
+
+		if err := bumpWorkoutListVersion(tx, userID); err != nil {
+			return err
+		}
+		return recordChange(tx, userID, model.EntityTypeWorkout, id, model.ChangeOpDelete)
+	})
+}
+
+func (s *WorkoutStore) GetWorkoutOfUserByClientID(ctx context.Context, userID uint64, clientID string) (model.Workout, error) {
+	var w model.Workout
+	err := s.DB.WithContext(ctx).Where("user_id = ? AND client_id = ?", userID, clientID).First(&w).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return w, constants.ErrCodeNotFound
+		}
+		return w, fmt.Errorf("failed to fetch workout with client id %s of user %d: %w", clientID, userID, err)
+	}
+	return w, nil
+}
+
+func bumpWorkoutListVersion(tx *gorm.DB, userID uint64) error {
+	err := tx.Model(&model.User{}).Where("id = ?", userID).
+		UpdateColumn("workout_list_version", gorm.Expr("workout_list_version + 1")).Error
+	if err != nil {
+		return fmt.Errorf("failed to bump workout list version of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *WorkoutStore) GetWorkoutListVersion(ctx context.Context, userID uint64) (int, error) {
+	var user model.User
+	err := s.DB.WithContext(ctx).Select("workout_list_version").Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, constants.ErrCodeNotFound
+		}
+		return 0, fmt.Errorf("failed to get workout list version of user %d: %w", userID, err)
+	}
+	return user.WorkoutListVersion, nil
+}
+
+// Returns the ids of the user's workouts in order, along with the list version
+func (s *WorkoutStore) GetWorkoutListState(ctx context.Context, userID uint64) ([]uint64, int, error) {
+	version, err := s.GetWorkoutListVersion(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ids []uint64
+	err = s.DB.WithContext(ctx).Model(&model.Workout{}).Where("user_id = ?", userID).Order("order_key, id").Pluck("id", &ids).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get workout ids of user %d: %w", userID, err)
+	}
+	return ids, version, nil
+}
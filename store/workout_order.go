@@ -0,0 +1,352 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/orderkey"
+	"gorm.io/gorm"
+)
+
+// Workouts are ordered by fractional index keys (see package orderkey), so
+// placing a workout only writes the key of that workout. All functions here
+// must run in a transaction that has already taken the write lock, e.g. by
+// bumping the list version first, so that concurrent moves always compute keys
+// from the committed neighbours.
+
+// Returns the key for placing a workout right after the workout afterID, or at
+// the start of the list if afterID is nil. The workout being placed, if it
+// already exists, is given as movingID so that its current key is ignored.
+// Rebalances the list if the key would get too long.
+func orderKeyAfter(tx *gorm.DB, userID, movingID uint64, afterID *uint64) (string, error) {
+	for rebalanced := false; ; rebalanced = true {
+		prev := ""
+		if afterID != nil {
+			var anchor model.Workout
+			err := tx.Select("order_key").Where("id = ? AND user_id = ?", *afterID, userID).First(&anchor).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", fmt.Errorf("%w: no workout with id %d to place after", constants.ErrCodeNotFound, *afterID)
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to get order key of workout %d: %w", *afterID, err)
+			}
+			prev = anchor.OrderKey
+		}
+
+		var next []string
+		err := tx.Model(&model.Workout{}).Where("user_id = ? AND id <> ? AND order_key > ?", userID, movingID, prev).
+			Order("order_key").Limit(1).Pluck("order_key", &next).Error
+		if err != nil {
+			return "", fmt.Errorf("failed to get next order key of user %d: %w", userID, err)
+		}
+		nextKey := ""
+		if len(next) != 0 {
+			nextKey = next[0]
+		}
+
+		key, err := orderkey.Between(prev, nextKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate order key for user %d: %w", userID, err)
+		}
+		if len(key) <= orderkey.MaxLength || rebalanced {
+			return key, nil
+		}
+		if err := rebalanceWorkoutOrder(tx, userID, movingID); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Like orderKeyAfter, but places the workout at the given index of the list
+// without it. A nil position or one past the end appends.
+func orderKeyAtPosition(tx *gorm.DB, userID, movingID uint64, position *int) (string, error) {
+	if position != nil && *position <= 0 {
+		return orderKeyAfter(tx, userID, movingID, nil)
+	}
+
+	others := tx.Model(&model.Workout{}).Where("user_id = ? AND id <> ?", userID, movingID)
+
+	var anchor []uint64
+	if position != nil {
+		err := others.Session(&gorm.Session{}).Order("order_key").Offset(*position-1).Limit(1).Pluck("id", &anchor).Error
+		if err != nil {
+			return "", fmt.Errorf("failed to get workout at position %d of user %d: %w", *position-1, userID, err)
+		}
+	}
+	if len(anchor) == 0 {
+		err := others.Session(&gorm.Session{}).Order("order_key DESC").Limit(1).Pluck("id", &anchor).Error
+		if err != nil {
+			return "", fmt.Errorf("failed to get last workout of user %d: %w", userID, err)
+		}
+	}
+	if len(anchor) == 0 {
+		return orderKeyAfter(tx, userID, movingID, nil)
+	}
+	return orderKeyAfter(tx, userID, movingID, &anchor[0])
+}
+
+// Returns n increasing keys after the user's last workout
+func orderKeysAtEnd(tx *gorm.DB, userID uint64, n int) ([]string, error) {
+	for rebalanced := false; ; rebalanced = true {
+		var last []string
+		err := tx.Model(&model.Workout{}).Where("user_id = ?", userID).Order("order_key DESC").Limit(1).Pluck("order_key", &last).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last order key of user %d: %w", userID, err)
+		}
+		lastKey := ""
+		if len(last) != 0 {
+			lastKey = last[0]
+		}
+
+		keys, err := orderkey.NBetween(lastKey, "", n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate order keys for user %d: %w", userID, err)
+		}
+		if n == 0 || len(keys[n-1]) <= orderkey.MaxLength || rebalanced {
+			return keys, nil
+		}
+		if err := rebalanceWorkoutOrder(tx, userID, 0); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Rewrites the order keys of all the user's workouts, except skipID, to evenly
+// spaced short keys. The skipped workout is left with a placeholder key that
+// sorts last, and must be given a new key by the caller.
+func rebalanceWorkoutOrder(tx *gorm.DB, userID, skipID uint64) error {
+	var ids []uint64
+	err := tx.Model(&model.Workout{}).Where("user_id = ? AND id <> ?", userID, skipID).Order("order_key, id").Pluck("id", &ids).Error
+	if err != nil {
+		return fmt.Errorf("failed to get workout ids of user %d: %w", userID, err)
+	}
+	return assignOrderKeys(tx, userID, ids, orderkey.Spread(len(ids)), skipID)
+}
+
+// Sets the order key of each workout in ids to the key at the same index.
+// Also parks the key of alsoPark, if any, so it can't collide with the new keys.
+func assignOrderKeys(tx *gorm.DB, userID uint64, ids []uint64, keys []string, alsoPark uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// The new keys may equal the current keys of other workouts in the list,
+	// so move everything out of the way of the unique index first
+	parked := append([]uint64{alsoPark}, ids...)
+	err := tx.Model(&model.Workout{}).Where("user_id = ? AND id IN ?", userID, parked).
+		UpdateColumn("order_key", gorm.Expr("? || id", orderkey.Sentinel)).Error
+	if err != nil {
+		return fmt.Errorf("failed to park order keys of user %d: %w", userID, err)
+	}
+
+	for i, id := range ids {
+		err := tx.Model(&model.Workout{}).Where("id = ? AND user_id = ?", id, userID).UpdateColumns(map[string]interface{}{
+			"order_key": keys[i],
+			"version":   gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update order key of workout %d: %w", id, err)
+		}
+		if err := recordChange(tx, userID, model.EntityTypeWorkout, id, model.ChangeOpUpsert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Places the workout right after the workout afterID, or first if afterID is
+// nil. Unlike positions, the anchor stays meaningful when other clients
+// reorder the list concurrently. Returns the updated workout.
+func (s *WorkoutStore) MoveWorkout(ctx context.Context, userID, id uint64, afterID *uint64, expectedVersion *int) (model.Workout, error) {
+	var w model.Workout
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if afterID != nil && *afterID == id {
+			return fmt.Errorf("%w: cannot place workout %d after itself", constants.ErrCodeInvalidValue, id)
+		}
+		if err := bumpWorkoutListVersion(tx, userID); err != nil {
+			return err
+		}
+
+		var err error
+		w, err = s.WithTx(tx).GetWorkoutOfUser(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != nil && *expectedVersion != w.Version {
+			return constants.ErrCodeVersionConflict
+		}
+
+		key, err := orderKeyAfter(tx, userID, id, afterID)
+		if err != nil {
+			return err
+		}
+		w.OrderKey = key
+		w.Version++
+		err = tx.Model(&model.Workout{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+			"order_key": w.OrderKey,
+			"version":   w.Version,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to move workout %d: %w", id, err)
+		}
+		return recordChange(tx, userID, model.EntityTypeWorkout, id, model.ChangeOpUpsert)
+	})
+	return w, err
+}
+
+// Puts the workouts in the order of ids, which must list all of the user's
+// workouts exactly once, or constants.ErrCodeInvalidValue is returned. The new
+// keys are generated between the keys of neighbours in ids, and a workout left
+// out could sit in that gap and clash with one of them, so a partial order
+// is refused rather than guessed at. Only the keys of the workouts that
+// actually moved are rewritten, so moving one workout updates one row. If
+// expectedListVersion is given and the list has been modified since, nothing
+// is changed and constants.ErrCodeVersionConflict is returned. Returns the new
+// list version.
+func (s *WorkoutStore) ReorderWorkouts(ctx context.Context, userID uint64, ids []uint64, expectedListVersion *int) (int, error) {
+	var newVersion int
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := bumpWorkoutListVersion(tx, userID); err != nil {
+			return err
+		}
+		var user model.User
+		err := tx.Select("workout_list_version").Where("id = ?", userID).First(&user).Error
+		if err != nil {
+			return fmt.Errorf("failed to get workout list version of user %d: %w", userID, err)
+		}
+		newVersion = user.WorkoutListVersion
+		if expectedListVersion != nil && *expectedListVersion+1 != newVersion {
+			return constants.ErrCodeVersionConflict
+		}
+
+		var workouts []model.Workout
+		err = tx.Select("id, order_key").Where("user_id = ?", userID).Find(&workouts).Error
+		if err != nil {
+			return fmt.Errorf("failed to get order keys of user %d: %w", userID, err)
+		}
+		currentKeys := make(map[uint64]string, len(workouts))
+		for _, w := range workouts {
+			currentKeys[w.ID] = w.OrderKey
+		}
+
+		keys := make([]string, len(ids))
+		seen := make(map[uint64]bool, len(ids))
+		for i, id := range ids {
+			key, ok := currentKeys[id]
+			if !ok {
+				return fmt.Errorf("%w: no workout with id %d", constants.ErrCodeNotFound, id)
+			}
+			if seen[id] {
+				return fmt.Errorf("%w: workout %d is listed more than once", constants.ErrCodeInvalidValue, id)
+			}
+			seen[id] = true
+			keys[i] = key
+		}
+		if len(ids) != len(workouts) {
+			return fmt.Errorf("%w: all %d workouts must be listed, got %d", constants.ErrCodeInvalidValue, len(workouts), len(ids))
+		}
+
+		movedIDs, movedKeys, err := reorderKeys(keys, ids)
+		if err != nil {
+			return fmt.Errorf("failed to generate order keys for user %d: %w", userID, err)
+		}
+		return assignOrderKeys(tx, userID, movedIDs, movedKeys, 0)
+	})
+	return newVersion, err
+}
+
+// Keeps the keys of the longest run of workouts that are already in increasing
+// order and generates keys in between for the rest. Returns the workouts that
+// need new keys along with those keys. Falls back to fresh keys for every
+// workout if the generated ones get too long.
+func reorderKeys(keys []string, ids []uint64) ([]uint64, []string, error) {
+	keep := longestIncreasing(keys)
+
+	var movedIDs []uint64
+	var movedKeys []string
+	prev := ""
+	for i := 0; i < len(keys); {
+		if keep[i] {
+			prev = keys[i]
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(keys) && !keep[j] {
+			j++
+		}
+		next := ""
+		if j < len(keys) {
+			next = keys[j]
+		}
+
+		newKeys, err := orderkey.NBetween(prev, next, j-i)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, key := range newKeys {
+			if len(key) > orderkey.MaxLength {
+				return ids, orderkey.Spread(len(ids)), nil
+			}
+			movedIDs = append(movedIDs, ids[i+k])
+			movedKeys = append(movedKeys, key)
+		}
+		prev = newKeys[len(newKeys)-1]
+		i = j
+	}
+	return movedIDs, movedKeys, nil
+}
+
+// Marks the elements of a longest strictly increasing subsequence of keys
+func longestIncreasing(keys []string) []bool {
+	// tails[k] is the index of the smallest key ending an increasing
+	// subsequence of length k+1
+	var tails []int
+	prevIndex := make([]int, len(keys))
+	for i, key := range keys {
+		k := sort.Search(len(tails), func(k int) bool { return keys[tails[k]] >= key })
+		prevIndex[i] = -1
+		if k > 0 {
+			prevIndex[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	keep := make([]bool, len(keys))
+	if len(tails) == 0 {
+		return keep
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = prevIndex[i] {
+		keep[i] = true
+	}
+	return keep
+}
+
+// Returns the 0-based position of each of the users' workouts, keyed by user
+// id and then workout id. Every user id gets an entry, even without workouts.
+func (s *WorkoutStore) GetWorkoutPositions(ctx context.Context, userIDs []uint64) (map[uint64]map[uint64]int, error) {
+	var workouts []model.Workout
+	err := s.DB.WithContext(ctx).Select("id, user_id").Where("user_id IN ?", userIDs).Order("user_id, order_key, id").Find(&workouts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workout positions: %w", err)
+	}
+
+	positions := make(map[uint64]map[uint64]int, len(userIDs))
+	for _, userID := range userIDs {
+		positions[userID] = make(map[uint64]int)
+	}
+	for _, w := range workouts {
+		userPositions := positions[w.UserID]
+		userPositions[w.ID] = len(userPositions)
+	}
+	return positions, nil
+}
@@ -0,0 +1,90 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 with
+// the parameters authenticator apps assume: HMAC-SHA1, 6 digits and a 30
+// second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// RFC 4226 recommends at least 160 bits
+	SecretBytes = 20
+	// Codes of this many periods before or after the current one are
+	// accepted too, to allow for clock drift and typing time
+	Skew = 1
+)
+
+// Authenticator apps expect the secret in unpadded base32
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+func EncodeSecret(secret []byte) string {
+	return secretEncoding.EncodeToString(secret)
+}
+
+// Returns the time step t falls in
+func Step(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(Period/time.Second)
+}
+
+// The code of the given time step
+func Code(secret []byte, step uint64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
+
+// Checks code against the steps within Skew of now. Returns the matching step,
+// which the caller should remember so the code can't be used again.
+func Validate(secret []byte, code string, now time.Time) (step uint64, ok bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(now)
+	for delta := -Skew; delta <= Skew; delta++ {
+		candidate := current + uint64(delta)
+		if subtle.ConstantTimeCompare([]byte(Code(secret, candidate)), []byte(code)) == 1 {
+			return candidate, true
+		}
+	}
+	return 0, false
+}
+
+// The otpauth:// URI authenticator apps import, usually through a QR code
+func URI(issuer, accountName string, secret []byte) string {
+	label := url.PathEscape(issuer + ":" + accountName)
+	query := url.Values{
+		"secret":    {EncodeSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period / time.Second))},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,260 @@
+package backend
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/auth"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/totp"
+)
+
+// Drives the authenticator of a test app on a clock of its own
+type twoFactorTest struct {
+	t   *testing.T
+	app *App
+	now time.Time
+	// The seeded user
+	user model.User
+}
+
+func newTwoFactorTest(t *testing.T) *twoFactorTest {
+	t.Helper()
+	app := newTestApp(t, nil)
+	tt := &twoFactorTest{t: t, app: app, now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	app.Authenticator.Now = func() time.Time { return tt.now }
+	if err := app.DB.Where("email = ?", testUserEmail).First(&tt.user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+func (tt *twoFactorTest) enroll() []byte {
+	tt.t.Helper()
+	enrollment, err := tt.app.Authenticator.EnrollTOTP(context.Background(), tt.user)
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		tt.t.Fatalf("malformed secret %q: %v", enrollment.Secret, err)
+	}
+	return secret
+}
+
+// Enrolls and confirms with the code of the current step, returning the secret
+// and the recovery codes
+func (tt *twoFactorTest) enable() ([]byte, []string) {
+	tt.t.Helper()
+	secret := tt.enroll()
+	recoveryCodes, err := tt.app.Authenticator.ConfirmTOTP(context.Background(), tt.user.ID, totp.Code(secret, totp.Step(tt.now)))
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	return secret, recoveryCodes
+}
+
+// Logs in with the password, returning the second factor challenge
+func (tt *twoFactorTest) challenge() string {
+	tt.t.Helper()
+	result, err := tt.app.Authenticator.Login(context.Background(), httptest.NewRecorder(),
+		model.UserLoginRequestBody{Email: testUserEmail, Password: testUserPassword}, "test")
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	if result.Challenge == nil {
+		tt.t.Fatal("login didn't ask for the second factor")
+	}
+	return result.Challenge.Token
+}
+
+func (tt *twoFactorTest) answer(challenge, code string) error {
+	_, err := tt.app.Authenticator.CompleteSecondFactor(context.Background(), httptest.NewRecorder(), challenge, code, "test")
+	return err
+}
+
+func assertErrorCode(t *testing.T, err error, want apperror.Code) {
+	t.Helper()
+	var appErr *apperror.Error
+	if !errors.As(err, &appErr) || appErr.Code != want {
+		t.Fatalf("got error %v, want code %s", err, want)
+	}
+}
+
+func TestTOTPEnrollAndConfirm(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	ctx := context.Background()
+
+	secret := tt.enroll()
+	// Not asked for before confirming
+	result, err := tt.app.Authenticator.Login(ctx, httptest.NewRecorder(),
+		model.UserLoginRequestBody{Email: testUserEmail, Password: testUserPassword}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Challenge != nil {
+		t.Fatal("unconfirmed enrollment asked for a second factor")
+	}
+
+	_, err = tt.app.Authenticator.ConfirmTOTP(ctx, tt.user.ID, totp.Code(secret, totp.Step(tt.now)+5))
+	assertErrorCode(t, err, apperror.CodeWrongSecondFactor)
+
+	// Enrolling again replaces the secret
+	secret = tt.enroll()
+	recoveryCodes, err := tt.app.Authenticator.ConfirmTOTP(ctx, tt.user.ID, totp.Code(secret, totp.Step(tt.now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("got %d recovery codes, want 10", len(recoveryCodes))
+	}
+
+	_, err = tt.app.Authenticator.EnrollTOTP(ctx, tt.user)
+	assertErrorCode(t, err, apperror.CodeConflict)
+	_, err = tt.app.Authenticator.ConfirmTOTP(ctx, tt.user.ID, totp.Code(secret, totp.Step(tt.now)))
+	assertErrorCode(t, err, apperror.CodeConflict)
+
+	tt.challenge()
+}
+
+func TestSecondFactorSkewWindow(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	secret, _ := tt.enable()
+	tt.now = tt.now.Add(10 * totp.Period)
+	step := totp.Step(tt.now)
+
+	if err := tt.answer(tt.challenge(), totp.Code(secret, step+totp.Skew+1)); err == nil {
+		t.Fatal("accepted a code from beyond the skew window")
+	}
+	if err := tt.answer(tt.challenge(), totp.Code(secret, step-totp.Skew-1)); err == nil {
+		t.Fatal("accepted a code from before the skew window")
+	}
+	if err := tt.answer(tt.challenge(), totp.Code(secret, step-totp.Skew)); err != nil {
+		t.Fatalf("previous step: %v", err)
+	}
+	if err := tt.answer(tt.challenge(), totp.Code(secret, step+totp.Skew)); err != nil {
+		t.Fatalf("next step: %v", err)
+	}
+}
+
+func TestSecondFactorRejectsReplayedSteps(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	secret, _ := tt.enable()
+
+	// Confirming used up the current step
+	step := totp.Step(tt.now)
+	assertErrorCode(t, tt.answer(tt.challenge(), totp.Code(secret, step)), apperror.CodeWrongSecondFactor)
+
+	if err := tt.answer(tt.challenge(), totp.Code(secret, step+1)); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, tt.answer(tt.challenge(), totp.Code(secret, step+1)), apperror.CodeWrongSecondFactor)
+	// Nor an earlier step that's still in the window
+	tt.now = tt.now.Add(totp.Period)
+	assertErrorCode(t, tt.answer(tt.challenge(), totp.Code(secret, step)), apperror.CodeWrongSecondFactor)
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	_, recoveryCodes := tt.enable()
+
+	if err := tt.answer(tt.challenge(), recoveryCodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, tt.answer(tt.challenge(), recoveryCodes[0]), apperror.CodeWrongSecondFactor)
+	if err := tt.answer(tt.challenge(), recoveryCodes[1]); err != nil {
+		t.Fatalf("second recovery code: %v", err)
+	}
+}
+
+func TestSecondFactorChallengeExpires(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	secret, _ := tt.enable()
+	challenge := tt.challenge()
+
+	tt.now = tt.now.Add(auth.SecondFactorChallengeLifetime)
+	assertErrorCode(t, tt.answer(challenge, totp.Code(secret, totp.Step(tt.now))), apperror.CodeUnauthenticated)
+}
+
+func TestSecondFactorLocksOutAfterTooManyWrongCodes(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	secret, recoveryCodes := tt.enable()
+
+	// Across challenges, since a new one is only a password away
+	for i := 0; i < auth.MaxSecondFactorFailures; i++ {
+		assertErrorCode(t, tt.answer(tt.challenge(), "000000-wrong"), apperror.CodeWrongSecondFactor)
+	}
+	tt.now = tt.now.Add(totp.Period)
+	assertErrorCode(t, tt.answer(tt.challenge(), totp.Code(secret, totp.Step(tt.now))), apperror.CodeRateLimited)
+	assertErrorCode(t, tt.answer(tt.challenge(), recoveryCodes[0]), apperror.CodeRateLimited)
+
+	tt.now = tt.now.Add(auth.SecondFactorLockout)
+	if err := tt.answer(tt.challenge(), totp.Code(secret, totp.Step(tt.now))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A right code starts the count over
+func TestSecondFactorFailuresResetOnSuccess(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	secret, _ := tt.enable()
+
+	for round := 1; round <= 2; round++ {
+		for i := 0; i < auth.MaxSecondFactorFailures-1; i++ {
+			assertErrorCode(t, tt.answer(tt.challenge(), "wrong"), apperror.CodeWrongSecondFactor)
+		}
+		tt.now = tt.now.Add(totp.Period)
+		if err := tt.answer(tt.challenge(), totp.Code(secret, totp.Step(tt.now))); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+	}
+}
+
+// The secret and the recovery codes must not end up in idempotency_keys
+func TestTOTPResponsesAreNotStoredForReplay(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+
+	c.header = http.Header{"Idempotency-Key": {"enroll"}}
+	var first, second struct {
+		EnrollTOTP struct {
+			Secret string `json:"secret"`
+		} `json:"enroll_totp"`
+	}
+	c.graphql(`mutation { enroll_totp { secret } }`, nil, &first)
+	c.graphql(`mutation { enroll_totp { secret } }`, nil, &second)
+	if first.EnrollTOTP.Secret == "" || first.EnrollTOTP.Secret == second.EnrollTOTP.Secret {
+		t.Fatalf("retry was replayed: secrets %q and %q", first.EnrollTOTP.Secret, second.EnrollTOTP.Secret)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(second.EnrollTOTP.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.header = http.Header{"Idempotency-Key": {"confirm"}}
+	var confirmation struct {
+		ConfirmTOTP struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"confirm_totp"`
+	}
+	c.graphql(`mutation($code: String!) { confirm_totp(code: $code) { recovery_codes } }`,
+		map[string]interface{}{"code": totp.Code(secret, totp.Step(time.Now()))}, &confirmation)
+	if len(confirmation.ConfirmTOTP.RecoveryCodes) == 0 {
+		t.Fatal("totp wasn't confirmed")
+	}
+
+	var stored int64
+	if err := app.DB.Model(&model.IdempotencyKey{}).Where("response_body IS NOT NULL").Count(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatalf("%d responses were stored", stored)
+	}
+}
@@ -50,9 +50,19 @@ func NewAESCipher(hexKey string) (*AESCipher, error) {
 	}, nil
 }
 
-var ErrInvalidAESKeyLength = errors.New("invalid AES key length")
+var (
+	ErrInvalidAESKeyLength = errors.New("invalid AES key length")
+	ErrCiphertextTooShort  = errors.New("ciphertext too short")
+)
 
 func (a AESCipher) Encrypt(plaintextBytes []byte) ([]byte, error) {
+	return a.EncryptWithAD(plaintextBytes, nil)
+}
+
+// Like Encrypt, additionally authenticating associatedData. The ciphertext
+// only decrypts with the same associated data, which binds it to a context,
+// e.g. the name of the cookie it's stored in.
+func (a AESCipher) EncryptWithAD(plaintextBytes, associatedData []byte) ([]byte, error) {
 	nonceSize := a.gcm.NonceSize()
 
 	nonce := make([]byte, nonceSize)
@@ -79,20 +89,27 @@ func (a AESCipher) Encrypt(plaintextBytes []byte) ([]byte, error) {
 
 	dst := make([]byte, 0, nonceSize+encryptedLen)
 
-	encryptedBytes := a.gcm.Seal(dst[:0], nonce, plaintextBytes, nil)
+	encryptedBytes := a.gcm.Seal(dst[:0], nonce, plaintextBytes, associatedData)
 
 	withNonce := append(encryptedBytes, nonce...)
 	return withNonce, nil
 }
 
 func (a *AESCipher) Decrypt(encryptedWithNonceBytes []byte) ([]byte, error) {
+	return a.DecryptWithAD(encryptedWithNonceBytes, nil)
+}
+
+func (a *AESCipher) DecryptWithAD(encryptedWithNonceBytes, associatedData []byte) ([]byte, error) {
 	nonceSize := a.gcm.NonceSize()
+	if len(encryptedWithNonceBytes) < nonceSize+a.gcm.Overhead() {
+		return nil, ErrCiphertextTooShort
+	}
 
 	encryptedBytesLen := len(encryptedWithNonceBytes) - nonceSize
 
 	cipherText := encryptedWithNonceBytes[0:encryptedBytesLen]
 	nonce := encryptedWithNonceBytes[encryptedBytesLen : encryptedBytesLen+nonceSize]
-	return a.gcm.Open(nil, nonce, cipherText, nil)
+	return a.gcm.Open(nil, nonce, cipherText, associatedData)
 }
 
 func (aesCipher *AESCipher) MustEncryptJSON(value interface{}) io.Reader {
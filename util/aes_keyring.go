@@ -0,0 +1,147 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AESKeyring holds the keys values are encrypted with, so that the key can be
+// rotated without making every existing value undecryptable at once. Values
+// are encrypted with the primary key and stored along with its ID. To rotate,
+// add the new key on every instance, then make it the primary, and drop the
+// old key once values encrypted with it have expired or been re-encrypted.
+type AESKeyring struct {
+	primaryID string
+	ciphers   map[string]*AESCipher
+	// Decrypts values stored before the keyring, which carry no key ID
+	legacy *AESCipher
+	// See AcceptUnversionedCookiesUntil
+	unversionedCookiesUntil time.Time
+}
+
+// Separates the key ID from the encoded ciphertext. Neither the hex nor the
+// base64 url alphabet contains it.
+const keyIDSeparator = "."
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Reports whether id can name a key of the keyring
+func IsValidKeyID(id string) bool {
+	return keyIDPattern.MatchString(id)
+}
+
+var (
+	ErrUnknownKeyID  = errors.New("value is encrypted with a key that is not in the keyring")
+	ErrNoLegacyKey   = errors.New("value has no key ID and no legacy key is configured")
+	ErrInvalidKeyIDs = errors.New("invalid keyring")
+)
+
+// hexKeys maps key IDs to 64 character hex keys. legacyHexKey decrypts values
+// without a key ID and may be empty.
+//
+// With an empty primaryID, legacyHexKey is the only key and values are stored
+// without an ID, as they were before keyrings.
+func NewAESKeyring(primaryID string, hexKeys map[string]string, legacyHexKey string) (*AESKeyring, error) {
+	k := &AESKeyring{primaryID: primaryID, ciphers: make(map[string]*AESCipher, len(hexKeys))}
+
+	for id, hexKey := range hexKeys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%w: key ID %q must be 1 to 32 letters, digits, '-' or '_'", ErrInvalidKeyIDs, id)
+		}
+		aesCipher, err := NewAESCipher(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.ciphers[id] = aesCipher
+	}
+
+	if legacyHexKey != "" {
+		legacy, err := NewAESCipher(legacyHexKey)
+		if err != nil {
+			return nil, fmt.Errorf("legacy key: %w", err)
+		}
+		k.legacy = legacy
+	}
+
+	if primaryID == "" {
+		if len(hexKeys) != 0 {
+			return nil, fmt.Errorf("%w: no primary key ID", ErrInvalidKeyIDs)
+		}
+		if k.legacy == nil {
+			return nil, fmt.Errorf("%w: no keys", ErrInvalidKeyIDs)
+		}
+	} else if _, ok := k.ciphers[primaryID]; !ok {
+		return nil, fmt.Errorf("%w: primary key %q is not in the keyring", ErrInvalidKeyIDs, primaryID)
+	}
+	return k, nil
+}
+
+func (k *AESKeyring) PrimaryKeyID() string {
+	return k.primaryID
+}
+
+func (k *AESKeyring) primary() *AESCipher {
+	if k.primaryID == "" {
+		return k.legacy
+	}
+	return k.ciphers[k.primaryID]
+}
+
+// Encrypts with the primary key, returning its ID along with the ciphertext
+func (k *AESKeyring) Encrypt(plaintext []byte) (string, []byte, error) {
+	return k.EncryptWithAD(plaintext, nil)
+}
+
+// See AESCipher.EncryptWithAD
+func (k *AESKeyring) EncryptWithAD(plaintext, associatedData []byte) (string, []byte, error) {
+	encrypted, err := k.primary().EncryptWithAD(plaintext, associatedData)
+	return k.primaryID, encrypted, err
+}
+
+// Decrypts with the key of the given ID, an empty one meaning the legacy key.
+// stale is true if that isn't the primary key, and the value should be
+// encrypted again.
+func (k *AESKeyring) Decrypt(keyID string, encrypted []byte) (plaintext []byte, stale bool, err error) {
+	return k.DecryptWithAD(keyID, encrypted, nil)
+}
+
+func (k *AESKeyring) DecryptWithAD(keyID string, encrypted, associatedData []byte) (plaintext []byte, stale bool, err error) {
+	var aesCipher *AESCipher
+	if keyID == "" {
+		if k.legacy == nil {
+			return nil, false, ErrNoLegacyKey
+		}
+		aesCipher = k.legacy
+	} else {
+		var ok bool
+		if aesCipher, ok = k.ciphers[keyID]; !ok {
+			return nil, false, fmt.Errorf("%w: %q", ErrUnknownKeyID, keyID)
+		}
+	}
+
+	plaintext, err = aesCipher.DecryptWithAD(encrypted, associatedData)
+	if err != nil {
+		return nil, false, err
+	}
+	return plaintext, keyID != k.primaryID, nil
+}
+
+// Prefixes the encoded ciphertext with the key ID, if any
+func JoinKeyID(keyID, encoded string) string {
+	if keyID == "" {
+		return encoded
+	}
+	return keyID + keyIDSeparator + encoded
+}
+
+// Inverse of JoinKeyID
+func SplitKeyID(value string) (keyID, encoded string) {
+	keyID, encoded, found := strings.Cut(value, keyIDSeparator)
+	if !found {
+		return "", value
+	}
+	return keyID, encoded
+}
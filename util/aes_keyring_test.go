@@ -0,0 +1,177 @@
+package util
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testHexKey1      = strings.Repeat("ab", 32)
+	testHexKey2      = strings.Repeat("cd", 32)
+	testLegacyHexKey = strings.Repeat("ef", 32)
+)
+
+func newKeyring(t *testing.T, primaryID string, hexKeys map[string]string, legacyHexKey string) *AESKeyring {
+	t.Helper()
+	keyring, err := NewAESKeyring(primaryID, hexKeys, legacyHexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyringDecryptsWithNonPrimaryKeys(t *testing.T) {
+	keys := map[string]string{"k1": testHexKey1, "k2": testHexKey2}
+	before := newKeyring(t, "k1", keys, "")
+	after := newKeyring(t, "k2", keys, "")
+
+	keyID, encrypted, err := before.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "k1" {
+		t.Fatalf("encrypted with key %q, want the primary k1", keyID)
+	}
+
+	plaintext, stale, err := after.Decrypt(keyID, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" || !stale {
+		t.Fatalf("got %q, stale %v, want it due for re-encryption", plaintext, stale)
+	}
+	if _, stale, err := before.Decrypt(keyID, encrypted); err != nil || stale {
+		t.Fatalf("primary key: stale %v, error %v", stale, err)
+	}
+}
+
+func TestKeyringLegacyKey(t *testing.T) {
+	legacy, err := NewAESCipher(testLegacyHexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := legacy.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withLegacy := newKeyring(t, "k1", map[string]string{"k1": testHexKey1}, testLegacyHexKey)
+	plaintext, stale, err := withLegacy.Decrypt("", encrypted)
+	if err != nil || string(plaintext) != "secret" || !stale {
+		t.Fatalf("got %q, stale %v, error %v", plaintext, stale, err)
+	}
+
+	withoutLegacy := newKeyring(t, "k1", map[string]string{"k1": testHexKey1}, "")
+	if _, _, err := withoutLegacy.Decrypt("", encrypted); !errors.Is(err, ErrNoLegacyKey) {
+		t.Fatalf("got error %v, want %v", err, ErrNoLegacyKey)
+	}
+}
+
+func TestKeyringRejectsUnknownKeyIDs(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string]string{"k1": testHexKey1}, "")
+	other := newKeyring(t, "k9", map[string]string{"k9": testHexKey2}, "")
+
+	keyID, encrypted, err := other.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyring.Decrypt(keyID, encrypted); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("got error %v, want %v", err, ErrUnknownKeyID)
+	}
+
+	cookieValue, err := EncryptCookieValue(other, "session", []byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(cookieValue, "k9.") {
+		t.Fatalf("cookie value %q isn't prefixed with its key ID", cookieValue)
+	}
+	if _, _, err := DecryptCookieValue(keyring, "session", cookieValue); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("cookie: got error %v, want %v", err, ErrUnknownKeyID)
+	}
+}
+
+func TestKeyringRejectsTamperedCiphertexts(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string]string{"k1": testHexKey1}, "")
+	keyID, encrypted, err := keyring.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range encrypted {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[i] ^= 1
+		if _, _, err := keyring.Decrypt(keyID, tampered); err == nil {
+			t.Fatalf("decrypted a ciphertext with byte %d flipped", i)
+		}
+	}
+	if _, _, err := keyring.Decrypt(keyID, encrypted[:len(encrypted)-1]); err == nil {
+		t.Fatal("decrypted a truncated ciphertext")
+	}
+	if _, _, err := keyring.Decrypt(keyID, encrypted[:4]); !errors.Is(err, ErrCiphertextTooShort) {
+		t.Fatalf("got error %v, want %v", err, ErrCiphertextTooShort)
+	}
+}
+
+func TestKeyringChecksAssociatedData(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string]string{"k1": testHexKey1}, "")
+	keyID, encrypted, err := keyring.EncryptWithAD([]byte("secret"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyring.DecryptWithAD(keyID, encrypted, []byte("ad")); err != nil {
+		t.Fatal(err)
+	}
+	for _, ad := range [][]byte{nil, []byte("other"), []byte("ad\x00")} {
+		if _, _, err := keyring.DecryptWithAD(keyID, encrypted, ad); err == nil {
+			t.Fatalf("decrypted with associated data %q", ad)
+		}
+	}
+
+	// A cookie value under another version byte, even while unversioned
+	// values are accepted
+	keyring.AcceptUnversionedCookiesUntil(time.Now().Add(time.Hour))
+	cookieValue, err := EncryptCookieValue(keyring, "session", []byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, encoded := SplitKeyID(cookieValue)
+	blob, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[0] = cookieFormatVersion + 1
+	reversioned := JoinKeyID(id, base64.URLEncoding.EncodeToString(blob))
+	if _, _, err := DecryptCookieValue(keyring, "session", reversioned); err == nil {
+		t.Fatal("decrypted a cookie value under another format version")
+	}
+	if _, _, err := DecryptCookieValue(keyring, "other", cookieValue); err == nil {
+		t.Fatal("decrypted a cookie value as another cookie")
+	}
+}
+
+func TestNewAESKeyringRejectsInvalidKeyrings(t *testing.T) {
+	tests := []struct {
+		name      string
+		primaryID string
+		keys      map[string]string
+		legacy    string
+	}{
+		{"no keys", "", nil, ""},
+		{"primary not in keyring", "k2", map[string]string{"k1": testHexKey1}, ""},
+		{"keys without a primary", "", map[string]string{"k1": testHexKey1}, testLegacyHexKey},
+		{"key ID with a separator", "k.1", map[string]string{"k.1": testHexKey1}, ""},
+		{"key ID too long", strings.Repeat("k", 33), map[string]string{strings.Repeat("k", 33): testHexKey1}, ""},
+	}
+	for _, tc := range tests {
+		if _, err := NewAESKeyring(tc.primaryID, tc.keys, tc.legacy); !errors.Is(err, ErrInvalidKeyIDs) {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, ErrInvalidKeyIDs)
+		}
+	}
+	if _, err := NewAESKeyring("k1", map[string]string{"k1": "abcd"}, ""); !errors.Is(err, ErrInvalidAESKeyLength) {
+		t.Errorf("short key: got error %v, want %v", err, ErrInvalidAESKeyLength)
+	}
+}
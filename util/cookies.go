@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/nrawrx3/workout-backend/constants"
 	"github.com/nrawrx3/workout-backend/model"
@@ -40,46 +41,124 @@ func ReadCookieThenDecodeB64(r *http.Request, name string) ([]byte, error) {
 	return value, nil
 }
 
-func EncryptThenEncodeB64ThenWriteCookie(w http.ResponseWriter, cookie http.Cookie, aesCipher *AESCipher, value []byte) error {
-	value, err := aesCipher.Encrypt(value)
+// Version of the encrypted cookie format. It's the first byte of the
+// encrypted blob and, together with the cookie name, the associated data the
+// blob is authenticated with, so a value minted for one cookie won't decrypt
+// when presented as another.
+const cookieFormatVersion byte = 1
+
+func cookieAssociatedData(cookieName string) []byte {
+	return append([]byte{cookieFormatVersion}, cookieName...)
+}
+
+// Encrypts value for the cookie named cookieName. The result is the ID of the
+// key used, a '.' and the base64 encoded blob.
+func EncryptCookieValue(keyring *AESKeyring, cookieName string, value []byte) (string, error) {
+	keyID, encrypted, err := keyring.EncryptWithAD(value, cookieAssociatedData(cookieName))
 	if err != nil {
-		return fmt.Errorf("failed to encrypt cookie value: %w", err)
+		return "", err
+	}
+	blob := append([]byte{cookieFormatVersion}, encrypted...)
+	return JoinKeyID(keyID, base64.URLEncoding.EncodeToString(blob)), nil
+}
+
+// Cookie values written before the cookie name was bound, with no version
+// byte and no associated data, are accepted until t and rejected after. The
+// zero time, the default, rejects them outright.
+func (k *AESKeyring) AcceptUnversionedCookiesUntil(t time.Time) {
+	k.unversionedCookiesUntil = t
+}
+
+var ErrUnversionedCookie = errors.New("cookie value is in the format from before cookie names were bound")
+
+// Inverse of EncryptCookieValue. Unversioned values are accepted only as long
+// as the keyring allows, and reported as stale so that they get written again
+// in the current format.
+func DecryptCookieValue(keyring *AESKeyring, cookieName string, cookieValue string) (value []byte, stale bool, err error) {
+	keyID, encoded := SplitKeyID(cookieValue)
+	blob, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode base64 encoded cookie bytes: %w", constants.ErrCodeInvalidValue)
+	}
+
+	if len(blob) > 0 && blob[0] == cookieFormatVersion {
+		value, stale, err = keyring.DecryptWithAD(keyID, blob[1:], cookieAssociatedData(cookieName))
+		if err == nil {
+			return value, stale, nil
+		}
 	}
 
+	if !time.Now().Before(keyring.unversionedCookiesUntil) {
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, ErrUnversionedCookie
+	}
+
+	// A legacy blob starts with a version byte by chance 1 in 256 times, in
+	// which case the attempt above fails to authenticate and we land here.
+	value, _, legacyErr := keyring.Decrypt(keyID, blob)
+	if legacyErr != nil {
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, legacyErr
+	}
+	return value, true, nil
+}
+
+func EncryptThenEncodeB64ThenWriteCookie(w http.ResponseWriter, cookie http.Cookie, keyring *AESKeyring, value []byte) error {
 	if len(value) > 4096 {
 		return constants.ErrCodeMaxSizeExceeded
 	}
 
-	return EncodeB64ThenWriteCookie(w, cookie, value)
+	cookieValue, err := EncryptCookieValue(keyring, cookie.Name, value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt cookie value: %w", err)
+	}
+
+	cookie.Value = cookieValue
+	if len(cookie.String()) > 4096 {
+		return constants.ErrCodeMaxSizeExceeded
+	}
+
+	http.SetCookie(w, &cookie)
+	return nil
 }
 
-func ReadCookieDecodeB64ThenDecrypt(r *http.Request, name string, aesCipher *AESCipher) (string, error) {
-	encryptedBytes, err := ReadCookieThenDecodeB64(r, name)
+// stale is true if the cookie is encrypted with a key other than the primary
+// one or in an older format, and should be written again
+func ReadCookieDecodeB64ThenDecrypt(r *http.Request, name string, keyring *AESKeyring) (value string, stale bool, err error) {
+	cookie, err := r.Cookie(name)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 encoded cookie bytes: %w", err)
+		if errors.Is(err, http.ErrNoCookie) {
+			return "", false, constants.ErrCodeNotFound
+		}
+		return "", false, err
 	}
-	decryptedBytes, err := aesCipher.Decrypt(encryptedBytes)
+
+	decryptedBytes, stale, err := DecryptCookieValue(keyring, name, cookie.Value)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt cookie value: %w", err)
+		return "", false, fmt.Errorf("failed to decrypt cookie value: %w", err)
 	}
-	return string(decryptedBytes), nil
+	return string(decryptedBytes), stale, nil
 }
 
-func ExtractSessionIDFromCookie(r *http.Request, cookieName string, aesCipher *AESCipher) (uint64, error) {
-	cookieValueRaw, err := ReadCookieDecodeB64ThenDecrypt(r, cookieName, aesCipher)
+func ExtractSessionIDFromCookie(r *http.Request, cookieName string, keyring *AESKeyring) (sessionID uint64, stale bool, err error) {
+	cookieValueRaw, stale, err := ReadCookieDecodeB64ThenDecrypt(r, cookieName, keyring)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 
 	var cookieValue model.SessionCookieValue
 	err = json.NewDecoder(strings.NewReader(cookieValueRaw)).Decode(&cookieValue)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 
 	sessionId, err := Uint64FromStringID(cookieValue.SessionID)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
-	return sessionId, nil
+	return sessionId, stale, nil
 }
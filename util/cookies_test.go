@@ -0,0 +1,74 @@
+package util
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeyring(t *testing.T) *AESKeyring {
+	t.Helper()
+	keyring, err := NewAESKeyring("k1", map[string]string{"k1": strings.Repeat("ab", 32)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// Encrypts the way cookies were before they were bound to their name
+func unversionedCookieValue(t *testing.T, keyring *AESKeyring, value string) string {
+	t.Helper()
+	keyID, encrypted, err := keyring.Encrypt([]byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return JoinKeyID(keyID, base64.URLEncoding.EncodeToString(encrypted))
+}
+
+func TestCookieValueIsBoundToTheCookieName(t *testing.T) {
+	keyring := newTestKeyring(t)
+	cookieValue, err := EncryptCookieValue(keyring, "session", []byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, stale, err := DecryptCookieValue(keyring, "session", cookieValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "42" || stale {
+		t.Fatalf("got %q, stale %v", value, stale)
+	}
+	if _, _, err := DecryptCookieValue(keyring, "oidc_flow", cookieValue); err == nil {
+		t.Fatal("value decrypted as another cookie")
+	}
+}
+
+func TestUnversionedCookiesAreRejectedByDefault(t *testing.T) {
+	keyring := newTestKeyring(t)
+	_, _, err := DecryptCookieValue(keyring, "session", unversionedCookieValue(t, keyring, "42"))
+	if !errors.Is(err, ErrUnversionedCookie) {
+		t.Fatalf("got error %v, want %v", err, ErrUnversionedCookie)
+	}
+}
+
+func TestUnversionedCookiesAreAcceptedUntilTheCutoff(t *testing.T) {
+	keyring := newTestKeyring(t)
+	cookieValue := unversionedCookieValue(t, keyring, "42")
+
+	keyring.AcceptUnversionedCookiesUntil(time.Now().Add(time.Hour))
+	value, stale, err := DecryptCookieValue(keyring, "session", cookieValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "42" || !stale {
+		t.Fatalf("got %q, stale %v, want it re-issued", value, stale)
+	}
+
+	keyring.AcceptUnversionedCookiesUntil(time.Now().Add(-time.Hour))
+	if _, _, err := DecryptCookieValue(keyring, "session", cookieValue); !errors.Is(err, ErrUnversionedCookie) {
+		t.Fatalf("got error %v past the cutoff, want %v", err, ErrUnversionedCookie)
+	}
+}
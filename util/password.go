@@ -0,0 +1,264 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are stored in the PHC string format, e.g.
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// with unpadded base64 salt and hash. bcrypt hashes are stored as bcrypt
+// writes them ($2a$10$...), which is the same shape. Hashes written before
+// this format are base64 encoded bcrypt hashes, still verified but always
+// due for a rehash.
+
+const (
+	PasswordAlgorithmBcrypt   = "bcrypt"
+	PasswordAlgorithmArgon2id = "argon2id"
+)
+
+var (
+	ErrUnknownPasswordHashFormat = errors.New("unknown password hash format")
+	ErrInvalidPasswordHashParams = errors.New("invalid password hash parameters")
+)
+
+type PasswordHashParams struct {
+	Algorithm  string
+	BcryptCost int
+
+	// Memory in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  uint32
+	Argon2KeyLength   uint32
+}
+
+// The argon2id parameters are the first of the OWASP recommendations
+var DefaultPasswordHashParams = PasswordHashParams{
+	Algorithm:         PasswordAlgorithmArgon2id,
+	BcryptCost:        bcrypt.DefaultCost,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+}
+
+// Returns a copy with unset parameters replaced by the defaults
+func (p PasswordHashParams) WithDefaults() PasswordHashParams {
+	d := DefaultPasswordHashParams
+	if p.Algorithm == "" {
+		p.Algorithm = d.Algorithm
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = d.BcryptCost
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = d.Argon2Memory
+	}
+	if p.Argon2Iterations == 0 {
+		p.Argon2Iterations = d.Argon2Iterations
+	}
+	if p.Argon2Parallelism == 0 {
+		p.Argon2Parallelism = d.Argon2Parallelism
+	}
+	if p.Argon2SaltLength == 0 {
+		p.Argon2SaltLength = d.Argon2SaltLength
+	}
+	if p.Argon2KeyLength == 0 {
+		p.Argon2KeyLength = d.Argon2KeyLength
+	}
+	return p
+}
+
+// Hashes new passwords with the configured algorithm, and tells which stored
+// hashes were made with another one or weaker parameters
+type PasswordHasher struct {
+	params PasswordHashParams
+}
+
+func NewPasswordHasher(params PasswordHashParams) (*PasswordHasher, error) {
+	params = params.WithDefaults()
+	switch params.Algorithm {
+	case PasswordAlgorithmBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("%w: bcrypt cost must be between %d and %d", ErrInvalidPasswordHashParams, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case PasswordAlgorithmArgon2id:
+		if params.Argon2Memory < 8*uint32(params.Argon2Parallelism) {
+			return nil, fmt.Errorf("%w: argon2id memory must be at least 8 KiB per thread", ErrInvalidPasswordHashParams)
+		}
+		if params.Argon2SaltLength < 8 || params.Argon2KeyLength < 16 {
+			return nil, fmt.Errorf("%w: argon2id salt must be at least 8 bytes and key at least 16", ErrInvalidPasswordHashParams)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidPasswordHashParams, params.Algorithm)
+	}
+	return &PasswordHasher{params: params}, nil
+}
+
+// Hashes with the default parameters
+func HashPassword(password string) (string, error) {
+	hasher, err := NewPasswordHasher(DefaultPasswordHashParams)
+	if err != nil {
+		return "", err
+	}
+	return hasher.Hash(password)
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.params.Algorithm == PasswordAlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, h.params.Argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	hash := argon2Hash{
+		memory:      h.params.Argon2Memory,
+		iterations:  h.params.Argon2Iterations,
+		parallelism: h.params.Argon2Parallelism,
+		salt:        salt,
+	}
+	hash.key = hash.derive(password, h.params.Argon2KeyLength)
+	return hash.String(), nil
+}
+
+// Reports whether the stored hash should be replaced by one made with the
+// current algorithm and parameters. Unparseable hashes need one too.
+func (h *PasswordHasher) NeedsRehash(encodedHash string) bool {
+	switch passwordHashAlgorithm(encodedHash) {
+	case PasswordAlgorithmBcrypt:
+		if h.params.Algorithm != PasswordAlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encodedHash))
+		return err != nil || cost < h.params.BcryptCost
+	case PasswordAlgorithmArgon2id:
+		if h.params.Algorithm != PasswordAlgorithmArgon2id {
+			return true
+		}
+		hash, err := parseArgon2Hash(encodedHash)
+		return err != nil ||
+			hash.memory < h.params.Argon2Memory ||
+			hash.iterations < h.params.Argon2Iterations ||
+			hash.parallelism != h.params.Argon2Parallelism ||
+			uint32(len(hash.salt)) < h.params.Argon2SaltLength ||
+			uint32(len(hash.key)) < h.params.Argon2KeyLength
+	}
+	return true
+}
+
+// Verifies the password against a hash in any of the supported formats. The
+// error is only for hashes that can't be parsed, a mismatch is just false.
+func PasswordMatchesHash(password, encodedHash string) (bool, error) {
+	switch passwordHashAlgorithm(encodedHash) {
+	case PasswordAlgorithmBcrypt:
+		return bcryptMatches(password, []byte(encodedHash))
+	case PasswordAlgorithmArgon2id:
+		hash, err := parseArgon2Hash(encodedHash)
+		if err != nil {
+			return false, err
+		}
+		key := hash.derive(password, uint32(len(hash.key)))
+		return subtle.ConstantTimeCompare(key, hash.key) == 1, nil
+	case "":
+		// Base64 encoded bcrypt, from before the PHC format
+		hash, err := base64.StdEncoding.DecodeString(encodedHash)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrUnknownPasswordHashFormat, err)
+		}
+		return bcryptMatches(password, hash)
+	}
+	return false, ErrUnknownPasswordHashFormat
+}
+
+func bcryptMatches(password string, hash []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUnknownPasswordHashFormat, err)
+	}
+	return true, nil
+}
+
+// Returns the algorithm named by the PHC identifier of the hash, "" for hashes
+// not in the PHC format
+func passwordHashAlgorithm(encodedHash string) string {
+	if !strings.HasPrefix(encodedHash, "$") {
+		return ""
+	}
+	id, _, _ := strings.Cut(encodedHash[1:], "$")
+	switch id {
+	case "2a", "2b", "2y":
+		return PasswordAlgorithmBcrypt
+	case "argon2id":
+		return PasswordAlgorithmArgon2id
+	}
+	return id
+}
+
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h argon2Hash) derive(password string, keyLength uint32) []byte {
+	return argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.parallelism, keyLength)
+}
+
+func (h argon2Hash) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.key))
+}
+
+func parseArgon2Hash(encodedHash string) (argon2Hash, error) {
+	var hash argon2Hash
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return hash, fmt.Errorf("%w: expected 5 fields in argon2id hash", ErrUnknownPasswordHashFormat)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return hash, fmt.Errorf("%w: unsupported argon2 version %q", ErrUnknownPasswordHashFormat, parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.memory, &hash.iterations, &hash.parallelism); err != nil {
+		return hash, fmt.Errorf("%w: invalid argon2id parameters %q", ErrUnknownPasswordHashFormat, parts[3])
+	}
+	if hash.iterations == 0 || hash.parallelism == 0 {
+		return hash, fmt.Errorf("%w: invalid argon2id parameters %q", ErrUnknownPasswordHashFormat, parts[3])
+	}
+
+	var err error
+	if hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return hash, fmt.Errorf("%w: invalid argon2id salt", ErrUnknownPasswordHashFormat)
+	}
+	if hash.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash.key) == 0 {
+		return hash, fmt.Errorf("%w: invalid argon2id key", ErrUnknownPasswordHashFormat)
+	}
+	return hash, nil
+}
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters, the tests hash a lot
+var testArgon2Params = PasswordHashParams{
+	Algorithm:         PasswordAlgorithmArgon2id,
+	Argon2Memory:      64,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 1,
+}
+
+var testBcryptParams = PasswordHashParams{
+	Algorithm:  PasswordAlgorithmBcrypt,
+	BcryptCost: bcrypt.MinCost,
+}
+
+func newTestHasher(t *testing.T, params PasswordHashParams) *PasswordHasher {
+	t.Helper()
+	hasher, err := NewPasswordHasher(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func hashWith(t *testing.T, params PasswordHashParams, password string) string {
+	t.Helper()
+	hash, err := newTestHasher(t, params).Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func assertPasswordMatches(t *testing.T, password, hash string, want bool) {
+	t.Helper()
+	ok, err := PasswordMatchesHash(password, hash)
+	if err != nil {
+		t.Fatalf("verifying against %q: %v", hash, err)
+	}
+	if ok != want {
+		t.Fatalf("password %q matches %q: got %v, want %v", password, hash, ok, want)
+	}
+}
+
+func TestArgon2idHashRoundTrips(t *testing.T) {
+	hash := hashWith(t, testArgon2Params, "hunter2")
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q isn't in the PHC format", hash)
+	}
+
+	parsed, err := parseArgon2Hash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.memory != 64 || parsed.iterations != 1 || parsed.parallelism != 1 ||
+		len(parsed.salt) != int(DefaultPasswordHashParams.Argon2SaltLength) ||
+		len(parsed.key) != int(DefaultPasswordHashParams.Argon2KeyLength) {
+		t.Fatalf("parsed %q as %+v", hash, parsed)
+	}
+	if parsed.String() != hash {
+		t.Fatalf("re-encoded %q as %q", hash, parsed.String())
+	}
+
+	assertPasswordMatches(t, "hunter2", hash, true)
+	assertPasswordMatches(t, "hunter3", hash, false)
+	if other := hashWith(t, testArgon2Params, "hunter2"); other == hash {
+		t.Fatal("two hashes of the same password share a salt")
+	}
+}
+
+func TestBcryptHashRoundTrips(t *testing.T) {
+	hash := hashWith(t, testBcryptParams, "hunter2")
+	if !strings.HasPrefix(hash, "$2a$04$") {
+		t.Fatalf("hash %q isn't a bcrypt hash of cost 4", hash)
+	}
+	assertPasswordMatches(t, "hunter2", hash, true)
+	assertPasswordMatches(t, "hunter3", hash, false)
+}
+
+// Hashes from before the PHC format are base64 encoded bcrypt hashes
+func TestLegacyBase64BcryptHashes(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := base64.StdEncoding.EncodeToString(bcryptHash)
+
+	assertPasswordMatches(t, "hunter2", hash, true)
+	assertPasswordMatches(t, "hunter3", hash, false)
+	for _, params := range []PasswordHashParams{testArgon2Params, testBcryptParams} {
+		if !newTestHasher(t, params).NeedsRehash(hash) {
+			t.Fatalf("legacy hash isn't due for a rehash with %s", params.Algorithm)
+		}
+	}
+}
+
+func TestMalformedPasswordHashes(t *testing.T) {
+	for _, hash := range []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("not bcrypt")),
+		"$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+		"$2a$04$short",
+	} {
+		if _, err := PasswordMatchesHash("hunter2", hash); !errors.Is(err, ErrUnknownPasswordHashFormat) {
+			t.Errorf("hash %q: got error %v, want %v", hash, err, ErrUnknownPasswordHashFormat)
+		}
+		if !newTestHasher(t, testArgon2Params).NeedsRehash(hash) {
+			t.Errorf("malformed hash %q isn't due for a rehash", hash)
+		}
+	}
+}
+
+func TestNeedsRehashWhenParametersChange(t *testing.T) {
+	argon2Hash := hashWith(t, testArgon2Params, "hunter2")
+	bcryptHash := hashWith(t, testBcryptParams, "hunter2")
+
+	stronger := func(change func(p *PasswordHashParams)) PasswordHashParams {
+		p := testArgon2Params
+		change(&p)
+		return p
+	}
+	tests := []struct {
+		name   string
+		params PasswordHashParams
+		hash   string
+		want   bool
+	}{
+		{"same argon2id parameters", testArgon2Params, argon2Hash, false},
+		{"more memory", stronger(func(p *PasswordHashParams) { p.Argon2Memory = 128 }), argon2Hash, true},
+		{"less memory", stronger(func(p *PasswordHashParams) { p.Argon2Memory = 32 }), argon2Hash, false},
+		{"more iterations", stronger(func(p *PasswordHashParams) { p.Argon2Iterations = 2 }), argon2Hash, true},
+		{"other parallelism", stronger(func(p *PasswordHashParams) { p.Argon2Parallelism = 2 }), argon2Hash, true},
+		{"longer salt", stronger(func(p *PasswordHashParams) { p.Argon2SaltLength = 32 }), argon2Hash, true},
+		{"longer key", stronger(func(p *PasswordHashParams) { p.Argon2KeyLength = 64 }), argon2Hash, true},
+		{"argon2id to bcrypt", testBcryptParams, argon2Hash, true},
+		{"same bcrypt cost", testBcryptParams, bcryptHash, false},
+		{"higher bcrypt cost", PasswordHashParams{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: 5}, bcryptHash, true},
+		{"bcrypt to argon2id", testArgon2Params, bcryptHash, true},
+	}
+	for _, tc := range tests {
+		if got := newTestHasher(t, tc.params).NeedsRehash(tc.hash); got != tc.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNewPasswordHasherRejectsWeakParameters(t *testing.T) {
+	for _, params := range []PasswordHashParams{
+		{Algorithm: "md5"},
+		{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: bcrypt.MinCost - 1},
+		{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: bcrypt.MaxCost + 1},
+		{Algorithm: PasswordAlgorithmArgon2id, Argon2Memory: 8, Argon2Parallelism: 2},
+		{Algorithm: PasswordAlgorithmArgon2id, Argon2SaltLength: 4},
+		{Algorithm: PasswordAlgorithmArgon2id, Argon2KeyLength: 8},
+	} {
+		if _, err := NewPasswordHasher(params); !errors.Is(err, ErrInvalidPasswordHashParams) {
+			t.Errorf("params %+v: got error %v, want %v", params, err, ErrInvalidPasswordHashParams)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package validation checks user supplied values before they reach the store.
+// Validators collect every violation instead of stopping at the first, and
+// report them as an apperror invalid input error with per field details.
+package validation
+
+import (
+	"fmt"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+// Highest position a workout can be given explicitly. Positions past the end
+// of the list append, so larger ones are pointless.
+const MaxWorkoutOrder = 10000
+
+// The order sentinel meaning "put it at the end of the list"
+const AppendWorkoutOrder = -1
+
+type WorkoutValidator struct {
+	defaults config.WorkoutLimits
+	byKind   map[model.WorkoutKind]config.WorkoutLimits
+}
+
+// Kinds missing from limits fall back to the config.DefaultWorkoutLimitsKey
+// entry, whose unset fields fall back to config.DefaultWorkoutLimits. Unset
+// fields of the other entries are taken from the default entry.
+func NewWorkoutValidator(limits map[string]config.WorkoutLimits) (*WorkoutValidator, error) {
+	v := &WorkoutValidator{
+		defaults: limits[config.DefaultWorkoutLimitsKey].WithDefaults(config.DefaultWorkoutLimits),
+		byKind:   make(map[model.WorkoutKind]config.WorkoutLimits),
+	}
+	if err := v.defaults.CheckRanges(); err != nil {
+		return nil, fmt.Errorf("default workout limits: %w", err)
+	}
+	for key, l := range limits {
+		if key == config.DefaultWorkoutLimitsKey {
+			continue
+		}
+		kind, err := model.CastWorkoutKind(key)
+		if err != nil {
+			return nil, fmt.Errorf("unknown workout kind %q in workout limits: %w", key, err)
+		}
+		v.byKind[kind] = l.WithDefaults(v.defaults)
+		if err := v.byKind[kind].CheckRanges(); err != nil {
+			return nil, fmt.Errorf("workout limits of %s: %w", kind, err)
+		}
+	}
+	return v, nil
+}
+
+func (v *WorkoutValidator) limitsOf(kind model.WorkoutKind) config.WorkoutLimits {
+	if l, ok := v.byKind[kind]; ok {
+		return l
+	}
+	return v.defaults
+}
+
+// Fields that are nil are not being set and are not checked. Field names are
+// the graphql argument names.
+type WorkoutFields struct {
+	Kind            model.WorkoutKind
+	Reps            *int
+	DurationSeconds *int
+	Rounds          *int
+	Order           *int
+	// Whether Order may be AppendWorkoutOrder. Only the deprecated mutations
+	// use the sentinel.
+	AllowAppendOrder bool
+}
+
+type fieldErrors []apperror.FieldError
+
+func (errs *fieldErrors) checkRange(field string, value *int, min, max int) {
+	if value == nil {
+		return
+	}
+	if *value < min || *value > max {
+		*errs = append(*errs, apperror.FieldError{
+			Field:   field,
+			Message: fmt.Sprintf("must be between %d and %d, got %d", min, max, *value),
+		})
+	}
+}
+
+// Returns nil if the fields are valid for the workout kind
+func (v *WorkoutValidator) Validate(w WorkoutFields) error {
+	l := v.limitsOf(w.Kind)
+
+	var errs fieldErrors
+	errs.checkRange("reps", w.Reps, *l.MinReps, *l.MaxReps)
+	errs.checkRange("duration_seconds", w.DurationSeconds, *l.MinDurationSeconds, *l.MaxDurationSeconds)
+	errs.checkRange("rounds", w.Rounds, *l.MinRounds, *l.MaxRounds)
+	if w.Order != nil && !(w.AllowAppendOrder && *w.Order == AppendWorkoutOrder) {
+		errs.checkRange("order", w.Order, 0, MaxWorkoutOrder)
+	}
+
+	if len(errs) != 0 {
+		return apperror.InvalidFields(errs)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/config"
+	"github.com/nrawrx3/workout-backend/model"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// The limits as values, for comparing
+func limitValues(l config.WorkoutLimits) [6]int {
+	return [6]int{*l.MinReps, *l.MaxReps, *l.MinDurationSeconds, *l.MaxDurationSeconds, *l.MinRounds, *l.MaxRounds}
+}
+
+func TestWorkoutLimitsFillUnsetFieldsFromTheDefaultEntry(t *testing.T) {
+	v, err := NewWorkoutValidator(map[string]config.WorkoutLimits{
+		config.DefaultWorkoutLimitsKey: {MaxReps: intPtr(500), MaxDurationSeconds: intPtr(600)},
+		string(model.WorkoutBurpees):   {MaxReps: intPtr(50)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := limitValues(config.DefaultWorkoutLimits)
+	want[1] = 500
+	want[3] = 600
+	if got := limitValues(v.limitsOf(model.WorkoutPushups)); got != want {
+		t.Fatalf("default limits are %v, want %v", got, want)
+	}
+	want[1] = 50
+	if got := limitValues(v.limitsOf(model.WorkoutBurpees)); got != want {
+		t.Fatalf("burpees limits are %v, want %v", got, want)
+	}
+
+	duration := 601
+	if err := v.Validate(WorkoutFields{Kind: model.WorkoutBurpees, DurationSeconds: &duration}); err == nil {
+		t.Fatal("burpees accepted a duration over the default entry's maximum")
+	}
+	reps := 0
+	if err := v.Validate(WorkoutFields{Kind: model.WorkoutBurpees, Reps: &reps}); err == nil {
+		t.Fatal("burpees accepted reps under the built-in minimum")
+	}
+}
+
+func TestWorkoutLimitsCanBeZero(t *testing.T) {
+	v, err := NewWorkoutValidator(map[string]config.WorkoutLimits{
+		config.DefaultWorkoutLimitsKey: {MinRounds: intPtr(1)},
+		string(model.WorkoutBurpees):   {MinReps: intPtr(0), MinRounds: intPtr(0), MaxRounds: intPtr(0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zero, one := 0, 1
+	if err := v.Validate(WorkoutFields{Kind: model.WorkoutBurpees, Reps: &zero, Rounds: &zero}); err != nil {
+		t.Fatalf("burpees rejected zero reps and rounds: %v", err)
+	}
+	if err := v.Validate(WorkoutFields{Kind: model.WorkoutBurpees, Rounds: &one}); err == nil {
+		t.Fatal("burpees accepted a round over a maximum of 0")
+	}
+	if err := v.Validate(WorkoutFields{Kind: model.WorkoutPushups, Rounds: &zero}); err == nil {
+		t.Fatal("pushups accepted zero rounds under the default entry's minimum")
+	}
+}
+
+func TestWorkoutLimitsRejectMinimumsOverMaximums(t *testing.T) {
+	tests := []map[string]config.WorkoutLimits{
+		{config.DefaultWorkoutLimitsKey: {MinReps: intPtr(10), MaxReps: intPtr(5)}},
+		// Over a maximum taken from the default entry
+		{
+			config.DefaultWorkoutLimitsKey: {MaxRounds: intPtr(10)},
+			string(model.WorkoutBurpees):   {MinRounds: intPtr(20)},
+		},
+		// Over a built-in maximum
+		{string(model.WorkoutBurpees): {MinDurationSeconds: intPtr(3 * 60 * 60)}},
+		// Under a built-in minimum
+		{string(model.WorkoutBurpees): {MaxReps: intPtr(0)}},
+	}
+	for i, limits := range tests {
+		if _, err := NewWorkoutValidator(limits); err == nil {
+			t.Errorf("case %d: accepted min over max", i)
+		}
+	}
+}
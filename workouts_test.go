@@ -0,0 +1,456 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/nrawrx3/workout-backend/apperror"
+	"github.com/nrawrx3/workout-backend/constants"
+	"github.com/nrawrx3/workout-backend/model"
+	"github.com/nrawrx3/workout-backend/store"
+)
+
+const updateWorkoutMutation = `mutation($id: ID!, $version: Int) {
+	update_workout(workout_id: $id, kind: PUSH_UPS, reps: 99, duration_seconds: 99, rounds: 9, order: 0, expected_version: $version)
+}`
+
+func TestUpdateWorkoutOnlyUpdatesOwnWorkouts(t *testing.T) {
+	app := newTestApp(t, nil)
+	var janesWorkout model.Workout
+	if err := app.DB.Order("id").First(&janesWorkout).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	createTestUser(t, app, "mallory", "mallory@example.com", "mallorypass")
+	mallory := newTestClient(t, app)
+	graphqlLogin(t, mallory, "mallory@example.com", "mallorypass")
+
+	codes := mallory.graphqlErrors(updateWorkoutMutation, map[string]interface{}{"id": strconv.FormatUint(janesWorkout.ID, 10)}, nil)
+	if want := []string{string(apperror.CodeNotFound)}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("got errors %v, want %v", codes, want)
+	}
+
+	var after model.Workout
+	if err := app.DB.First(&after, janesWorkout.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after.Version != janesWorkout.Version || after.Reps != janesWorkout.Reps {
+		t.Fatalf("workout changed: %+v", after)
+	}
+}
+
+func TestUpdateWorkoutChecksExpectedVersion(t *testing.T) {
+	app := newTestApp(t, nil)
+	var workout model.Workout
+	if err := app.DB.Order("id").First(&workout).Error; err != nil {
+		t.Fatal(err)
+	}
+	jane := newTestClient(t, app)
+	graphqlLogin(t, jane, testUserEmail, testUserPassword)
+	id := strconv.FormatUint(workout.ID, 10)
+
+	codes := jane.graphqlErrors(updateWorkoutMutation, map[string]interface{}{"id": id, "version": workout.Version + 1}, nil)
+	if want := []string{string(apperror.CodeConflict)}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("stale version: got errors %v, want %v", codes, want)
+	}
+
+	jane.graphql(updateWorkoutMutation, map[string]interface{}{"id": id, "version": workout.Version}, nil)
+	// Last write wins without a version
+	jane.graphql(updateWorkoutMutation, map[string]interface{}{"id": id}, nil)
+
+	var after model.Workout
+	if err := app.DB.First(&after, workout.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after.Version != workout.Version+2 || after.Reps != 99 {
+		t.Fatalf("got version %d reps %d, want version %d reps 99", after.Version, after.Reps, workout.Version+2)
+	}
+}
+
+func workoutOrder(t *testing.T, c *testClient) []string {
+	t.Helper()
+	resp, body := c.get("/api/v1/workouts/order")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/v1/workouts/order: %d %s", resp.StatusCode, body)
+	}
+	var state struct {
+		Data model.WorkoutListStateResponseJSON `json:"data"`
+	}
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Data.WorkoutIDs
+}
+
+// Keys are generated between the keys of the listed workouts only, so a
+// partial list could give a workout the key of an unlisted one
+func TestReorderWorkoutsNeedsTheWholeList(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	before := workoutOrder(t, c)
+	if len(before) < 3 {
+		t.Fatalf("seeded %d workouts, want at least 3", len(before))
+	}
+
+	for name, ids := range map[string][]string{
+		"partial":   {before[len(before)-1], before[0]},
+		"duplicate": append(append([]string{}, before...), before[0]),
+	} {
+		encoded, _ := json.Marshal(model.ReorderWorkoutsRequestJSON{WorkoutIDs: ids})
+		resp, body := c.request(http.MethodPut, "/api/v1/workouts/order", "application/json", encoded)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: got %d %s, want 422", name, resp.StatusCode, body)
+		}
+		if after := workoutOrder(t, c); !reflect.DeepEqual(after, before) {
+			t.Fatalf("%s: order changed to %v", name, after)
+		}
+	}
+
+	reversed := make([]string, len(before))
+	for i, id := range before {
+		reversed[len(before)-1-i] = id
+	}
+	encoded, _ := json.Marshal(model.ReorderWorkoutsRequestJSON{WorkoutIDs: reversed})
+	resp, body := c.request(http.MethodPut, "/api/v1/workouts/order", "application/json", encoded)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reversed: got %d %s", resp.StatusCode, body)
+	}
+	if after := workoutOrder(t, c); !reflect.DeepEqual(after, reversed) {
+		t.Fatalf("got order %v, want %v", after, reversed)
+	}
+}
+
+// The deprecated operations taking a user_id refuse anyone else's
+func TestDeprecatedWorkoutOperationsNeedTheSessionUser(t *testing.T) {
+	app := newTestApp(t, nil)
+	var jane model.User
+	if err := app.DB.Where("email = ?", testUserEmail).First(&jane).Error; err != nil {
+		t.Fatal(err)
+	}
+	mallory := createTestUser(t, app, "mallory", "mallory@example.com", "mallorypass")
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, "mallory@example.com", "mallorypass")
+
+	const createWorkout = `mutation($user: ID!) {
+		create_workout(user_id: $user, kind: PUSH_UPS, reps: 10, duration_seconds: 60, order: -1)
+	}`
+	const workouts = `query($user: ID!) { workouts(user_id: $user) { id } }`
+	janesID := map[string]interface{}{"user": strconv.FormatUint(jane.ID, 10)}
+	want := []string{string(apperror.CodeForbidden)}
+	if codes := c.graphqlErrors(createWorkout, janesID, nil); !reflect.DeepEqual(codes, want) {
+		t.Fatalf("create_workout: got errors %v, want %v", codes, want)
+	}
+	if codes := c.graphqlErrors(workouts, janesID, nil); !reflect.DeepEqual(codes, want) {
+		t.Fatalf("workouts: got errors %v, want %v", codes, want)
+	}
+	var count int64
+	if err := app.DB.Model(&model.Workout{}).Where("user_id = ?", jane.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("jane has %d workouts, want 4", count)
+	}
+
+	malloryID := map[string]interface{}{"user": strconv.FormatUint(mallory.ID, 10)}
+	c.graphql(createWorkout, malloryID, nil)
+	var list struct {
+		Workouts []struct {
+			ID string `json:"id"`
+		} `json:"workouts"`
+	}
+	c.graphql(workouts, malloryID, &list)
+	if len(list.Workouts) != 1 {
+		t.Fatalf("mallory has %d workouts, want 1", len(list.Workouts))
+	}
+}
+
+const patchWorkoutMutation = `mutation($id: ID!, $version: Int!, $patch: UpdateWorkoutPatch!) {
+	patch_workout(workout_id: $id, expected_version: $version, patch: $patch) {
+		workout { id kind reps rounds duration_seconds order version }
+		user_errors { field code }
+	}
+}`
+
+type patchedWorkout struct {
+	ID              string `json:"id"`
+	Kind            string `json:"kind"`
+	Reps            int    `json:"reps"`
+	Rounds          int    `json:"rounds"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Order           int    `json:"order"`
+	Version         int    `json:"version"`
+}
+
+type patchWorkoutResult struct {
+	PatchWorkout struct {
+		Workout    *patchedWorkout `json:"workout"`
+		UserErrors []struct {
+			Field string `json:"field"`
+			Code  string `json:"code"`
+		} `json:"user_errors"`
+	} `json:"patch_workout"`
+}
+
+// Fields left out of the patch and fields given as null are both left
+// unchanged
+func TestPatchWorkoutOnlyChangesGivenFields(t *testing.T) {
+	app := newTestApp(t, nil)
+	workout := firstWorkoutOf(t, app, testUserEmail)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	id := strconv.FormatUint(workout.ID, 10)
+
+	patch := func(version int, patch map[string]interface{}) patchWorkoutResult {
+		t.Helper()
+		var result patchWorkoutResult
+		c.graphql(patchWorkoutMutation, map[string]interface{}{"id": id, "version": version, "patch": patch}, &result)
+		return result
+	}
+
+	result := patch(workout.Version, map[string]interface{}{"reps": 42})
+	want := patchedWorkout{
+		ID:              id,
+		Kind:            "PUSH_UPS",
+		Reps:            42,
+		Rounds:          workout.Rounds,
+		DurationSeconds: workout.DurationSeconds,
+		Order:           0,
+		Version:         workout.Version + 1,
+	}
+	if got := result.PatchWorkout.Workout; got == nil || *got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	result = patch(want.Version, map[string]interface{}{"rounds": 5, "reps": nil, "duration_seconds": nil, "kind": nil, "order": nil})
+	want.Rounds = 5
+	want.Version++
+	if got := result.PatchWorkout.Workout; got == nil || *got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	after := firstWorkoutOf(t, app, testUserEmail)
+	if after.Reps != 42 || after.Rounds != 5 || after.DurationSeconds != workout.DurationSeconds || after.Kind != workout.Kind || after.OrderKey != workout.OrderKey {
+		t.Fatalf("stored %+v", after)
+	}
+
+	// An invalid patch changes nothing
+	result = patch(want.Version, map[string]interface{}{"reps": 0, "rounds": 1})
+	if result.PatchWorkout.Workout != nil || len(result.PatchWorkout.UserErrors) != 1 || result.PatchWorkout.UserErrors[0].Field != "reps" {
+		t.Fatalf("got %+v, want a user error on reps", result.PatchWorkout)
+	}
+	if after := firstWorkoutOf(t, app, testUserEmail); after.Reps != 42 || after.Rounds != 5 || after.Version != want.Version {
+		t.Fatalf("stored %+v after an invalid patch", after)
+	}
+}
+
+// Two devices of the same user editing from the same version, the later one
+// is refused with what the earlier one stored
+func TestWorkoutConflictsCarryTheServerState(t *testing.T) {
+	app := newTestApp(t, nil)
+	workout := firstWorkoutOf(t, app, testUserEmail)
+	phone := newTestClient(t, app)
+	graphqlLogin(t, phone, testUserEmail, testUserPassword)
+	laptop := newTestClient(t, app)
+	graphqlLogin(t, laptop, testUserEmail, testUserPassword)
+	id := strconv.FormatUint(workout.ID, 10)
+
+	phone.graphql(patchWorkoutMutation, map[string]interface{}{"id": id, "version": workout.Version, "patch": map[string]interface{}{"reps": 42}}, nil)
+
+	var patched struct {
+		PatchWorkout struct {
+			Workout        *patchedWorkout `json:"workout"`
+			CurrentWorkout *patchedWorkout `json:"current_workout"`
+			UserErrors     []struct {
+				Code string `json:"code"`
+			} `json:"user_errors"`
+		} `json:"patch_workout"`
+	}
+	laptop.graphql(`mutation($id: ID!, $version: Int!) {
+		patch_workout(workout_id: $id, expected_version: $version, patch: {reps: 7}) {
+			workout { id }
+			current_workout { id kind reps rounds duration_seconds order version }
+			user_errors { code }
+		}
+	}`, map[string]interface{}{"id": id, "version": workout.Version}, &patched)
+	payload := patched.PatchWorkout
+	if payload.Workout != nil || len(payload.UserErrors) != 1 || payload.UserErrors[0].Code != string(apperror.CodeConflict) {
+		t.Fatalf("patch_workout: got %+v, want a conflict", payload)
+	}
+	if current := payload.CurrentWorkout; current == nil || current.Reps != 42 || current.Version != workout.Version+1 {
+		t.Fatalf("patch_workout: conflict carries %+v, want the phone's edit", current)
+	}
+
+	var moved struct {
+		MoveWorkout struct {
+			CurrentWorkout *struct {
+				Version int `json:"version"`
+			} `json:"current_workout"`
+			UserErrors []struct {
+				Code string `json:"code"`
+			} `json:"user_errors"`
+		} `json:"move_workout"`
+	}
+	laptop.graphql(`mutation($id: ID!, $version: Int) {
+		move_workout(workout_id: $id, expected_version: $version) { current_workout { version } user_errors { code } }
+	}`, map[string]interface{}{"id": id, "version": workout.Version}, &moved)
+	if moved.MoveWorkout.CurrentWorkout == nil || moved.MoveWorkout.CurrentWorkout.Version != workout.Version+1 {
+		t.Fatalf("move_workout: got %+v, want a conflict at version %d", moved.MoveWorkout, workout.Version+1)
+	}
+
+	if after := firstWorkoutOf(t, app, testUserEmail); after.Reps != 42 || after.Version != workout.Version+1 {
+		t.Fatalf("stored %+v, want only the phone's edit", after)
+	}
+}
+
+func TestReorderWorkoutsChecksTheListVersion(t *testing.T) {
+	app := newTestApp(t, nil)
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	var version struct {
+		WorkoutListVersion int `json:"workout_list_version"`
+	}
+	c.graphql(`{ workout_list_version }`, nil, &version)
+	stale := version.WorkoutListVersion
+	before := workoutOrder(t, c)
+
+	// Adding a workout changes the list
+	c.graphql(`mutation { add_workout(input: {kind: BURPEES, reps: 10, duration_seconds: 60}) { workout { id } } }`, nil, nil)
+	current := workoutOrder(t, c)
+
+	reorder := `mutation($ids: [ID!]!, $version: Int) {
+		reorder_workouts(workoutIdAtRow: $ids, expected_list_version: $version)
+	}`
+	_, body := c.postJSON("/gql/query", map[string]interface{}{
+		"query":     reorder,
+		"variables": map[string]interface{}{"ids": before, "version": stale},
+	})
+	var resp struct {
+		Errors []struct {
+			Extensions struct {
+				Code               string   `json:"code"`
+				CurrentListVersion int      `json:"current_list_version"`
+				CurrentWorkoutIDs  []string `json:"current_workout_ids"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 1 {
+		t.Fatalf("got %s, want a conflict", body)
+	}
+	ext := resp.Errors[0].Extensions
+	if ext.Code != string(apperror.CodeConflict) || ext.CurrentListVersion <= stale || !reflect.DeepEqual(ext.CurrentWorkoutIDs, current) {
+		t.Fatalf("got %s, want a conflict carrying the list %v", body, current)
+	}
+	if after := workoutOrder(t, c); !reflect.DeepEqual(after, current) {
+		t.Fatalf("order changed to %v", after)
+	}
+
+	// Retrying against the current version goes through
+	reversed := make([]string, len(current))
+	for i, id := range current {
+		reversed[len(current)-1-i] = id
+	}
+	c.graphql(reorder, map[string]interface{}{"ids": reversed, "version": ext.CurrentListVersion}, nil)
+	if after := workoutOrder(t, c); !reflect.DeepEqual(after, reversed) {
+		t.Fatalf("got order %v, want %v", after, reversed)
+	}
+}
+
+type workoutListSnapshot struct {
+	workouts    int64
+	changes     int64
+	listVersion int
+}
+
+func snapshotWorkoutList(t *testing.T, app *App, userID uint64) workoutListSnapshot {
+	t.Helper()
+	var s workoutListSnapshot
+	if err := app.DB.Model(&model.Workout{}).Where("user_id = ?", userID).Count(&s.workouts).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := app.DB.Model(&model.ChangeLogEntry{}).Where("user_id = ?", userID).Count(&s.changes).Error; err != nil {
+		t.Fatal(err)
+	}
+	version, err := store.NewWorkoutStore(app.DB).GetWorkoutListVersion(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listVersion = version
+	return s
+}
+
+func TestCreateWorkoutsIsAllOrNothing(t *testing.T) {
+	app := newTestApp(t, nil)
+	janesWorkout := firstWorkoutOf(t, app, testUserEmail)
+	userID := janesWorkout.UserID
+	c := newTestClient(t, app)
+	graphqlLogin(t, c, testUserEmail, testUserPassword)
+	before := snapshotWorkoutList(t, app, userID)
+
+	const createWorkouts = `mutation($inputs: [CreateWorkoutInput!]!) {
+		create_workouts(inputs: $inputs) { workouts { id order } user_errors { field code } }
+	}`
+	var result struct {
+		CreateWorkouts struct {
+			Workouts []struct {
+				ID    string `json:"id"`
+				Order int    `json:"order"`
+			} `json:"workouts"`
+			UserErrors []struct {
+				Field string `json:"field"`
+				Code  string `json:"code"`
+			} `json:"user_errors"`
+		} `json:"create_workouts"`
+	}
+	valid := map[string]interface{}{"kind": "BURPEES", "reps": 10, "duration_seconds": 60}
+	invalid := map[string]interface{}{"kind": "BURPEES", "reps": 0, "duration_seconds": 60}
+	c.graphql(createWorkouts, map[string]interface{}{"inputs": []interface{}{valid, invalid, valid}}, &result)
+	payload := result.CreateWorkouts
+	if len(payload.Workouts) != 0 || len(payload.UserErrors) != 1 || payload.UserErrors[0].Field != "inputs.1.reps" {
+		t.Fatalf("got %+v, want only a user error on inputs.1.reps", payload)
+	}
+	if after := snapshotWorkoutList(t, app, userID); after != before {
+		t.Fatalf("list went from %+v to %+v", before, after)
+	}
+
+	// A failure inside the transaction, after the first workout is inserted
+	taken := "device-1"
+	if err := app.DB.Model(&janesWorkout).UpdateColumn("client_id", taken).Error; err != nil {
+		t.Fatal(err)
+	}
+	before = snapshotWorkoutList(t, app, userID)
+	batch := []*model.Workout{
+		{Kind: model.WorkoutBurpees, Reps: 10, DurationSeconds: 60},
+		{Kind: model.WorkoutBurpees, Reps: 10, DurationSeconds: 60, ClientID: &taken},
+	}
+	err := store.NewWorkoutStore(app.DB).CreateWorkouts(context.Background(), userID, batch)
+	if !errors.Is(err, constants.ErrCodeAlreadyExists) {
+		t.Fatalf("got error %v, want %v", err, constants.ErrCodeAlreadyExists)
+	}
+	if after := snapshotWorkoutList(t, app, userID); after != before {
+		t.Fatalf("list went from %+v to %+v", before, after)
+	}
+
+	// Appended in the given order
+	c.graphql(createWorkouts, map[string]interface{}{"inputs": []interface{}{valid, valid, valid}}, &result)
+	payload = result.CreateWorkouts
+	if len(payload.Workouts) != 3 || len(payload.UserErrors) != 0 {
+		t.Fatalf("got %+v", payload)
+	}
+	for i, w := range payload.Workouts {
+		if w.Order != int(before.workouts)+i {
+			t.Fatalf("workout %d is at %d, want %d", i, w.Order, int(before.workouts)+i)
+		}
+	}
+	if after := snapshotWorkoutList(t, app, userID); after.workouts != before.workouts+3 || after.listVersion != before.listVersion+1 {
+		t.Fatalf("list went from %+v to %+v", before, after)
+	}
+}